@@ -0,0 +1,193 @@
+// Package audit writes an append-only audit log of the changes made by the
+// syncers: snapshots stored and loaded, snapshots removed by the cleaner and
+// entries removed by the sweeper. Every record is a single JSON object, which
+// is written as a line to a file and/or as a message to syslog.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/utils/topics"
+	"github.com/sirupsen/logrus"
+)
+
+// Event types of audit records
+const (
+	EventSnapshotStored  = "snapshot_stored"
+	EventSnapshotLoaded  = "snapshot_loaded"
+	EventSnapshotDeleted = "snapshot_deleted"
+	EventEntriesSwept    = "entries_swept"
+)
+
+// Record is a single audit record. Which fields are set depends on the Event.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	LMDB     string    `json:"lmdb"`
+	Instance string    `json:"instance,omitempty"` // instance that created the snapshot
+	Snapshot string    `json:"snapshot,omitempty"` // full snapshot name in storage
+	TxnID    int64     `json:"txn_id,omitempty"`   // LMDB txn the snapshot was created from or loaded in
+	Size     int64     `json:"size,omitempty"`     // compressed snapshot size
+
+	// Stored snapshots
+	Entries map[string]int64 `json:"entries,omitempty"` // by DBI name
+
+	// Loaded snapshots
+	LocalChanged *bool                      `json:"local_changed,omitempty"`
+	Changes      map[string]events.DBIStats `json:"changes,omitempty"` // by DBI name
+
+	// Deleted snapshots
+	Reason string `json:"reason,omitempty"`
+
+	// Swept entries
+	Cutoff  time.Time      `json:"cutoff,omitzero"`
+	Removed map[string]int `json:"removed,omitempty"` // by DBI name
+}
+
+// Log writes audit records to the configured sinks
+type Log struct {
+	mu     sync.Mutex // protects the sinks
+	file   *os.File
+	syslog *syslog.Writer
+
+	stop    chan struct{}
+	watches []<-chan struct{} // closed when done
+}
+
+// Open opens the sinks configured in c, which must be enabled
+func Open(c config.Audit) (*Log, error) {
+	a := &Log{
+		stop: make(chan struct{}),
+	}
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("audit file: %w", err)
+		}
+		a.file = f
+	}
+	if c.Syslog.Enabled {
+		w, err := syslog.Dial(c.Syslog.Network, c.Syslog.Address,
+			syslog.LOG_INFO|syslog.LOG_DAEMON, c.Syslog.Tag)
+		if err != nil {
+			if a.file != nil {
+				_ = a.file.Close()
+			}
+			return nil, fmt.Errorf("audit syslog: %w", err)
+		}
+		a.syslog = w
+	}
+	logrus.WithFields(logrus.Fields{
+		"file":   c.File,
+		"syslog": c.Syslog.Enabled,
+	}).Info("Audit log enabled")
+	return a, nil
+}
+
+// Write writes a record to all sinks. Errors are logged, because there is
+// nothing the syncer can do about them.
+func (a *Log) Write(r Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		logrus.WithError(err).Error("Audit record marshal failed") // should never happen
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if _, err := a.file.Write(append(data, '\n')); err != nil {
+			logrus.WithError(err).Error("Audit file write failed")
+		}
+	}
+	if a.syslog != nil {
+		if err := a.syslog.Info(string(data)); err != nil {
+			logrus.WithError(err).Error("Audit syslog write failed")
+		}
+	}
+}
+
+// Watch writes records for the events of the syncer of the named LMDB until
+// the Log is closed. The subscriptions are made before Watch returns, so
+// that no events are missed if it is called before the syncer is started.
+func (a *Log) Watch(lmdbName string, ev *events.Events) {
+	watch(a, ev.UpdateStored, func(info events.UpdateInfo) {
+		a.Write(Record{
+			Event:    EventSnapshotStored,
+			LMDB:     lmdbName,
+			Instance: info.NameInfo.InstanceID,
+			Snapshot: info.NameInfo.FullName,
+			TxnID:    info.Meta.LmdbTxnID,
+			Size:     info.Size,
+			Entries:  info.DBIEntries,
+		})
+	})
+	watch(a, ev.UpdateLoaded, func(info events.UpdateInfo) {
+		a.Write(Record{
+			Event:        EventSnapshotLoaded,
+			LMDB:         lmdbName,
+			Instance:     info.NameInfo.InstanceID,
+			Snapshot:     info.NameInfo.FullName,
+			TxnID:        int64(info.TxnID),
+			Size:         info.Size,
+			LocalChanged: &info.LocalChanged,
+			Changes:      info.DBIStats,
+		})
+	})
+	watch(a, ev.SnapshotDeleted, func(info events.DeletedInfo) {
+		a.Write(Record{
+			Event:    EventSnapshotDeleted,
+			LMDB:     lmdbName,
+			Instance: info.NameInfo.InstanceID,
+			Snapshot: info.NameInfo.FullName,
+			Reason:   info.Reason,
+		})
+	})
+	watch(a, ev.EntriesSwept, func(info events.SweepInfo) {
+		a.Write(Record{
+			Event:   EventEntriesSwept,
+			LMDB:    lmdbName,
+			Cutoff:  info.Cutoff.UTC(),
+			Removed: info.Cleaned,
+		})
+	})
+}
+
+// watch calls fn for every value published to the topic until the Log is
+// closed.
+func watch[T any](a *Log, t *topics.Topic[T], fn func(T)) {
+	a.watches = append(a.watches, t.HandleUntil(a.stop, fn))
+}
+
+// Close stops watching events and closes the sinks
+func (a *Log) Close() error {
+	close(a.stop)
+	for _, done := range a.watches {
+		<-done
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	if a.file != nil {
+		err = a.file.Close()
+		a.file = nil
+	}
+	if a.syslog != nil {
+		if serr := a.syslog.Close(); serr != nil && err == nil {
+			err = serr
+		}
+		a.syslog = nil
+	}
+	return err
+}
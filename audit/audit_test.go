@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog_Watch(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := Open(config.Audit{File: fpath})
+	require.NoError(t, err)
+
+	ev := events.New()
+	a.Watch("main", ev)
+
+	ni := snapshot.NameInfo{
+		FullName:   "main__a__20220101-010203-000000000__G-0000000000000001.pb.gz",
+		InstanceID: "a",
+	}
+	ev.UpdateStored.Publish(events.UpdateInfo{
+		NameInfo:   ni,
+		Meta:       snapshot.Meta{LmdbTxnID: 12},
+		Size:       1000,
+		DBIEntries: map[string]int64{"foo": 3},
+	})
+	ev.UpdateLoaded.Publish(events.UpdateInfo{
+		NameInfo:     ni,
+		TxnID:        header.TxnID(34),
+		Size:         1000,
+		LocalChanged: true,
+		DBIStats:     map[string]events.DBIStats{"foo": {Added: 1, Skipped: 2}},
+	})
+	ev.SnapshotDeleted.Publish(events.DeletedInfo{
+		NameInfo: ni,
+		Reason:   "newer snapshot",
+	})
+	cutoff := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	ev.EntriesSwept.Publish(events.SweepInfo{
+		Cutoff:  cutoff,
+		Cleaned: map[string]int{"foo": 5},
+	})
+	require.NoError(t, a.Close())
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		assert.False(t, r.Time.IsZero())
+		r.Time = time.Time{}
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+
+	changed := true
+	// Every topic is handled concurrently, so the order may differ
+	assert.ElementsMatch(t, []Record{
+		{
+			Event:    EventSnapshotStored,
+			LMDB:     "main",
+			Instance: "a",
+			Snapshot: ni.FullName,
+			TxnID:    12,
+			Size:     1000,
+			Entries:  map[string]int64{"foo": 3},
+		},
+		{
+			Event:        EventSnapshotLoaded,
+			LMDB:         "main",
+			Instance:     "a",
+			Snapshot:     ni.FullName,
+			TxnID:        34,
+			Size:         1000,
+			LocalChanged: &changed,
+			Changes:      map[string]events.DBIStats{"foo": {Added: 1, Skipped: 2}},
+		},
+		{
+			Event:    EventSnapshotDeleted,
+			LMDB:     "main",
+			Instance: "a",
+			Snapshot: ni.FullName,
+			Reason:   "newer snapshot",
+		},
+		{
+			Event:   EventEntriesSwept,
+			LMDB:    "main",
+			Cutoff:  cutoff,
+			Removed: map[string]int{"foo": 5},
+		},
+	}, records)
+}
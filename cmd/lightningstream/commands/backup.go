@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/syncer"
+	"github.com/PowerDNS/simpleblob"
+	"github.com/c2h5oh/datasize"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.Flags().StringP("database", "d", "",
+		"Named database to back up")
+	backupCmd.Flags().Bool("snapshot", false,
+		"Store the backup as a snapshot instead of a compressed LMDB data file")
+	backupCmd.Flags().StringP("output", "o", "",
+		"Write the backup to this local directory instead of the storage")
+	backupCmd.Flags().String("tmp-dir", "",
+		"Directory for the temporary copy of the LMDB (default: system temp dir)")
+	_ = backupCmd.MarkFlagRequired("database")
+}
+
+const backupLong = `
+Create a consistent backup of an LMDB while it is in use, and store it in
+the configured storage.
+
+A compacted copy of the LMDB is made with a read transaction, so the
+application and Lightning Stream can keep running. By default, the backup
+is the gzip compressed LMDB data file, which can be restored by
+decompressing it into an empty LMDB directory. With --snapshot, the copy
+is converted into a regular Lightning Stream snapshot.
+
+Backups are stored with a name that starts with 'storage.backup_prefix',
+so that they are never loaded by other instances or removed by the
+cleaner. The name is printed on stdout.
+
+When converting to a snapshot of an LMDB with schema_tracks_changes
+disabled, the shadow DBIs of the copy are first updated with the current
+main DBIs, so that the snapshot contains all local changes. The LMDB itself
+is not modified.
+`
+
+var backupCmd = &cobra.Command{
+	Use:          "backup",
+	Short:        "Store a consistent backup of an LMDB",
+	Long:         backupLong,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbName, err := cmd.Flags().GetString("database")
+		if err != nil {
+			return err
+		}
+		asSnapshot, err := cmd.Flags().GetBool("snapshot")
+		if err != nil {
+			return err
+		}
+		outDir, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		tmpDir, err := cmd.Flags().GetString("tmp-dir")
+		if err != nil {
+			return err
+		}
+
+		s, env, err := newOfflineSyncer(dbName)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = env.Close()
+		}()
+
+		var b *syncer.Backup
+		if outDir != "" {
+			var path string
+			b, err = s.Backup(rootCtx, env, tmpDir, asSnapshot, func(name string) (io.WriteCloser, error) {
+				path = filepath.Join(outDir, name)
+				return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			})
+			if err != nil {
+				if path != "" {
+					_ = os.Remove(path)
+				}
+				return err
+			}
+			logrus.WithFields(logrus.Fields{
+				"db":   dbName,
+				"name": b.Name,
+				"path": path,
+				"size": datasize.ByteSize(b.Size).HumanReadable(),
+			}).Info("Wrote backup")
+		} else {
+			// Only the backend setup has a timeout, the backup of a large
+			// LMDB can take much longer.
+			setupCtx, cancel := context.WithTimeout(rootCtx, time.Minute)
+			defer cancel()
+			st, err := simpleblob.GetBackend(setupCtx, conf.Storage.Type, conf.Storage.Options)
+			if err != nil {
+				return err
+			}
+			var name string
+			b, err = s.Backup(rootCtx, env, tmpDir, asSnapshot, func(n string) (io.WriteCloser, error) {
+				name = n
+				return simpleblob.NewWriter(rootCtx, st, name)
+			})
+			if err != nil {
+				if name != "" {
+					// Do not leave a partial backup behind, even if the
+					// backup failed because rootCtx was cancelled
+					delCtx, delCancel := context.WithTimeout(context.Background(), time.Minute)
+					defer delCancel()
+					if delErr := st.Delete(delCtx, name); delErr != nil && !errors.Is(delErr, os.ErrNotExist) {
+						logrus.WithError(delErr).WithField("name", name).Warn("Failed to remove partial backup")
+					}
+				}
+				return err
+			}
+			logrus.WithFields(logrus.Fields{
+				"db":   dbName,
+				"name": b.Name,
+				"size": datasize.ByteSize(b.Size).HumanReadable(),
+			}).Info("Stored backup")
+		}
+		fmt.Println(b.Name)
+		return nil
+	},
+}
@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/stats"
+	"github.com/PowerDNS/lightningstream/syncer"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+	compactCmd.Flags().StringP("database", "d", "",
+		"Named database to operate on")
+	compactCmd.Flags().StringP("output", "o", "",
+		"Path of the compacted copy (default: data file path with '.compact' suffix)")
+	compactCmd.Flags().Bool("swap", false,
+		"Replace the data file with the compacted copy")
+	compactCmd.Flags().Bool("no-backup", false,
+		"Do not keep the original data file with a '.bak-<time>' suffix when swapping")
+	_ = compactCmd.MarkFlagRequired("database")
+}
+
+const compactLong = `
+Write a compacted copy of an LMDB, without the free pages that a bloated
+data file accumulates over time.
+
+The copy is made with a read transaction, so this can be done while the
+LMDB is in use. By default, the copy is written next to the data file with
+a '.compact' suffix. An existing file at the output path is replaced.
+
+With --swap, the data file is replaced by the compacted copy, and the
+original data file is kept with a '.bak-<time>' suffix, unless --no-backup
+is given. Swapping is only possible while no other process has the LMDB open,
+so close the application before swapping.
+
+A running Lightning Stream can swap in a compacted copy itself, without
+being stopped. Pause loading and sending with the admin API, and then
+request the swap with:
+
+    POST /api/v1/admin/lmdbs/<name>/compact?swap
+
+Note that LMDB resets the transaction ID of a compacted copy to 1.
+`
+
+var compactCmd = &cobra.Command{
+	Use:          "compact",
+	Short:        "Write a compacted copy of an LMDB, and optionally swap it in",
+	Long:         compactLong,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbName, err := cmd.Flags().GetString("database")
+		if err != nil {
+			return err
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		swap, err := cmd.Flags().GetBool("swap")
+		if err != nil {
+			return err
+		}
+		noBackup, err := cmd.Flags().GetBool("no-backup")
+		if err != nil {
+			return err
+		}
+
+		lc, exist := conf.LMDBs[dbName]
+		if !exist {
+			return fmt.Errorf("no LMDB with name %q configured", dbName)
+		}
+		l := logrus.WithField("db", dbName)
+		env, err := syncer.OpenEnv(l, lc)
+		if err != nil {
+			return err
+		}
+		closed := false
+		defer func() {
+			if !closed {
+				_ = env.Close()
+			}
+		}()
+
+		dataPath, err := lmdbenv.DataPath(env)
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			output = dataPath + ".compact"
+		}
+		checkNotInUse := func() error {
+			inUse, err := lmdbenv.InUseByOtherProcess(env)
+			if err != nil {
+				return err
+			}
+			if inUse {
+				return errors.New("the LMDB is open in another process, close " +
+					"the application before swapping, and swap through the " +
+					"admin API if Lightning Stream is running")
+			}
+			return nil
+		}
+		if swap {
+			if err := checkNotInUse(); err != nil {
+				return err
+			}
+		}
+
+		var fl stats.Freelist
+		err = env.View(func(txn *lmdb.Txn) error {
+			fl, err = stats.ReadFreelist(env, txn)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		st, err := os.Stat(dataPath)
+		if err != nil {
+			return err
+		}
+		l.WithFields(logrus.Fields{
+			"data_file":     dataPath,
+			"file_size":     datasize.ByteSize(st.Size()).HumanReadable(),
+			"free_pages":    fl.FreePages,
+			"fragmentation": fmt.Sprintf("%.1f%%", 100*fl.Fragmentation()),
+		}).Info("Writing compacted copy")
+
+		fileMask := lc.Options.WithDefaults().FileMask
+		if err := lmdbenv.CompactCopy(env, output, fileMask); err != nil {
+			return err
+		}
+		cst, err := os.Stat(output)
+		if err != nil {
+			return err
+		}
+		l.WithFields(logrus.Fields{
+			"output":    output,
+			"file_size": datasize.ByteSize(cst.Size()).HumanReadable(),
+		}).Info("Wrote compacted copy")
+
+		if !swap {
+			return nil
+		}
+
+		// Check again right before we close the env
+		if err := checkNotInUse(); err != nil {
+			return err
+		}
+		closed = true
+		if err := env.Close(); err != nil {
+			return err
+		}
+		backupPath := lmdbenv.BackupPath(dataPath, time.Now())
+		if noBackup {
+			backupPath = ""
+		}
+		if err := lmdbenv.SwapDataFile(dataPath, output, backupPath); err != nil {
+			return err
+		}
+		l.WithFields(logrus.Fields{
+			"data_file": dataPath,
+			"backup":    backupPath,
+		}).Info("Swapped in compacted copy")
+		return nil
+	},
+}
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/snapshot/ndjson"
+	"github.com/PowerDNS/lightningstream/syncer"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringP("database", "d", "",
+		"Named database to export from")
+	exportCmd.Flags().StringP("snapshot", "s", "",
+		"Export a snapshot instead of a database")
+	exportCmd.Flags().BoolP("local", "l", false,
+		"The snapshot is a local file instead of a remote snapshot")
+	exportCmd.Flags().String("dbi", "", "Only export DBI with this exact name")
+	exportCmd.Flags().StringP("output", "o", "",
+		"Output filename (default: stdout)")
+}
+
+const exportLong = `
+Export the contents of a configured LMDB or of a snapshot as NDJSON.
+
+Every line is a JSON object describing a single entry:
+
+    {"dbi":"records","key":"YQ==","value":"eAB5","timestamp":1700000000000000000,"flags":1,"dbi_flags":8}
+
+Keys and values are base64 encoded. The timestamp is in nanoseconds since the
+UNIX epoch and the flags are the Lightning Stream header flags, where 1 means
+that the entry was deleted. Fields with zero values are omitted. A line without
+a key declares an empty DBI.
+
+When exporting an LMDB with schema_tracks_changes disabled, the shadow DBIs
+are exported. These reflect the state of the main DBIs as of the last sync.
+
+The output can be loaded again with the 'import' command.
+`
+
+var exportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "Export an LMDB or snapshot as NDJSON",
+	Long:         exportLong,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbName, err := cmd.Flags().GetString("database")
+		if err != nil {
+			return err
+		}
+		snapName, err := cmd.Flags().GetString("snapshot")
+		if err != nil {
+			return err
+		}
+		local, err := cmd.Flags().GetBool("local")
+		if err != nil {
+			return err
+		}
+		dbiName, err := cmd.Flags().GetString("dbi")
+		if err != nil {
+			return err
+		}
+		outName, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		if (dbName == "") == (snapName == "") {
+			return fmt.Errorf("exactly one of --database or --snapshot is required")
+		}
+
+		var snap *snapshot.Snapshot
+		if snapName != "" {
+			snap, err = loadSnapshotFile(rootCtx, snapName, local)
+		} else {
+			snap, err = readLMDBSnapshot(rootCtx, dbName)
+		}
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		if outName != "" {
+			f, err := os.Create(outName)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			out = f
+		}
+		// Buffered output speeds things up
+		bw := bufio.NewWriter(out)
+		if err := ndjson.NewWriter(bw).WriteSnapshot(snap, dbiName); err != nil {
+			return err
+		}
+		return bw.Flush()
+	},
+}
+
+// loadSnapshotFile loads a snapshot from a local file or from remote storage.
+func loadSnapshotFile(ctx context.Context, name string, local bool) (*snapshot.Snapshot, error) {
+	var data []byte
+	var err error
+	if local {
+		data, err = os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		st, err := simpleblob.GetBackend(ctx, conf.Storage.Type, conf.Storage.Options)
+		if err != nil {
+			return nil, err
+		}
+		data, err = st.Load(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshot.LoadData(data)
+}
+
+// newOfflineSyncer opens a configured LMDB and creates a syncer for it that
+// is not connected to a storage backend, for commands that operate on the
+// LMDB directly.
+// The caller must close the returned env.
+func newOfflineSyncer(dbName string) (*syncer.Syncer, *lmdb.Env, error) {
+	lc, exist := conf.LMDBs[dbName]
+	if !exist {
+		return nil, nil, fmt.Errorf("no LMDB with name %q configured", dbName)
+	}
+	env, err := syncer.OpenEnv(logrus.WithField("db", dbName), lc)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := syncer.New(dbName, env, nil, conf, lc, syncer.Options{})
+	if err != nil {
+		_ = env.Close()
+		return nil, nil, err
+	}
+	return s, env, nil
+}
+
+// readLMDBSnapshot reads the contents of a configured LMDB as a snapshot.
+func readLMDBSnapshot(ctx context.Context, dbName string) (*snapshot.Snapshot, error) {
+	s, env, err := newOfflineSyncer(dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = env.Close()
+	}()
+	return s.ReadSnapshot(ctx, env)
+}
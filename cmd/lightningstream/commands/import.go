@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/snapshot/ndjson"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringP("database", "d", "",
+		"Named database to import into")
+	importCmd.Flags().StringP("snapshot-output", "s", "",
+		"Write a local snapshot file instead of importing into a database")
+	importCmd.Flags().String("input", "",
+		"Input filename (default: stdin)")
+}
+
+const importLong = `
+Import NDJSON as produced by the 'export' command into a configured LMDB,
+or convert it into a local snapshot file.
+
+Entries are merged into the LMDB the same way as a remote snapshot would be:
+an entry only replaces an existing one if its timestamp is newer. Entries
+without a timestamp get the current time. Entries with the deleted flag set
+delete existing entries with an older timestamp.
+
+The LMDB must not be in use by a running syncer, as it would not be able to
+detect the changes made by the import when schema_tracks_changes is disabled.
+
+A snapshot file written with --snapshot-output can be uploaded with
+'snapshots put'.
+`
+
+var importCmd = &cobra.Command{
+	Use:          "import",
+	Short:        "Import NDJSON into an LMDB or snapshot",
+	Long:         importLong,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbName, err := cmd.Flags().GetString("database")
+		if err != nil {
+			return err
+		}
+		snapOut, err := cmd.Flags().GetString("snapshot-output")
+		if err != nil {
+			return err
+		}
+		inName, err := cmd.Flags().GetString("input")
+		if err != nil {
+			return err
+		}
+		if (dbName == "") == (snapOut == "") {
+			return fmt.Errorf("exactly one of --database or --snapshot-output is required")
+		}
+
+		var in io.Reader = os.Stdin
+		if inName != "" {
+			f, err := os.Open(inName)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			in = f
+		}
+
+		now := time.Now()
+		dbis, err := ndjson.Read(bufio.NewReader(in), ndjson.ReadOptions{
+			DefaultTimestamp: uint64(header.TimestampFromTime(now)),
+		})
+		if err != nil {
+			return err
+		}
+
+		snap := &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.WriteCompatFormatVersion,
+			Databases:     dbis,
+		}
+		snap.Meta.TimestampNano = uint64(header.TimestampFromTime(now))
+
+		if snapOut != "" {
+			out, _, err := snapshot.DumpData(snap)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(snapOut, out, 0666)
+		}
+
+		s, env, err := newOfflineSyncer(dbName)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = env.Close()
+		}()
+
+		update := snapshot.Update{
+			Snapshot: snap,
+			NameInfo: snapshot.NameInfo{
+				Kind:      "import",
+				Timestamp: now,
+			},
+		}
+		// A lastTxnID of 0 makes sure that any local changes are first
+		// synced to the shadow DBIs, if used.
+		txnID, _, err := s.LoadOnce(rootCtx, env, "import", update, 0)
+		if err != nil {
+			return err
+		}
+		logrus.WithField("txnID", txnID).Info("Import complete")
+		return nil
+	},
+}
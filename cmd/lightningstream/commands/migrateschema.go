@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(migrateSchemaCmd)
+	migrateSchemaCmd.Flags().StringP("database", "d", "",
+		"Named database to operate on")
+	migrateSchemaCmd.Flags().String("to", "",
+		"Target schema, one of: 'native', 'shadow'")
+	migrateSchemaCmd.Flags().Bool("dry-run", false,
+		"Only report what would be changed, do not change the LMDB")
+	_ = migrateSchemaCmd.MarkFlagRequired("database")
+	_ = migrateSchemaCmd.MarkFlagRequired("to")
+}
+
+const migrateSchemaLong = `
+Convert an LMDB between the shadow schema (schema_tracks_changes disabled)
+and the native schema (schema_tracks_changes enabled) in place.
+
+Converting to 'native' moves the timestamps and deletion markers from the
+_sync_shadow_* DBIs into native headers in the main DBIs, and removes the
+shadow DBIs. Changes to the main DBIs that were not synced to the shadow DBIs
+yet get the current time as timestamp.
+
+Converting to 'shadow' moves the native headers into new _sync_shadow_* DBIs
+and removes the headers and deleted entries from the main DBIs.
+
+The migration is performed in a single write transaction. Stop Lightning
+Stream and any application using the LMDB before running this, and update
+schema_tracks_changes in the configuration afterwards. Until then, it must
+reflect the current schema: the migration is refused if the configuration or
+the contents of the LMDB show that it already uses the target schema.
+DupSort DBIs can only be migrated with dupsort_set enabled.
+
+Use --dry-run to get a report of the entries that would be migrated.
+`
+
+var migrateSchemaCmd = &cobra.Command{
+	Use:          "migrate-schema",
+	Short:        "Convert an LMDB between shadow and native schema",
+	Long:         migrateSchemaLong,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbName, err := cmd.Flags().GetString("database")
+		if err != nil {
+			return err
+		}
+		to, err := cmd.Flags().GetString("to")
+		if err != nil {
+			return err
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		var toNative bool
+		switch to {
+		case "native":
+			toNative = true
+		case "shadow":
+			toNative = false
+		default:
+			return fmt.Errorf("invalid target schema %q, must be 'native' or 'shadow'", to)
+		}
+
+		s, env, err := newOfflineSyncer(dbName)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = env.Close()
+		}()
+
+		stats, err := s.MigrateSchema(rootCtx, env, toNative, dryRun)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "DBI\tENTRIES\tDELETED\n")
+		for _, st := range stats {
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%d\n", st.DBI, st.Entries, st.Deleted)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if dryRun {
+			logrus.Info("Dry run, no changes were made")
+			return nil
+		}
+		logrus.Warnf("Migration complete, you MUST now set schema_tracks_changes "+
+			"to %v for database %q in the configuration", toNative, dbName)
+		return nil
+	},
+}
@@ -62,6 +62,18 @@ func statsForLMDB(name string, lc config.LMDB) error {
 			datasize.ByteSize(info.MapSize).HumanReadable(),
 			usedPct)
 
+		fl, err := stats.ReadFreelist(env, txn)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: Freelist: %d pages (%s) in %d entries, %.1f %% of %d used pages\n",
+			name,
+			fl.FreePages,
+			datasize.ByteSize(fl.FreeBytes()).HumanReadable(),
+			fl.Entries,
+			100*fl.Fragmentation(),
+			fl.UsedPages)
+
 		return nil
 	})
 	return err
@@ -5,10 +5,17 @@ import (
 	"errors"
 	"os"
 	"slices"
+	"time"
 
+	"github.com/PowerDNS/lightningstream/audit"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/notify"
+	"github.com/PowerDNS/lightningstream/reload"
 	"github.com/PowerDNS/lightningstream/snapshot/storage"
 	"github.com/PowerDNS/lightningstream/status"
 	"github.com/PowerDNS/lightningstream/syncer"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/simpleblob"
 	"github.com/sirupsen/logrus"
@@ -38,10 +45,54 @@ func runSync(receiveOnly bool) error {
 		conf.OnlyOnce = true
 	}
 
+	shutdownTracing, err := tracing.Setup(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Flush any pending spans, ctx is already cancelled at this point
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		if err := shutdownTracing(sctx); err != nil {
+			logrus.WithError(err).Warn("Failed to flush traces")
+		}
+	}()
+
+	var auditLog *audit.Log
+	if conf.Audit.Enabled() {
+		auditLog, err = audit.Open(conf.Audit)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				logrus.WithError(err).Warn("Failed to close audit log")
+			}
+		}()
+	}
+
+	var notifier *notify.Notifier
+	if conf.Notify.Enabled() {
+		notifier, err = notify.New(conf)
+		if err != nil {
+			return err
+		}
+		defer notifier.Close()
+	}
+
+	var reloader *reload.Reloader
+	if conf.Reload.Enabled() {
+		reloader = reload.New(conf)
+		defer reloader.Close()
+	}
+
 	st, err := simpleblob.GetBackend(ctx, conf.Storage.Type, conf.Storage.Options)
 	if err != nil {
 		return err
 	}
+	if conf.Tracing.Endpoint != "" {
+		st = tracing.WrapStorage(st)
+	}
 	logrus.WithField("storage_type", conf.Storage.Type).Info("Storage backend initialised")
 	storage.SetGlobal(st)
 	status.SetStorage(st)
@@ -78,6 +129,17 @@ func runSync(receiveOnly bool) error {
 			}
 		}
 
+		// Install a seed image before we open the LMDB for syncing, if
+		// enabled and the LMDB is still empty.
+		if lc.Seed.Bootstrap {
+			if _, err := syncer.BootstrapSeed(ctx, l, st, name, lc); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				l.WithError(err).Error("Bootstrap from seed image failed, loading snapshots instead")
+			}
+		}
+
 		env, err := syncer.OpenEnv(l, lc)
 		if err != nil {
 			return err
@@ -89,6 +151,19 @@ func runSync(receiveOnly bool) error {
 		if SyncerOptionsCallback != nil {
 			opt = SyncerOptionsCallback(opt, l)
 		}
+		needEvents := auditLog != nil || notifier != nil || reloader != nil
+		if needEvents && opt.Events == nil {
+			opt.Events = events.New()
+		}
+		if auditLog != nil {
+			auditLog.Watch(name, opt.Events)
+		}
+		if notifier != nil {
+			notifier.Watch(name, opt.Events)
+		}
+		if reloader != nil {
+			reloader.Watch(name, env, opt.Events)
+		}
 
 		s, err := syncer.New(name, env, st, conf, lc, opt)
 		if err != nil {
@@ -97,7 +172,12 @@ func runSync(receiveOnly bool) error {
 
 		eg.Go(func() error {
 			defer func() {
-				if err := env.Close(); err != nil {
+				if reloader != nil {
+					reloader.Forget(name)
+				}
+				// The syncer replaces the env when it swaps in a
+				// compacted copy
+				if err := lmdbenv.Close(s.Env()); err != nil {
 					l.WithError(err).Error("Env close failed")
 				}
 			}()
@@ -120,7 +200,9 @@ func runSync(receiveOnly bool) error {
 	healthz.SetMeta("version", version)
 
 	if !conf.OnlyOnce {
-		status.StartHTTPServer(conf)
+		if err := status.StartHTTPServer(ctx, conf); err != nil {
+			return err
+		}
 	} else {
 		logrus.Info("Not starting the HTTP server, because OnlyOnce is set")
 	}
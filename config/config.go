@@ -4,10 +4,14 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/PowerDNS/go-tlsconfig"
 	"github.com/PowerDNS/lightningstream/lmdbenv/dbiflags"
+	"github.com/c2h5oh/datasize"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
@@ -49,6 +53,12 @@ const (
 	// DefaultMemoryDecompressedSnapshots is the number of decompressed snapshots
 	// we can keep in memory.
 	DefaultMemoryDecompressedSnapshots = 3
+
+	// DefaultSnapshotWorkers is the number of workers used to create a snapshot
+	DefaultSnapshotWorkers = 1
+
+	// DefaultBackupPrefix is the default prefix for backups in the storage
+	DefaultBackupPrefix = "backup-"
 )
 
 var (
@@ -108,6 +118,10 @@ type Config struct {
 	HTTP     HTTP            `yaml:"http"`
 	Log      logger.Config   `yaml:"log"`
 	Health   Health          `yaml:"health"`
+	Tracing  Tracing         `yaml:"tracing"`
+	Audit    Audit           `yaml:"audit"`
+	Notify   Notify          `yaml:"notify"`
+	Reload   Reload          `yaml:"reload"`
 
 	// LMDBPollInterval is the minimum time between checking for new LMDB
 	// transactions. The check itself is fast, but this also serves to rate limit
@@ -157,6 +171,15 @@ type Config struct {
 	// Increasing this can speed up processing at the cost of memory.
 	MemoryDecompressedSnapshots int `yaml:"memory_decompressed_snapshots"`
 
+	// SnapshotWorkers is the maximum number of concurrent workers used to
+	// create a snapshot for each database (minimum: 1, default: 1).
+	// With more than one worker, the snapshot is compressed in parallel, and
+	// when schema_tracks_changes is enabled, the DBIs are also read in
+	// parallel using multiple read transactions.
+	// The same number of workers is used to merge remote snapshots with
+	// read transactions before the write lock is acquired.
+	SnapshotWorkers int `yaml:"snapshot_workers"`
+
 	// LMDBScrapeSmaps enabled the scraping of /proc/smaps for LMDB stats
 	LMDBScrapeSmaps bool `yaml:"lmdb_scrape_smaps"`
 
@@ -177,6 +200,12 @@ type LMDB struct {
 	// Per-DBI options
 	DBIOptions map[string]DBIOptions `yaml:"dbi_options"`
 
+	// MapSizeGrowth configures automatic growth of the LMDB map size
+	MapSizeGrowth MapSizeGrowth `yaml:"map_size_growth"`
+
+	// Seed configures seed images to bootstrap new instances
+	Seed Seed `yaml:"seed"`
+
 	// Both important and dangerous: set to true if the LMDB schema already tracks
 	// changes in the exact way that this tool expects. This includes:
 	// - Every value is prefixed with an 24+ byte LS header.
@@ -191,10 +220,98 @@ type LMDB struct {
 	// Not compatible with schema_tracks_changes=true
 	DupSortHack bool `yaml:"dupsort_hack"`
 
+	// Enables support for DupSort DBs by storing every key with the set of
+	// its values, each with their own timestamp and deletion marker.
+	// This will be applied to all dbs marked as DupSort, and supports any key
+	// and value size. Compatible with schema_tracks_changes=true, in which
+	// case every value of a DupSort key is prefixed with an LS header.
+	// All instances must use the same DupSort option.
+	// Not compatible with dupsort_hack=true.
+	DupSortSet bool `yaml:"dupsort_set"`
+
+	// IncrementalShadowSync merges the main DBIs into the shadow DBIs by
+	// walking both with a cursor, instead of first creating a full copy of
+	// every main DBI. Entries that are the same in both are skipped with a
+	// byte comparison without copying them, and only entries that changed
+	// are merged and written. Every entry is still read on every sync.
+	// DupSort DBIs always use a full copy.
+	// Only used when schema_tracks_changes is disabled.
+	IncrementalShadowSync bool `yaml:"incremental_shadow_sync"`
+
+	// LoadLockDuration limits how long a single write transaction may take
+	// when loading a remote snapshot. If set, large snapshots are applied in
+	// several write transactions, similar to the sweeper LockDuration, to
+	// limit the latency spike for application writes.
+	// This is not a hard quota, a load may overrun it slightly.
+	// When schema_tracks_changes is disabled, the main and shadow DBIs are
+	// updated together for every merged key, and DupSort DBIs are not
+	// supported.
+	// Default: 0 (disabled, a snapshot is loaded in a single transaction)
+	LoadLockDuration time.Duration `yaml:"load_lock_duration"`
+
+	// LoadReleaseDuration determines how long to wait before the next write
+	// transaction of a snapshot load that was split by LoadLockDuration.
+	// Default: equal to LoadLockDuration
+	LoadReleaseDuration time.Duration `yaml:"load_release_duration"`
+
+	// LoadBatchSize is the maximum number of ready remote updates that are
+	// merged together in a single write transaction. Batching reduces the
+	// number of write lock acquisitions and shadow DBI syncs when many
+	// instances publish snapshots at the same time.
+	// Not used when LoadLockDuration is set.
+	// Default: 1 (every update is loaded in its own transaction)
+	LoadBatchSize int `yaml:"load_batch_size"`
+
+	// PrepareLoad configures merging remote snapshots with read transactions
+	// before the write lock is acquired.
+	PrepareLoad PrepareLoad `yaml:"prepare_load"`
+
+	// SkipUnchangedDBIs skips loading a snapshot DBI if its digest is the
+	// same as in the last snapshot we loaded from the same instance. Merging
+	// the same DBI again would normally not result in any changes, so this
+	// saves a lot of work when instances only change a few DBIs.
+	// Only snapshots written by a version that records DBI digests can be
+	// skipped. The digests are only kept in memory, so after a restart all
+	// DBIs are loaded once.
+	SkipUnchangedDBIs bool `yaml:"skip_unchanged_dbis"`
+
 	// HeaderExtraPaddingBlock adds an extra 8 all-zero bytes to the LS header
 	// to make it 32 bytes. This is useful to test an application's handling of
 	// the numExtra header field. This does not apply to shadow tables.
 	HeaderExtraPaddingBlock bool `yaml:"header_extra_padding_block"`
+
+	// SchemaVersion is the version of the application schema of the data in
+	// this LMDB. It is recorded in the snapshots, and used to select the
+	// Rewrites to apply to snapshots written by instances running another
+	// schema version. A value of 0 means unversioned: no rewrites are applied.
+	SchemaVersion uint32 `yaml:"schema_version"`
+
+	// Rewrites are applied to snapshot DBIs written with a different
+	// SchemaVersion when they are loaded, to allow mixed-version clusters
+	// during rolling upgrades.
+	Rewrites []Rewrite `yaml:"rewrites"`
+}
+
+// Rewrite describes how a snapshot DBI written with schema version FromVersion
+// must be changed to be compatible with schema version ToVersion.
+// Rewrites are applied step by step, following the FromVersion and ToVersion
+// of the rules, until the local schema version is reached. This works both
+// for upgrades and downgrades.
+type Rewrite struct {
+	DBI         string `yaml:"dbi"`          // Name of the DBI in the snapshot
+	FromVersion uint32 `yaml:"from_version"` // Schema version of the snapshot
+	ToVersion   uint32 `yaml:"to_version"`   // Schema version after the rewrite
+
+	// Func is the name of a registered rewrite function to apply to all
+	// entries. Rewrite functions are registered in Go code, see
+	// snapshot.RegisterRewrite.
+	Func string `yaml:"func"`
+
+	// RenameTo renames the DBI.
+	RenameTo string `yaml:"rename_to"`
+
+	// Drop removes the DBI from the snapshot, so that it will not be loaded.
+	Drop bool `yaml:"drop"`
 }
 
 // Sweeper settings for the LMDB sweeper that removed deleted entries after
@@ -289,6 +406,83 @@ func (sw Sweeper) RetentionDurationMinusCutoff() time.Duration {
 	return retention
 }
 
+// MapSizeGrowth configures automatic growth of the LMDB map size when a
+// snapshot load or a local snapshot fails with MDB_MAP_FULL.
+// Other processes that have the LMDB open must handle MDB_MAP_RESIZED
+// errors by adopting the new map size.
+type MapSizeGrowth struct {
+	// Factor is the factor to multiply the map size with when it needs to
+	// grow. It must be larger than 1. Growth is disabled when not set.
+	Factor float64 `yaml:"factor"`
+
+	// Max is the maximum map size to grow to. Required if Factor is set.
+	Max datasize.ByteSize `yaml:"max"`
+
+	// Threshold is the fraction of the map size that can be in use before
+	// the map size is proactively grown before a snapshot load.
+	// Default: 0 (only grow when MDB_MAP_FULL is returned)
+	Threshold float64 `yaml:"threshold"`
+}
+
+// Enabled returns true if map size growth is enabled
+func (g MapSizeGrowth) Enabled() bool {
+	return g.Factor > 1
+}
+
+// Seed configures seed images: compacted copies of the LMDB data file that
+// a new instance with an empty LMDB can install directly, instead of loading
+// the latest snapshot of every instance. After installing a seed image, the
+// instance catches up by loading snapshots as usual.
+// All instances must use the same LMDB schema and schema_tracks_changes
+// setting as the instance that published the seed image.
+type Seed struct {
+	// PublishInterval enables publishing a seed image at this interval, once
+	// all snapshots have been loaded after startup. Only set this on one or
+	// a few designated instances.
+	// Default: 0 (disabled)
+	PublishInterval time.Duration `yaml:"publish_interval"`
+
+	// Keep is the number of seed images of this instance to keep in storage
+	// after publishing a new one.
+	// Default: 2
+	Keep int `yaml:"keep"`
+
+	// Bootstrap installs the latest seed image at startup if the LMDB is empty
+	// and not opened by any other process.
+	Bootstrap bool `yaml:"bootstrap"`
+}
+
+// KeepCount returns the number of seed images to keep
+func (s Seed) KeepCount() int {
+	if s.Keep <= 0 {
+		return 2
+	}
+	return s.Keep
+}
+
+// PrepareLoad configures preparing loads: remote snapshots are merged with
+// the current LMDB contents using read transactions before the write lock is
+// acquired, so that the write transaction only needs to apply the resulting
+// changes. The prepared changes are held in memory until they are applied.
+type PrepareLoad struct {
+	// Disabled merges remote snapshots in the write transaction instead.
+	Disabled bool `yaml:"disabled"`
+
+	// MaxMemory limits the total size of the keys and values of the changes
+	// prepared for a single load. DBIs whose changes do not fit are merged
+	// in the write transaction instead.
+	// Default: 256 MB
+	MaxMemory datasize.ByteSize `yaml:"max_memory"`
+}
+
+// MaxMemoryBytes returns the memory limit for prepared changes
+func (p PrepareLoad) MaxMemoryBytes() int64 {
+	if p.MaxMemory == 0 {
+		return int64(256 * datasize.MB)
+	}
+	return int64(p.MaxMemory)
+}
+
 type DBIOptions struct {
 	// OverrideCreateFlags can override DBI create flags when loading a
 	// snapshot and the DBI does not create yet.
@@ -307,6 +501,12 @@ type Storage struct {
 	// FIXME: Configure per LMDB instead, since we run a cleaner per LMDB?
 	Cleanup Cleanup `yaml:"cleanup"`
 
+	// BackupPrefix is prepended to the names of backups stored by the
+	// backup command, to keep them apart from the snapshots. Backups are
+	// never loaded or cleaned by the syncer.
+	// Default: "backup-"
+	BackupPrefix string `yaml:"backup_prefix"`
+
 	RootPath string `yaml:"root_path,omitempty"` // Deprecated: use options.root_path for fs
 }
 
@@ -339,6 +539,163 @@ type Cleanup struct {
 // HTTP configures the HTTP server with Prometheus metrics and status page
 type HTTP struct {
 	Address string `yaml:"address"` // Address like ":8000"
+
+	// AdminToken enables the admin API under /api/v1/admin/ for runtime
+	// control of the syncers. Requests must include this token in an
+	// "Authorization: Bearer <token>" header.
+	// Default: empty (admin API disabled)
+	AdminToken string `yaml:"admin_token"`
+
+	// AdminClientCert enables the admin API for clients with a client
+	// certificate verified by the TLS config, which requires
+	// tls.require_client_cert. When the AdminToken is also set, requests
+	// need both.
+	AdminClientCert bool `yaml:"admin_client_cert"`
+
+	// AdminClientSubjects restricts the admin API to client certificates
+	// with one of these subject common names or full subjects, like
+	// "CN=admin,O=Example". Default: empty (any verified client certificate)
+	AdminClientSubjects []string `yaml:"admin_client_subjects"`
+
+	// TLS enables HTTPS when a cert and key are configured. Certificate
+	// files are reloaded on change when watch_certs is set. Client
+	// certificates signed by the configured CA are required when
+	// require_client_cert is set.
+	TLS tlsconfig.Config `yaml:"tls"`
+
+	// BasicAuth requires HTTP basic authentication for all endpoints,
+	// except for the admin API, which uses its own authentication.
+	BasicAuth BasicAuth `yaml:"basic_auth"`
+}
+
+// AdminEnabled returns true if the admin API is enabled
+func (h HTTP) AdminEnabled() bool {
+	return h.AdminToken != "" || h.AdminClientCert
+}
+
+// BasicAuth configures HTTP basic authentication
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Enabled returns true if basic authentication is configured
+func (b BasicAuth) Enabled() bool {
+	return b.Username != "" || b.Password != ""
+}
+
+// Tracing configures the export of OpenTelemetry traces of sync cycles and
+// storage operations to an OTLP collector over HTTP.
+type Tracing struct {
+	// Endpoint is the host and port of the OTLP HTTP collector, like
+	// "localhost:4318". Tracing is disabled when empty.
+	Endpoint string `yaml:"endpoint"`
+	// URLPath overrides the default "/v1/traces" path
+	URLPath string `yaml:"url_path"`
+	// Insecure uses plain HTTP instead of HTTPS
+	Insecure bool `yaml:"insecure"`
+	// Headers are sent with every export request, e.g. for authentication
+	Headers map[string]string `yaml:"headers"`
+	// SampleRatio is the fraction of traces to record, between 0 and 1.
+	// Default: 1 (all traces)
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Audit configures an append-only audit log of the snapshots stored and
+// loaded, the snapshots removed by the cleaner and the entries removed by the
+// sweeper. Records can be written to a file, syslog, or both.
+type Audit struct {
+	// File is the path of a JSON lines file to append records to
+	File string `yaml:"file"`
+	// Syslog sends every record as a JSON message to syslog
+	Syslog AuditSyslog `yaml:"syslog"`
+}
+
+// Enabled returns true if any audit sink is configured
+func (a Audit) Enabled() bool {
+	return a.File != "" || a.Syslog.Enabled
+}
+
+// AuditSyslog configures the syslog audit sink
+type AuditSyslog struct {
+	Enabled bool `yaml:"enabled"`
+	// Network and Address of a remote syslog server, like "udp" and
+	// "loghost:514". The local syslog daemon is used when empty.
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// Tag of the messages. Default: "lightningstream"
+	Tag string `yaml:"tag"`
+}
+
+// Notify configures webhooks and commands that are called on sync events,
+// like a cache purge after a snapshot was loaded.
+type Notify struct {
+	Webhooks []Webhook       `yaml:"webhooks"`
+	Commands []NotifyCommand `yaml:"commands"`
+	// Timeout of a single webhook request or command
+	Timeout time.Duration `yaml:"timeout"`
+	// QueueSize is the number of events that can be queued per webhook or
+	// command. Events are dropped when the queue is full.
+	QueueSize int `yaml:"queue_size"`
+	// HealthInterval is how often the health trackers are checked for
+	// state changes
+	HealthInterval time.Duration `yaml:"health_interval"`
+}
+
+// Enabled returns true if any webhook or command is configured
+func (n Notify) Enabled() bool {
+	return len(n.Webhooks) > 0 || len(n.Commands) > 0
+}
+
+// Webhook receives events as a JSON POST request
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Headers are sent with every request, e.g. for authentication
+	Headers map[string]string `yaml:"headers"`
+	// Events to send, all if empty
+	Events []string `yaml:"events"`
+}
+
+// NotifyCommand is a local command that receives the event JSON on stdin
+type NotifyCommand struct {
+	// Command and its arguments, not interpreted by a shell
+	Command []string `yaml:"command"`
+	// Events to run the command for, all if empty
+	Events []string `yaml:"events"`
+}
+
+// Reload configures an action that is triggered after loads of remote
+// snapshots changed the LMDBs, e.g. to let an application flush its caches.
+// It receives the changed keys per DBI as JSON. Consecutive loads are
+// combined into a single trigger.
+type Reload struct {
+	// Command and its arguments that receives the JSON on stdin, not
+	// interpreted by a shell
+	Command []string `yaml:"command"`
+	// URL that receives the JSON as a POST request
+	URL string `yaml:"url"`
+	// Headers are sent with every request to the URL
+	Headers map[string]string `yaml:"headers"`
+	// Socket is the path of a Unix stream socket that receives the JSON as a
+	// single line
+	Socket string `yaml:"socket"`
+	// Debounce is how long to wait for more loads before triggering
+	Debounce time.Duration `yaml:"debounce"`
+	// MaxDelay limits how long a trigger can be postponed by new loads
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// MaxKeys is the maximum number of changed keys included per DBI. If more
+	// keys changed, the DBI is marked as truncated.
+	MaxKeys int `yaml:"max_keys"`
+	// PowerDNSZones decodes the names of the affected zones from the keys of
+	// the PowerDNS Auth LMDB backend (schema version 5)
+	PowerDNSZones bool `yaml:"powerdns_zones"`
+	// Timeout of the command, request or socket write
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Enabled returns true if any reload action is configured
+func (r Reload) Enabled() bool {
+	return len(r.Command) > 0 || r.URL != "" || r.Socket != ""
 }
 
 // Health configures the healthz error & warn thresholds
@@ -373,12 +730,141 @@ func (c Config) Check() error {
 		if l.SchemaTracksChanges && l.DupSortHack {
 			return fmt.Errorf("lmdb.schema_tracks_changes: cannot be used together with the dupsort_hack option")
 		}
+		if l.DupSortHack && l.DupSortSet {
+			return fmt.Errorf("lmdb.dupsort_set: cannot be used together with the dupsort_hack option")
+		}
+		if l.LoadLockDuration > 0 && !l.SchemaTracksChanges && (l.DupSortHack || l.DupSortSet) {
+			return fmt.Errorf("lmdb.load_lock_duration: DupSort DBIs are not supported without schema_tracks_changes")
+		}
+		if l.LoadLockDuration < 0 || l.LoadReleaseDuration < 0 {
+			return fmt.Errorf("lmdb.load_lock_duration: negative durations not allowed")
+		}
+		if l.LoadBatchSize < 0 {
+			return fmt.Errorf("lmdb.load_batch_size: must not be negative")
+		}
+		if g := l.MapSizeGrowth; g.Factor != 0 {
+			if g.Factor <= 1 {
+				return fmt.Errorf("lmdb.map_size_growth.factor: must be larger than 1")
+			}
+			if g.Max == 0 {
+				return fmt.Errorf("lmdb.map_size_growth.max: required when factor is set")
+			}
+			if g.Threshold < 0 || g.Threshold >= 1 {
+				return fmt.Errorf("lmdb.map_size_growth.threshold: must be between 0 and 1")
+			}
+		}
+		if l.Seed.PublishInterval < 0 {
+			return fmt.Errorf("lmdb.seed.publish_interval: negative durations not allowed")
+		}
+		if l.Seed.Keep < 0 {
+			return fmt.Errorf("lmdb.seed.keep: must not be negative")
+		}
+		for i, rw := range l.Rewrites {
+			rwPrefix := fmt.Sprintf("%s: rewrites[%d]", prefix, i)
+			if rw.DBI == "" {
+				return fmt.Errorf("%s: no dbi configured", rwPrefix)
+			}
+			if rw.FromVersion == 0 || rw.ToVersion == 0 || rw.FromVersion == rw.ToVersion {
+				return fmt.Errorf("%s: from_version and to_version must be different non-zero versions", rwPrefix)
+			}
+			if rw.Func == "" && rw.RenameTo == "" && !rw.Drop {
+				return fmt.Errorf("%s: one of func, rename_to or drop is required", rwPrefix)
+			}
+			if rw.Drop && (rw.Func != "" || rw.RenameTo != "") {
+				return fmt.Errorf("%s: drop cannot be combined with func or rename_to", rwPrefix)
+			}
+			if rw.RenameTo == "" {
+				continue
+			}
+			if rw.RenameTo == rw.DBI {
+				return fmt.Errorf("%s: rename_to must differ from dbi", rwPrefix)
+			}
+			// Rules for the same versions are applied one after the other,
+			// so a renamed DBI must not be touched by another rule.
+			for j, other := range l.Rewrites {
+				if j == i || other.FromVersion != rw.FromVersion || other.ToVersion != rw.ToVersion {
+					continue
+				}
+				if other.DBI == rw.RenameTo || other.RenameTo == rw.RenameTo {
+					return fmt.Errorf("%s: rename_to %q conflicts with rewrites[%d] for the same versions",
+						rwPrefix, rw.RenameTo, j)
+				}
+			}
+		}
 	}
 	if c.HTTP.Address != "" {
 		if _, _, err := net.SplitHostPort(c.HTTP.Address); err != nil {
 			return fmt.Errorf("http.address: %v", err)
 		}
 	}
+	if t := c.HTTP.AdminToken; t != "" && len(t) < 16 {
+		return fmt.Errorf("http.admin_token: must be at least 16 characters")
+	}
+	if c.HTTP.AdminClientCert && !c.HTTP.TLS.RequireClientCert {
+		return fmt.Errorf("http.admin_client_cert: requires http.tls.require_client_cert")
+	}
+	if len(c.HTTP.AdminClientSubjects) > 0 && !c.HTTP.AdminClientCert {
+		return fmt.Errorf("http.admin_client_subjects: requires http.admin_client_cert")
+	}
+	if t := c.HTTP.TLS; t.HasCertWithKey() || t.RequireClientCert {
+		if !t.HasCertWithKey() {
+			return fmt.Errorf("http.tls: both a cert and key are required")
+		}
+		if t.RequireClientCert && !t.HasCA() {
+			return fmt.Errorf("http.tls: require_client_cert needs a ca or ca_file")
+		}
+	}
+	if b := c.HTTP.BasicAuth; b.Enabled() && (b.Username == "" || b.Password == "") {
+		return fmt.Errorf("http.basic_auth: both username and password are required")
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		return fmt.Errorf("tracing.sample_ratio: must be between 0 and 1")
+	}
+	if sl := c.Audit.Syslog; (sl.Network == "") != (sl.Address == "") {
+		return fmt.Errorf("audit.syslog: network and address must be set together")
+	}
+	for i, wh := range c.Notify.Webhooks {
+		u, err := url.Parse(wh.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notify.webhooks[%d].url: http or https URL required", i)
+		}
+	}
+	for i, nc := range c.Notify.Commands {
+		if len(nc.Command) == 0 || nc.Command[0] == "" {
+			return fmt.Errorf("notify.commands[%d].command: must not be empty", i)
+		}
+	}
+	if r := c.Reload; r.Enabled() {
+		if len(r.Command) > 0 && r.Command[0] == "" {
+			return fmt.Errorf("reload.command: must not be empty")
+		}
+		if r.URL != "" {
+			u, err := url.Parse(r.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("reload.url: http or https URL required")
+			}
+		}
+		if r.Debounce < 0 || r.MaxDelay < r.Debounce {
+			return fmt.Errorf("reload.max_delay: must not be shorter than reload.debounce")
+		}
+		if r.MaxKeys < 0 {
+			return fmt.Errorf("reload.max_keys: must not be negative")
+		}
+		if r.Timeout <= 0 {
+			return fmt.Errorf("reload.timeout: positive duration required")
+		}
+	}
+	if c.Notify.Enabled() {
+		if c.Notify.Timeout <= 0 {
+			return fmt.Errorf("notify.timeout: positive duration required")
+		}
+		if c.Notify.QueueSize < 1 {
+			return fmt.Errorf("notify.queue_size: positive number required")
+		}
+		if c.Notify.HealthInterval < time.Second {
+			return fmt.Errorf("notify.health_interval: too short interval")
+		}
+	}
 	if c.LMDBPollInterval < 100*time.Millisecond {
 		return fmt.Errorf("lmdb_poll_interval: too short interval")
 	}
@@ -400,6 +886,17 @@ func (c Config) Check() error {
 	if c.MemoryDecompressedSnapshots < 1 {
 		return fmt.Errorf("memory_decompressed_snapshots: positive number required")
 	}
+	if c.SnapshotWorkers < 1 {
+		return fmt.Errorf("snapshot_workers: positive number required")
+	}
+	if c.Storage.BackupPrefix == "" {
+		return fmt.Errorf("storage.backup_prefix: must not be empty")
+	}
+	for name := range c.LMDBs {
+		if strings.HasPrefix(name, c.Storage.BackupPrefix) {
+			return fmt.Errorf("storage.backup_prefix: lmdb %q starts with the backup prefix", name)
+		}
+	}
 	return nil
 }
 
@@ -474,6 +971,7 @@ func Default() Config {
 		StorageForceSnapshotInterval: DefaultStorageForceSnapshotInterval,
 		MemoryDownloadedSnapshots:    DefaultMemoryDownloadedSnapshots,
 		MemoryDecompressedSnapshots:  DefaultMemoryDecompressedSnapshots,
+		SnapshotWorkers:              DefaultSnapshotWorkers,
 
 		Sweeper: Sweeper{
 			Enabled:         false,
@@ -484,7 +982,31 @@ func Default() Config {
 			ReleaseDuration: 50 * time.Millisecond,
 		},
 
+		Tracing: Tracing{
+			SampleRatio: 1,
+		},
+
+		Notify: Notify{
+			Timeout:        10 * time.Second,
+			QueueSize:      100,
+			HealthInterval: 10 * time.Second,
+		},
+
+		Reload: Reload{
+			Debounce: time.Second,
+			MaxDelay: 10 * time.Second,
+			MaxKeys:  10000,
+			Timeout:  10 * time.Second,
+		},
+
+		Audit: Audit{
+			Syslog: AuditSyslog{
+				Tag: "lightningstream",
+			},
+		},
+
 		Storage: Storage{
+			BackupPrefix: DefaultBackupPrefix,
 			Cleanup: Cleanup{
 				Enabled:                    false, // TODO: Enable by default in future
 				Interval:                   5 * time.Minute,
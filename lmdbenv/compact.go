@@ -0,0 +1,114 @@
+package lmdbenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// DataPath returns the path of the data file of an open env
+func DataPath(env *lmdb.Env) (string, error) {
+	path, err := env.Path()
+	if err != nil {
+		return "", err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return "", err
+	}
+	if flags&lmdb.NoSubdir > 0 {
+		return path, nil
+	}
+	return filepath.Join(path, "data.mdb"), nil
+}
+
+// LockPath returns the path of the lock file of an open env
+func LockPath(env *lmdb.Env) (string, error) {
+	path, err := env.Path()
+	if err != nil {
+		return "", err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return "", err
+	}
+	if flags&lmdb.NoSubdir > 0 {
+		return path + "-lock", nil
+	}
+	return filepath.Join(path, "lock.mdb"), nil
+}
+
+// CompactCopy writes a compacted copy of the env to a file at path,
+// using mdb_env_copy2 with MDB_CP_COMPACT. Free pages are omitted from the
+// copy, so the copy can be a lot smaller than the original data file.
+// This uses a read transaction and can be done while the env is in use.
+// Like View, it holds a shared lock that prevents map size changes.
+//
+// The copy is written to a temporary file with a unique name next to path,
+// which is renamed to path when complete. An existing file at path is
+// replaced, and an interrupted copy never leaves a partial file at path.
+//
+// Note that LMDB resets the transaction ID of a compacted copy to 1.
+func CompactCopy(env *lmdb.Env, path string, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	fail := func(err error) error {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fail(err)
+	}
+	mu := envLock(env)
+	mu.RLock()
+	if mu.closed {
+		err = ErrEnvClosed
+	} else {
+		err = env.CopyFDFlag(f.Fd(), lmdb.CopyCompact)
+	}
+	mu.RUnlock()
+	if err != nil {
+		return fail(fmt.Errorf("compacting copy: %w", err))
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// BackupPath returns the path to keep the old data file at when swapping in
+// a compacted copy. The name includes the time, so that backups of earlier
+// swaps are never in the way.
+func BackupPath(dataPath string, t time.Time) string {
+	return dataPath + ".bak-" + t.UTC().Format("20060102T150405.000Z")
+}
+
+// SwapDataFile replaces the data file at dataPath with the file at newPath.
+// If backupPath is not empty, the old data file is kept there as a hard link.
+// No process may have the LMDB open during the swap, see InUseByOtherProcess.
+func SwapDataFile(dataPath, newPath, backupPath string) error {
+	if backupPath != "" {
+		if err := os.Link(dataPath, backupPath); err != nil {
+			return fmt.Errorf("backup data file: %w", err)
+		}
+	}
+	if err := os.Rename(newPath, dataPath); err != nil {
+		return fmt.Errorf("swap data file: %w", err)
+	}
+	return nil
+}
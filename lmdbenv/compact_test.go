@@ -0,0 +1,91 @@
+package lmdbenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactCopy(t *testing.T) {
+	err := TestEnv(func(env *lmdb.Env) error {
+		err := env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, txn.Put(dbi, fmt.Appendf(nil, "key-%05d", i), make([]byte, 1000), 0))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			for i := 1; i < 1000; i++ {
+				require.NoError(t, txn.Del(dbi, fmt.Appendf(nil, "key-%05d", i), nil))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		dataPath, err := DataPath(env)
+		require.NoError(t, err)
+		lockPath, err := LockPath(env)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Dir(dataPath), filepath.Dir(lockPath))
+
+		inUse, err := InUseByOtherProcess(env)
+		require.NoError(t, err)
+		assert.False(t, inUse, "own process does not count")
+
+		dir := filepath.Dir(dataPath)
+		compactPath := filepath.Join(dir, "data.mdb.compact")
+		// A stale output of an interrupted run is replaced
+		require.NoError(t, os.WriteFile(compactPath, []byte("stale"), 0600))
+		require.NoError(t, CompactCopy(env, compactPath, 0600))
+		tmpFiles, err := filepath.Glob(compactPath + ".tmp*")
+		require.NoError(t, err)
+		assert.Empty(t, tmpFiles)
+
+		st, err := os.Stat(dataPath)
+		require.NoError(t, err)
+		cst, err := os.Stat(compactPath)
+		require.NoError(t, err)
+		assert.Less(t, cst.Size(), st.Size()/10)
+
+		require.NoError(t, Close(env))
+		assert.ErrorIs(t, View(env, func(txn *lmdb.Txn) error { return nil }), ErrEnvClosed)
+		assert.ErrorIs(t, CompactCopy(env, compactPath, 0600), ErrEnvClosed)
+
+		// A backup of an earlier swap is not in the way
+		now := time.Now()
+		require.NoError(t, os.WriteFile(BackupPath(dataPath, now.Add(-time.Hour)), nil, 0600))
+		backupPath := BackupPath(dataPath, now)
+		require.NoError(t, SwapDataFile(dataPath, compactPath, backupPath))
+		_, err = os.Stat(backupPath)
+		require.NoError(t, err)
+
+		env2, err := New(dir, 0)
+		require.NoError(t, err)
+		defer func() {
+			_ = env2.Close()
+		}()
+		err = env2.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			require.Len(t, vals, 1)
+			assert.Equal(t, "key-00000", vals[0].Key)
+			return nil
+		})
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
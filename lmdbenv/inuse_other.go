@@ -0,0 +1,15 @@
+//go:build !unix
+
+package lmdbenv
+
+import (
+	"errors"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// InUseByOtherProcess returns true if another process has the env open.
+// This is not supported on this platform.
+func InUseByOtherProcess(env *lmdb.Env) (bool, error) {
+	return false, errors.New("checking if the LMDB is in use is not supported on this platform")
+}
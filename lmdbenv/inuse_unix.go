@@ -0,0 +1,79 @@
+//go:build unix
+
+package lmdbenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// lockFiles keeps the lock files opened by InUseByOtherProcess open.
+// Closing any file descriptor of a file releases all fcntl locks that the
+// process holds on that file, including the locks LMDB holds through the env,
+// which would allow another process to reset the reader table while our env
+// is still in use. These files are therefore never closed.
+var lockFiles sync.Map // lock file path -> *os.File
+
+// InUseByOtherProcess returns true if another process has the env open.
+//
+// Every process that has an env open holds a shared fcntl lock on the first
+// byte of the lock file. Locks of our own process never conflict, so any
+// conflicting lock belongs to another process.
+func InUseByOtherProcess(env *lmdb.Env) (bool, error) {
+	lockPath, err := LockPath(env)
+	if err != nil {
+		return false, err
+	}
+	f, err := openLockFile(lockPath)
+	if err != nil {
+		return false, err
+	}
+	return lockedByOtherProcess(f)
+}
+
+// openLockFile returns the open lock file at path, which remains open for the
+// lifetime of the process. A new file is opened if the lock file was replaced.
+func openLockFile(path string) (*os.File, error) {
+	if v, ok := lockFiles.Load(path); ok {
+		f := v.(*os.File)
+		fst, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if os.SameFile(fst, st) {
+			return f, nil
+		}
+		// The old file is no longer in use by LMDB, but it is not closed,
+		// because that would still be unsafe if an old env uses it.
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	lockFiles.Store(path, f)
+	return f, nil
+}
+
+// lockedByOtherProcess checks if another process holds a lock on the first
+// byte of the file
+func lockedByOtherProcess(f *os.File) (bool, error) {
+	lk := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+		Start:  0,
+		Len:    1,
+	}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		return false, fmt.Errorf("check lock: %w", err)
+	}
+	return lk.Type != syscall.F_UNLCK, nil
+}
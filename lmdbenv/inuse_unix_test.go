@@ -0,0 +1,53 @@
+//go:build unix
+
+package lmdbenv
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lockCheckEnv = "LMDBENV_TEST_LOCK_CHECK"
+
+// TestInUseByOtherProcess_keepsLock checks that our own lock on the lock
+// file is not released by the check, using a child process to look at it.
+func TestInUseByOtherProcess_keepsLock(t *testing.T) {
+	if path := os.Getenv(lockCheckEnv); path != "" {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		locked, err := lockedByOtherProcess(f)
+		require.NoError(t, err)
+		fmt.Printf("locked=%v\n", locked)
+		return
+	}
+
+	lockedInChild := func(lockPath string) bool {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestInUseByOtherProcess_keepsLock$")
+		cmd.Env = append(os.Environ(), lockCheckEnv+"="+lockPath)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.Contains(string(out), "locked=true")
+	}
+
+	err := TestEnv(func(env *lmdb.Env) error {
+		lockPath, err := LockPath(env)
+		require.NoError(t, err)
+		require.True(t, lockedInChild(lockPath))
+
+		for i := 0; i < 2; i++ {
+			inUse, err := InUseByOtherProcess(env)
+			require.NoError(t, err)
+			assert.False(t, inUse, "own process does not count")
+		}
+		assert.True(t, lockedInChild(lockPath), "own lock must still be held")
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,153 @@
+package lmdbenv
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// Changing the map size of an Env unmaps and remaps the data file, so it
+// must never be done while a transaction is active in this process.
+// Code that runs transactions concurrently with a possible map size change
+// must use View, Update and Info from this package, which hold a shared lock
+// on the Env during the call. SetMapSize holds the exclusive lock.
+// The same applies to closing an Env that other goroutines may still use,
+// which must be done with Close.
+//
+// Other processes are not affected: LMDB records the new map size in the
+// data file with the next write transaction, and other processes get an
+// MDB_MAP_RESIZED error when they start a new transaction, after which they
+// must call SetMapSize with a size of 0 to adopt the new map size.
+var envLocks sync.Map // *lmdb.Env -> *envState
+
+// ErrEnvClosed is returned for an Env that was closed with Close
+var ErrEnvClosed = errors.New("lmdb env is closed")
+
+type envState struct {
+	sync.RWMutex
+	closed bool // protected by the exclusive lock
+}
+
+func envLock(env *lmdb.Env) *envState {
+	mu, _ := envLocks.LoadOrStore(env, &envState{})
+	return mu.(*envState)
+}
+
+// View runs env.View while holding a shared lock that prevents map size
+// changes with SetMapSize.
+func View(env *lmdb.Env, fn lmdb.TxnOp) error {
+	mu := envLock(env)
+	mu.RLock()
+	defer mu.RUnlock()
+	if mu.closed {
+		return ErrEnvClosed
+	}
+	return env.View(fn)
+}
+
+// Update runs env.Update while holding a shared lock that prevents map size
+// changes with SetMapSize.
+func Update(env *lmdb.Env, fn lmdb.TxnOp) error {
+	mu := envLock(env)
+	mu.RLock()
+	defer mu.RUnlock()
+	if mu.closed {
+		return ErrEnvClosed
+	}
+	return env.Update(fn)
+}
+
+// ViewUnlocked runs env.View without taking the shared lock. It must only be
+// used by goroutines that run while another goroutine holds the shared lock
+// in a View or Update call that waits for them, like workers that read in
+// parallel with an outer transaction. Taking the shared lock again there
+// could deadlock with a waiting SetMapSize.
+func ViewUnlocked(env *lmdb.Env, fn lmdb.TxnOp) error {
+	return env.View(fn)
+}
+
+// Info runs env.Info while holding a shared lock that prevents map size
+// changes with SetMapSize, because the info is read from the map.
+func Info(env *lmdb.Env) (*lmdb.EnvInfo, error) {
+	mu := envLock(env)
+	mu.RLock()
+	defer mu.RUnlock()
+	if mu.closed {
+		return nil, ErrEnvClosed
+	}
+	return env.Info()
+}
+
+// TxnActive returns true if a transaction started with View or Update, or a
+// CompactCopy is active for the env. This is intended for tests.
+func TxnActive(env *lmdb.Env) bool {
+	mu := envLock(env)
+	if mu.TryLock() {
+		mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// SetMapSize changes the map size of the env after waiting for all
+// transactions started with View and Update to finish. The caller must not
+// have any active transaction itself.
+// A size of 0 adopts the map size recorded in the data file, which is needed
+// after another process grew the map size.
+func SetMapSize(env *lmdb.Env, size int64) error {
+	mu := envLock(env)
+	mu.Lock()
+	defer mu.Unlock()
+	if mu.closed {
+		return ErrEnvClosed
+	}
+	return env.SetMapSize(size)
+}
+
+// Close closes the env after waiting for all transactions started with View
+// and Update to finish. Afterwards, these functions return ErrEnvClosed
+// instead of using the closed env, so that goroutines that still have a
+// reference to the env, like metrics collectors, fail safely.
+func Close(env *lmdb.Env) error {
+	mu := envLock(env)
+	mu.Lock()
+	defer mu.Unlock()
+	if mu.closed {
+		return ErrEnvClosed
+	}
+	mu.closed = true
+	return env.Close()
+}
+
+// MapUsage returns the number of bytes used in the map and the current map
+// size. Pages on the freelist are counted as used, because LMDB may not be
+// able to reuse them immediately.
+func MapUsage(env *lmdb.Env) (used, size int64, err error) {
+	mu := envLock(env)
+	mu.RLock()
+	defer mu.RUnlock()
+	if mu.closed {
+		return 0, 0, ErrEnvClosed
+	}
+	info, err := env.Info()
+	if err != nil {
+		return 0, 0, err
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	used = (info.LastPNO + 1) * int64(stat.PSize)
+	return used, info.MapSize, nil
+}
+
+// IsErrno returns true if err or any error it wraps is the LMDB errno.
+// Unlike lmdb.IsErrno, it also works for errors wrapped with fmt.Errorf.
+func IsErrno(err error, errno lmdb.Errno) bool {
+	var opErr *lmdb.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Errno == errno
+	}
+	return errors.Is(err, errno)
+}
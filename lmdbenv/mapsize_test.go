@@ -0,0 +1,45 @@
+package lmdbenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetMapSize(t *testing.T) {
+	err := TestEnv(func(env *lmdb.Env) error {
+		const size = 1024 * 1024
+		require.NoError(t, SetMapSize(env, size))
+
+		err := Update(env, func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			return txn.Put(dbi, []byte("a"), make([]byte, 100_000), 0)
+		})
+		require.NoError(t, err)
+
+		used, mapSize, err := MapUsage(env)
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), mapSize)
+		assert.Greater(t, used, int64(100_000))
+		assert.Less(t, used, int64(size))
+
+		// Filling the map
+		err = Update(env, func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			if err := txn.Put(dbi, []byte("b"), make([]byte, 2*size), 0); err != nil {
+				return fmt.Errorf("put: %w", err)
+			}
+			return nil
+		})
+		assert.True(t, IsErrno(err, lmdb.MapFull), "unexpected error: %v", err)
+		assert.False(t, IsErrno(err, lmdb.MapResized))
+		assert.False(t, IsErrno(nil, lmdb.MapFull))
+		return nil
+	})
+	require.NoError(t, err)
+}
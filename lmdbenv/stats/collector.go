@@ -68,6 +68,10 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- statEntriesDesc
 	ch <- statPagesDesc
 	ch <- statDepthDesc
+	ch <- freelistEntriesDesc
+	ch <- freelistPagesDesc
+	ch <- freelistBytesDesc
+	ch <- freelistFragmentationDesc
 	ch <- smapsDesc
 }
 
@@ -88,7 +92,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 func (c *Collector) doCollect(ch chan<- prometheus.Metric, t Target) {
 	do := func() error {
 		// Collect env info
-		info, err := t.Env.Info()
+		info, err := lmdbenv.Info(t.Env)
 		if err != nil {
 			return fmt.Errorf("env info: %w", err)
 		}
@@ -134,7 +138,7 @@ func (c *Collector) doCollect(ch chan<- prometheus.Metric, t Target) {
 		)
 
 		// Collect per database stat
-		err = t.Env.View(func(txn *lmdb.Txn) error {
+		err = lmdbenv.View(t.Env, func(txn *lmdb.Txn) error {
 			var totalUsedBytes uint64
 			var err error
 			dbnames := t.DBNames
@@ -216,6 +220,36 @@ func (c *Collector) doCollect(ch chan<- prometheus.Metric, t Target) {
 					t.Name,
 				)
 			}
+
+			// Collect freelist stats
+			fl, err := ReadFreelist(t.Env, txn)
+			if err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(
+				freelistEntriesDesc,
+				prometheus.GaugeValue,
+				float64(fl.Entries),
+				t.Name,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				freelistPagesDesc,
+				prometheus.GaugeValue,
+				float64(fl.FreePages),
+				t.Name,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				freelistBytesDesc,
+				prometheus.GaugeValue,
+				float64(fl.FreeBytes()),
+				t.Name,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				freelistFragmentationDesc,
+				prometheus.GaugeValue,
+				fl.Fragmentation(),
+				t.Name,
+			)
 			return nil
 		})
 		if err != nil {
@@ -330,6 +364,30 @@ var (
 		[]string{"lmdb", "db"},
 		nil,
 	)
+	freelistEntriesDesc = prometheus.NewDesc(
+		"lmdb_freelist_entries",
+		"Number of records in the LMDB freelist",
+		[]string{"lmdb"},
+		nil,
+	)
+	freelistPagesDesc = prometheus.NewDesc(
+		"lmdb_freelist_pages",
+		"Number of free pages in the LMDB freelist that can be reused",
+		[]string{"lmdb"},
+		nil,
+	)
+	freelistBytesDesc = prometheus.NewDesc(
+		"lmdb_freelist_bytes",
+		"Bytes in free pages in the LMDB freelist that can be reused",
+		[]string{"lmdb"},
+		nil,
+	)
+	freelistFragmentationDesc = prometheus.NewDesc(
+		"lmdb_freelist_fraction",
+		"Free pages as fraction (0-1) of all pages used in the data file, an indication of fragmentation",
+		[]string{"lmdb"},
+		nil,
+	)
 	smapsDesc = prometheus.NewDesc(
 		"lmdb_smaps_bytes",
 		"Memory statistics for LMDB database from /proc/self/smaps (Linux only)",
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// freeDBI is the internal LMDB DBI that holds the freelist (FREE_DBI)
+const freeDBI lmdb.DBI = 0
+
+// Freelist contains statistics about the LMDB freelist, the pages that were
+// freed by earlier transactions and can be reused for new data.
+type Freelist struct {
+	Entries   uint64 // Number of freelist records (one per freeing transaction)
+	FreePages uint64 // Number of free pages
+	UsedPages uint64 // Number of pages used in the data file, including free pages
+	PageSize  uint64 // Page size in bytes
+}
+
+// FreeBytes returns the number of bytes in free pages
+func (f Freelist) FreeBytes() uint64 {
+	return f.FreePages * f.PageSize
+}
+
+// Fragmentation returns the fraction (0-1) of the used pages that are free.
+// A high value means that the data file could be shrunk a lot by a
+// compacting copy.
+func (f Freelist) Fragmentation() float64 {
+	if f.UsedPages == 0 {
+		return 0
+	}
+	return float64(f.FreePages) / float64(f.UsedPages)
+}
+
+// ReadFreelist reads the freelist statistics in the given transaction.
+// This needs to read the whole freelist, which is usually small.
+func ReadFreelist(env *lmdb.Env, txn *lmdb.Txn) (Freelist, error) {
+	var fl Freelist
+	info, err := env.Info()
+	if err != nil {
+		return fl, fmt.Errorf("env info: %w", err)
+	}
+	fl.UsedPages = uint64(info.LastPNO) + 1
+
+	c, err := txn.OpenCursor(freeDBI)
+	if err != nil {
+		return fl, fmt.Errorf("open freelist cursor: %w", err)
+	}
+	defer c.Close()
+
+	stat, err := txn.Stat(freeDBI)
+	if err != nil {
+		return fl, fmt.Errorf("stat freelist: %w", err)
+	}
+	fl.PageSize = uint64(stat.PSize)
+
+	// Every value is an LMDB ID list: a native size_t with the number of
+	// page numbers, followed by the page numbers.
+	const idSize = int(unsafe.Sizeof(uintptr(0)))
+	for {
+		_, v, err := c.Get(nil, nil, lmdb.Next)
+		if err != nil {
+			if lmdb.IsNotFound(err) {
+				break
+			}
+			return fl, fmt.Errorf("read freelist: %w", err)
+		}
+		if len(v) < idSize {
+			return fl, fmt.Errorf("invalid freelist value of %d bytes", len(v))
+		}
+		var n uint64
+		if idSize == 8 {
+			n = binary.NativeEndian.Uint64(v)
+		} else {
+			n = uint64(binary.NativeEndian.Uint32(v))
+		}
+		fl.Entries++
+		fl.FreePages += n
+	}
+	return fl, nil
+}
@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFreelist(t *testing.T) {
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		var dbi lmdb.DBI
+		err := env.Update(func(txn *lmdb.Txn) (err error) {
+			dbi, err = txn.OpenDBI("foo", lmdb.Create)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 1000; i++ {
+				if err := txn.Put(dbi, fmt.Appendf(nil, "key-%05d", i), make([]byte, 100), 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		var before Freelist
+		err = env.View(func(txn *lmdb.Txn) (err error) {
+			before, err = ReadFreelist(env, txn)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Greater(t, before.PageSize, uint64(0))
+		assert.Greater(t, before.UsedPages, uint64(10))
+
+		// Deleting all data frees the pages
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return txn.Drop(dbi, false)
+		})
+		require.NoError(t, err)
+		// The pages are only added to the freelist by the next transaction
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return txn.Put(dbi, []byte("a"), []byte("b"), 0)
+		})
+		require.NoError(t, err)
+
+		var after Freelist
+		err = env.View(func(txn *lmdb.Txn) (err error) {
+			after, err = ReadFreelist(env, txn)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Greater(t, after.Entries, uint64(0))
+		assert.Greater(t, after.FreePages, before.FreePages)
+		assert.Greater(t, after.Fragmentation(), 0.5)
+		assert.Equal(t, after.FreePages*after.PageSize, after.FreeBytes())
+		return nil
+	})
+	require.NoError(t, err)
+}
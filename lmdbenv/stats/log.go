@@ -21,7 +21,7 @@ func Log(env *lmdb.Env, dbnames []string, withSmaps bool, log logrus.FieldLogger
 		// TODO: smaps (optional?)
 
 		// Collect env info
-		info, err := env.Info()
+		info, err := lmdbenv.Info(env)
 		if err != nil {
 			return fmt.Errorf("env info: %w", err)
 		}
@@ -44,7 +44,7 @@ func Log(env *lmdb.Env, dbnames []string, withSmaps bool, log logrus.FieldLogger
 		}).Info("LMDB info")
 
 		// Collect per database stat
-		err = env.View(func(txn *lmdb.Txn) error {
+		err = lmdbenv.View(env, func(txn *lmdb.Txn) error {
 			var err error
 			if dbnames == nil {
 				dbnames, err = lmdbenv.ReadDBINames(txn)
@@ -73,6 +73,17 @@ func Log(env *lmdb.Env, dbnames []string, withSmaps bool, log logrus.FieldLogger
 					"psize":          stat.PSize,
 				}).Info("LMDB db stat")
 			}
+
+			fl, err := ReadFreelist(env, txn)
+			if err != nil {
+				return err
+			}
+			log.WithFields(logrus.Fields{
+				"entries":       fl.Entries,
+				"free_pages":    fl.FreePages,
+				"used_pages":    fl.UsedPages,
+				"fragmentation": fl.Fragmentation(),
+			}).Info("LMDB freelist")
 			return nil
 		})
 		if err != nil {
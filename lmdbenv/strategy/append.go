@@ -1,7 +1,6 @@
 package strategy
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 
@@ -20,6 +19,10 @@ import (
 //
 // Uses: make the very first snapshot load fast.
 func Append(txn *lmdb.Txn, dbi lmdb.DBI, it Iterator) error {
+	cmpFunc, err := dbiKeyCmpFunc(txn, dbi)
+	if err != nil {
+		return err
+	}
 	prevKey := make([]byte, 0, LMDBMaxKeySize)
 	for {
 		// Get key
@@ -32,7 +35,7 @@ func Append(txn *lmdb.Txn, dbi lmdb.DBI, it Iterator) error {
 		}
 
 		// Check to ensure the keys are in insert order
-		if bytes.Compare(prevKey, key) >= 0 {
+		if cmpFunc(prevKey, key) >= 0 {
 			return ErrNotSorted
 		}
 		prevKey = prevKey[:len(key)]
@@ -47,3 +47,17 @@ func TestAppend_unsorted(t *testing.T) {
 		t.Fatalf("TestTxn error: %v", err)
 	}
 }
+
+func TestAppend_reverseKey(t *testing.T) {
+	items := []lmdbenv.KVString{
+		{"xa", "1"},
+		{"ab", "2"},
+		{"zc", "3"},
+	}
+	exp := []lmdbenv.KVString{
+		{"xa", "X1"},
+		{"ab", "X2"},
+		{"zc", "X3"},
+	}
+	doStrategyTestFlags(t, Append, lmdb.ReverseKey, nil, items, exp, 'X')
+}
@@ -0,0 +1,84 @@
+package strategy
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// DupIterator is the interface expected by DupUpdate.
+// Instead of a single value per key, it merges all the values of a key in
+// a DupSort DBI at once.
+type DupIterator interface {
+	// Next returns the next LMDB key to update. Calling it invalidates
+	// earlier byte slides received from any of these methods.
+	// It returns io.EOF when no more entries are available.
+	Next() (key []byte, err error)
+	// MergeDups takes all the existing LMDB values for the current key in
+	// DupSort order, merges them with the values we want to insert, and
+	// returns the values the key must have afterwards.
+	// If changed is false, the existing values are retained as is.
+	// Returning no values with changed set to true removes the key.
+	MergeDups(oldvals [][]byte) (vals [][]byte, changed bool, err error)
+}
+
+// DupUpdate implements the Update strategy for DupSort DBIs.
+//
+// - Sorted input not required
+// - Every key is passed only once and all its values are replaced at once
+func DupUpdate(txn *lmdb.Txn, dbi lmdb.DBI, it DupIterator) error {
+	c, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return fmt.Errorf("open cursor: %w", err)
+	}
+	defer c.Close()
+
+	var oldvals [][]byte
+	for {
+		// Get key
+		key, err := it.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil // done
+			}
+			return fmt.Errorf("next: %w", err)
+		}
+
+		// Get all existing values for this key. These are copied, because
+		// the merged values may refer to them after we deleted the key.
+		oldvals = oldvals[:0]
+		_, v, err := c.Get(key, nil, lmdb.Set)
+		for err == nil {
+			oldvals = append(oldvals, append([]byte(nil), v...))
+			_, v, err = c.Get(nil, nil, lmdb.NextDup)
+		}
+		if !lmdb.IsNotFound(err) {
+			return fmt.Errorf("get dups: %w", err)
+		}
+
+		vals, changed, err := it.MergeDups(oldvals)
+		if err != nil {
+			return fmt.Errorf("merge dups: %w", err)
+		}
+		if !changed {
+			continue
+		}
+
+		if len(oldvals) > 0 {
+			// Remove all the values for the key. txn.Del cannot be used for
+			// this, because it passes an empty value instead of NULL.
+			if _, _, err := c.Get(key, nil, lmdb.Set); err != nil {
+				return fmt.Errorf("get: %w", err)
+			}
+			if err := c.Del(lmdb.NoDupData); err != nil {
+				return fmt.Errorf("del: %w", err)
+			}
+		}
+		for _, val := range vals {
+			if err := txn.Put(dbi, key, val, 0); err != nil {
+				return fmt.Errorf("put: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package strategy
+
+import (
+	"io"
+	"testing"
+
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+)
+
+// testDupIterator adds the values for every key to the existing values,
+// and removes the key if no values are given.
+type testDupIterator struct {
+	keys []string
+	vals [][]string
+	idx  int
+}
+
+func (it *testDupIterator) Next() (key []byte, err error) {
+	it.idx++
+	if it.idx >= len(it.keys) {
+		return nil, io.EOF
+	}
+	return []byte(it.keys[it.idx]), nil
+}
+
+func (it *testDupIterator) MergeDups(oldvals [][]byte) (vals [][]byte, changed bool, err error) {
+	add := it.vals[it.idx]
+	if len(add) == 0 {
+		return nil, len(oldvals) > 0, nil
+	}
+	vals = append(vals, oldvals...)
+	for _, v := range add {
+		vals = append(vals, []byte(v))
+	}
+	return vals, true, nil
+}
+
+func TestDupUpdate(t *testing.T) {
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		return env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("dups", lmdb.Create|lmdb.DupSort)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, []byte("a"), []byte("1"), 0))
+			require.NoError(t, txn.Put(dbi, []byte("a"), []byte("3"), 0))
+			require.NoError(t, txn.Put(dbi, []byte("b"), []byte("1"), 0))
+			require.NoError(t, txn.Put(dbi, []byte("c"), []byte("1"), 0))
+
+			it := &testDupIterator{
+				keys: []string{"c", "a", "b", "d"},
+				vals: [][]string{{"0"}, {"2"}, nil, {"x", "y"}},
+				idx:  -1,
+			}
+			err = DupUpdate(txn, dbi, it)
+			require.NoError(t, err)
+
+			items, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "a", Val: "1"},
+				{Key: "a", Val: "2"},
+				{Key: "a", Val: "3"},
+				{Key: "c", Val: "0"},
+				{Key: "c", Val: "1"},
+				{Key: "d", Val: "x"},
+				{Key: "d", Val: "y"},
+			}, items)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
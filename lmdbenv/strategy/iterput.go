@@ -26,16 +26,12 @@ func IterPut(txn *lmdb.Txn, dbi lmdb.DBI, it Iterator) error {
 	}
 	defer c.Close()
 
-	integerKey := false
 	flags, err := txn.Flags(dbi)
 	if err != nil {
 		return fmt.Errorf("get flags: %w", err)
 	}
-	if flags&LMDBIntegerKeyFlag > 0 {
-		integerKey = true
-	}
 
-	err = iterBoth(it, c, integerKey, func(itKey, dbKey, dbVal []byte, itEOF, dbEOF bool) error {
+	err = iterBoth(it, c, flags, func(itKey, dbKey, dbVal []byte, itEOF, dbEOF bool) error {
 		// log.Printf("@@@ args: %s, %s, %s, %v, %v", string(itKey), string(dbKey), string(dbVal), itEOF, dbEOF)
 
 		// Database cursor behind
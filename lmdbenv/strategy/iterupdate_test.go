@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lmdb-go/lmdb"
 )
 
 func TestIterUpdate_empty(t *testing.T) {
@@ -306,3 +307,22 @@ func TestIterUpdateNS(t *testing.T) {
 	}
 	doStrategyTestNS(t, IterUpdate, items1, items2, exp, 'A')
 }
+
+func TestIterUpdate_reverseKey(t *testing.T) {
+	// In MDB_REVERSEKEY order, the last byte of the keys is compared first
+	olditems := []lmdbenv.KVString{
+		{"xa", "X1"},
+		{"yb", "X2"},
+	}
+	items := []lmdbenv.KVString{
+		{"xa", "3"},
+		{"ab", "4"},
+		{"zc", "5"},
+	}
+	exp := []lmdbenv.KVString{
+		{"xa", "X3"},
+		{"ab", "X4"},
+		{"zc", "X5"},
+	}
+	doStrategyTestFlags(t, IterUpdate, lmdb.ReverseKey, olditems, items, exp, 'X')
+}
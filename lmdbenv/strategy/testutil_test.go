@@ -148,7 +148,13 @@ func prefillDBI(txn *lmdb.Txn, dbi lmdb.DBI, items []lmdbenv.KVString) error {
 // items are formatted as k = VW... (only value bytes)
 // expItems are formatted the same way as oldItems.
 func doStrategyTestNS(t *testing.T, f Func, oldItems, items, expItems []lmdbenv.KVString, ns byte) {
-	err := lmdbenv.TestTxn(func(txn *lmdb.Txn, dbi lmdb.DBI) error {
+	doStrategyTestFlags(t, f, 0, oldItems, items, expItems, ns)
+}
+
+// doStrategyTestFlags is like doStrategyTestNS, but uses a DBI created with
+// the given DBI flags.
+func doStrategyTestFlags(t *testing.T, f Func, dbiFlags uint, oldItems, items, expItems []lmdbenv.KVString, ns byte) {
+	err := lmdbenv.TestTxnFlags(dbiFlags, func(txn *lmdb.Txn, dbi lmdb.DBI) error {
 		// First insert some old data
 		if oldItems != nil {
 			if err := prefillDBI(txn, dbi, oldItems); err != nil {
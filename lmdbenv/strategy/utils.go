@@ -21,14 +21,9 @@ type iterBothFunc func(itKey, dbKey, dbVal []byte, itEOF, dbEOF bool) error
 
 // iterBoth iterates over both LMDB and the Iterator and calls the callback
 // function with the values.
-// Set integerKey=true if the MDB_INTEGERKEY flag is set on the DBI.
-func iterBoth(it Iterator, c *lmdb.Cursor, integerKey bool, f iterBothFunc) error {
-	var cmpFunc func(a, b []byte) int
-	if integerKey && isLittleEndian {
-		cmpFunc = cmpIntegerLittleEndian
-	} else {
-		cmpFunc = bytes.Compare
-	}
+// The dbiFlags are used to determine the key order of the DBI.
+func iterBoth(it Iterator, c *lmdb.Cursor, dbiFlags uint, f iterBothFunc) error {
+	cmpFunc := KeyCmpFunc(dbiFlags)
 
 	itEOF := false
 	dbEOF := false
@@ -116,6 +111,54 @@ func iterBoth(it Iterator, c *lmdb.Cursor, integerKey bool, f iterBothFunc) erro
 	}
 }
 
+// KeyCmpFunc returns the compare function that matches the key order LMDB
+// uses for a DBI with the given flags.
+func KeyCmpFunc(dbiFlags uint) func(a, b []byte) int {
+	switch {
+	case dbiFlags&LMDBIntegerKeyFlag > 0 && isLittleEndian:
+		return cmpIntegerLittleEndian
+	case dbiFlags&lmdb.ReverseKey > 0:
+		return cmpReverse
+	default:
+		return bytes.Compare
+	}
+}
+
+// dbiKeyCmpFunc returns the key compare function for a DBI
+func dbiKeyCmpFunc(txn *lmdb.Txn, dbi lmdb.DBI) (func(a, b []byte) int, error) {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return nil, fmt.Errorf("get flags: %w", err)
+	}
+	return KeyCmpFunc(flags), nil
+}
+
+// cmpReverse is a compare function that compares the data starting at the
+// last byte, like LMDB does for MDB_REVERSEKEY.
+func cmpReverse(a, b []byte) int {
+	i := len(a) - 1
+	j := len(b) - 1
+	for i >= 0 && j >= 0 {
+		if a[i] != b[j] {
+			if a[i] < b[j] {
+				return -1
+			}
+			return 1
+		}
+		i--
+		j--
+	}
+	// All compared bytes are equal, the shorter one is smaller
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
 // cmpIntegerLittleEndian is a compare function that interprets the data as a little endian
 func cmpIntegerLittleEndian(a, b []byte) int {
 	ai := bytesToInt(a)
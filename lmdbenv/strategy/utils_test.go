@@ -22,3 +22,27 @@ func Test_cmpIntegerLittleEndian(t *testing.T) {
 		})
 	}
 }
+
+func Test_cmpReverse(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []byte
+		b    []byte
+		want int
+	}{
+		{"same", []byte("ab"), []byte("ab"), 0},
+		{"last-byte-first", []byte("ba"), []byte("ab"), -1},
+		{"gt", []byte("ab"), []byte("ba"), 1},
+		{"same-suffix", []byte("ab"), []byte("yb"), -1},
+		{"shorter", []byte("b"), []byte("ab"), -1},
+		{"longer", []byte("ab"), []byte("b"), 1},
+		{"empty", []byte(""), []byte("a"), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cmpReverse(tt.a, tt.b); got != tt.want {
+				t.Errorf("cmpReverse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -41,10 +41,16 @@ type TestTxnFunc func(txn *lmdb.Txn, dbi lmdb.DBI) error
 // This is a convenience wrapper around TestEnv().
 // Any error returned by this function is returned unmodified to the caller.
 func TestTxn(f TestTxnFunc) error {
+	return TestTxnFlags(0, f)
+}
+
+// TestTxnFlags is like TestTxn, but creates the DBI with the given DBI flags,
+// like lmdb.ReverseKey.
+func TestTxnFlags(dbiFlags uint, f TestTxnFunc) error {
 	noErr := errors.New("no error")
 	return TestEnv(func(env *lmdb.Env) error {
 		err := env.Update(func(txn *lmdb.Txn) error {
-			dbi, err := txn.OpenDBI("tempdbi", lmdb.Create)
+			dbi, err := txn.OpenDBI("tempdbi", lmdb.Create|dbiFlags)
 			if err != nil {
 				return fmt.Errorf("create dbi: %w", err)
 			}
@@ -0,0 +1,19 @@
+package notify
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricNotifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_notify_total",
+			Help: "Number of notifications by target kind, event and result (ok, failed or dropped)",
+		},
+		[]string{"kind", "event", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricNotifications)
+}
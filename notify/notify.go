@@ -0,0 +1,248 @@
+// Package notify calls configured webhooks and local commands on sync events,
+// for example to purge a cache after a snapshot was loaded. The event is sent
+// as JSON, in the body of a POST request for webhooks and on stdin for
+// commands. Every target has its own queue and is called sequentially, so a
+// slow target never blocks the syncers or other targets.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/status/healthtracker"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/sirupsen/logrus"
+)
+
+// Event names that targets can subscribe to
+const (
+	EventUpdateLoaded    = "update_loaded"
+	EventUpdateStored    = "update_stored"
+	EventSnapshotOverdue = "snapshot_overdue"
+	EventHealthChanged   = "health_changed"
+)
+
+var validEvents = map[string]bool{
+	EventUpdateLoaded:    true,
+	EventUpdateStored:    true,
+	EventSnapshotOverdue: true,
+	EventHealthChanged:   true,
+}
+
+// Event is the JSON sent to the targets. Which fields are set depends on the
+// Event name.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Instance string    `json:"instance"` // our own instance
+	LMDB     string    `json:"lmdb,omitempty"`
+
+	// Loaded and stored snapshots
+	SnapshotInstance string                     `json:"snapshot_instance,omitempty"`
+	Snapshot         string                     `json:"snapshot,omitempty"`
+	Timestamp        time.Time                  `json:"timestamp,omitzero"` // of the snapshot
+	TxnID            int64                      `json:"txn_id,omitempty"`
+	LocalChanged     *bool                      `json:"local_changed,omitempty"`
+	Changes          map[string]events.DBIStats `json:"changes,omitempty"` // by DBI name
+
+	// Health changes
+	Health        *healthtracker.Status `json:"health,omitempty"`
+	PreviousState string                `json:"previous_state,omitempty"`
+}
+
+// Notifier sends events to the configured targets
+type Notifier struct {
+	instance string
+	conf     config.Notify
+	targets  []*target
+
+	stop    chan struct{}
+	watches []<-chan struct{} // closed when done
+	ctx     context.Context   // canceled on Close
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup // workers and health checker
+}
+
+// New creates a Notifier for the targets in the config, which must be
+// enabled, and starts delivering events.
+func New(c config.Config) (*Notifier, error) {
+	nc := c.Notify
+	n := &Notifier{
+		instance: c.Instance,
+		conf:     nc,
+		stop:     make(chan struct{}),
+	}
+	userAgent := "lightningstream/" + c.Version
+	for i, wh := range nc.Webhooks {
+		t, err := newWebhook(wh, nc.QueueSize, userAgent)
+		if err != nil {
+			return nil, fmt.Errorf("notify.webhooks[%d]: %w", i, err)
+		}
+		n.targets = append(n.targets, t)
+	}
+	for i, cmd := range nc.Commands {
+		t, err := newCommand(cmd, nc.QueueSize)
+		if err != nil {
+			return nil, fmt.Errorf("notify.commands[%d]: %w", i, err)
+		}
+		n.targets = append(n.targets, t)
+	}
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	for _, t := range n.targets {
+		n.wg.Add(1)
+		go n.worker(t)
+	}
+	if n.wanted(EventHealthChanged) {
+		n.wg.Add(1)
+		go n.checkHealth()
+	}
+	logrus.WithFields(logrus.Fields{
+		"webhooks": len(nc.Webhooks),
+		"commands": len(nc.Commands),
+	}).Info("Notifications enabled")
+	return n, nil
+}
+
+// Watch sends the events of the syncer of the named LMDB until the Notifier
+// is closed. The subscriptions are made before Watch returns.
+func (n *Notifier) Watch(lmdbName string, ev *events.Events) {
+	n.watches = append(n.watches,
+		ev.UpdateLoaded.HandleUntil(n.stop, func(info events.UpdateInfo) {
+			e := n.updateEvent(EventUpdateLoaded, lmdbName, info)
+			e.TxnID = int64(info.TxnID)
+			e.LocalChanged = &info.LocalChanged
+			e.Changes = info.DBIStats
+			n.publish(e)
+		}),
+		ev.UpdateStored.HandleUntil(n.stop, func(info events.UpdateInfo) {
+			e := n.updateEvent(EventUpdateStored, lmdbName, info)
+			e.TxnID = info.Meta.LmdbTxnID
+			n.publish(e)
+		}),
+		ev.SnapshotOverdue.HandleUntil(n.stop, func(struct{}) {
+			n.publish(Event{
+				Event: EventSnapshotOverdue,
+				LMDB:  lmdbName,
+			})
+		}),
+	)
+}
+
+func (n *Notifier) updateEvent(event, lmdbName string, info events.UpdateInfo) Event {
+	return Event{
+		Event:            event,
+		LMDB:             lmdbName,
+		SnapshotInstance: info.NameInfo.InstanceID,
+		Snapshot:         info.NameInfo.FullName,
+		Timestamp:        info.NameInfo.Timestamp.UTC(),
+	}
+}
+
+// wanted returns true if any target wants the event
+func (n *Notifier) wanted(event string) bool {
+	for _, t := range n.targets {
+		if t.wants(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish queues the event for all targets that want it. Events are dropped
+// if the queue of a target is full.
+func (n *Notifier) publish(e Event) {
+	e.Time = time.Now().UTC()
+	e.Instance = n.instance
+	for _, t := range n.targets {
+		if !t.wants(e.Event) {
+			continue
+		}
+		select {
+		case t.queue <- e:
+		default:
+			metricNotifications.WithLabelValues(t.kind, e.Event, "dropped").Inc()
+			logrus.WithFields(logrus.Fields{
+				"kind":   t.kind,
+				"target": t.name,
+				"event":  e.Event,
+			}).Warn("Notification queue full, dropping event")
+		}
+	}
+}
+
+// worker sends the queued events of a target until the Notifier is closed
+func (n *Notifier) worker(t *target) {
+	defer n.wg.Done()
+	l := logrus.WithFields(logrus.Fields{
+		"kind":   t.kind,
+		"target": t.name,
+	})
+	for {
+		var e Event
+		select {
+		case <-n.ctx.Done():
+			return
+		case e = <-t.queue:
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			l.WithError(err).Error("Notification marshal failed") // should never happen
+			continue
+		}
+		ctx, cancel := context.WithTimeout(n.ctx, n.conf.Timeout)
+		err = t.send(ctx, e, data)
+		cancel()
+		if err != nil {
+			metricNotifications.WithLabelValues(t.kind, e.Event, "failed").Inc()
+			l.WithError(err).WithField("event", e.Event).Warn("Notification failed")
+			continue
+		}
+		metricNotifications.WithLabelValues(t.kind, e.Event, "ok").Inc()
+		l.WithField("event", e.Event).Debug("Notification sent")
+	}
+}
+
+// checkHealth periodically publishes an event for every health tracker that
+// changed state. The state depends on how long a failure lasts, so it must
+// be polled.
+func (n *Notifier) checkHealth() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.conf.HealthInterval)
+	defer ticker.Stop()
+	states := make(map[string]string) // by tracker name
+	for {
+		for _, st := range healthtracker.AllStatus() {
+			prev, seen := states[st.Name]
+			states[st.Name] = st.State
+			if !seen || prev == st.State {
+				continue
+			}
+			n.publish(Event{
+				Event:         EventHealthChanged,
+				Health:        &st,
+				PreviousState: prev,
+			})
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops watching events and stops the workers. Events that are still
+// queued are discarded.
+func (n *Notifier) Close() {
+	close(n.stop)
+	for _, done := range n.watches {
+		<-done
+	}
+	n.cancel()
+	n.wg.Wait()
+}
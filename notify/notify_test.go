@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNameInfo = snapshot.NameInfo{
+	FullName:   "main__a__20220101-010203-000000000__G-0000000000000001.pb.gz",
+	InstanceID: "a",
+	Timestamp:  time.Date(2022, 1, 1, 1, 2, 3, 0, time.UTC),
+}
+
+func testConfig() config.Config {
+	c := config.Default()
+	c.Instance = "self"
+	c.Version = "test"
+	return c
+}
+
+func TestNotifier_webhook(t *testing.T) {
+	type request struct {
+		token string
+		event Event
+	}
+	requests := make(chan request, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		requests <- request{token: r.Header.Get("X-Token"), event: e}
+	}))
+	defer srv.Close()
+
+	c := testConfig()
+	c.Notify.Webhooks = []config.Webhook{{
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Events:  []string{EventUpdateLoaded},
+	}}
+	n, err := New(c)
+	require.NoError(t, err)
+	defer n.Close()
+
+	ev := events.New()
+	n.Watch("main", ev)
+	ev.UpdateStored.Publish(events.UpdateInfo{NameInfo: testNameInfo}) // not wanted
+	ev.UpdateLoaded.Publish(events.UpdateInfo{
+		NameInfo:     testNameInfo,
+		TxnID:        header.TxnID(12),
+		LocalChanged: true,
+		DBIStats:     map[string]events.DBIStats{"foo": {Added: 1}},
+	})
+
+	var req request
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for webhook")
+	}
+	assert.Equal(t, "secret", req.token)
+	assert.False(t, req.event.Time.IsZero())
+	req.event.Time = time.Time{}
+	changed := true
+	assert.Equal(t, Event{
+		Event:            EventUpdateLoaded,
+		Instance:         "self",
+		LMDB:             "main",
+		SnapshotInstance: "a",
+		Snapshot:         testNameInfo.FullName,
+		Timestamp:        testNameInfo.Timestamp,
+		TxnID:            12,
+		LocalChanged:     &changed,
+		Changes:          map[string]events.DBIStats{"foo": {Added: 1}},
+	}, req.event)
+	assert.Empty(t, requests)
+}
+
+func TestNotifier_command(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig()
+	c.Notify.Commands = []config.NotifyCommand{{
+		Command: []string{"sh", "-c", `cat > "$0/event.json" && echo "$LIGHTNINGSTREAM_EVENT $LIGHTNINGSTREAM_LMDB" > "$0/env"`, dir},
+	}}
+	n, err := New(c)
+	require.NoError(t, err)
+	defer n.Close()
+
+	ev := events.New()
+	n.Watch("main", ev)
+	ev.SnapshotOverdue.Publish(struct{}{})
+
+	envPath := filepath.Join(dir, "env")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(envPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	env, err := os.ReadFile(envPath)
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot_overdue main\n", string(env))
+
+	f, err := os.Open(filepath.Join(dir, "event.json"))
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	var e Event
+	require.NoError(t, json.Unmarshal(data, &e))
+	assert.Equal(t, EventSnapshotOverdue, e.Event)
+	assert.Equal(t, "self", e.Instance)
+}
+
+func TestNew_unknownEvent(t *testing.T) {
+	c := testConfig()
+	c.Notify.Commands = []config.NotifyCommand{{
+		Command: []string{"true"},
+		Events:  []string{"update_exploded"},
+	}}
+	_, err := New(c)
+	assert.ErrorContains(t, err, `notify.commands[0]: unknown event "update_exploded"`)
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/PowerDNS/lightningstream/config"
+)
+
+// Target kinds
+const (
+	KindWebhook = "webhook"
+	KindCommand = "command"
+)
+
+// maxOutput limits the command output or response body included in errors
+const maxOutput = 512
+
+// target is a single webhook or command with its own queue
+type target struct {
+	kind   string
+	name   string          // for logging, without secrets
+	events map[string]bool // nil for all events
+	queue  chan Event
+	send   func(ctx context.Context, e Event, data []byte) error
+}
+
+// wants returns true if the event must be sent to the target
+func (t *target) wants(event string) bool {
+	return t.events == nil || t.events[event]
+}
+
+func eventSet(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !validEvents[name] {
+			return nil, fmt.Errorf("unknown event %q", name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+func newWebhook(wh config.Webhook, queueSize int, userAgent string) (*target, error) {
+	events, err := eventSet(wh.Events)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &target{
+		kind:   KindWebhook,
+		name:   u.Redacted(),
+		events: events,
+		queue:  make(chan Event, queueSize),
+		send: func(ctx context.Context, e Event, data []byte) error {
+			return PostJSON(ctx, wh.URL, wh.Headers, userAgent, data)
+		},
+	}, nil
+}
+
+func newCommand(nc config.NotifyCommand, queueSize int) (*target, error) {
+	events, err := eventSet(nc.Events)
+	if err != nil {
+		return nil, err
+	}
+	return &target{
+		kind:   KindCommand,
+		name:   nc.Command[0],
+		events: events,
+		queue:  make(chan Event, queueSize),
+		send: func(ctx context.Context, e Event, data []byte) error {
+			return RunCommand(ctx, nc.Command, []string{
+				"LIGHTNINGSTREAM_EVENT=" + e.Event,
+				"LIGHTNINGSTREAM_LMDB=" + e.LMDB,
+			}, data)
+		},
+	}, nil
+}
+
+// PostJSON sends the JSON data as a POST request to the URL and returns an
+// error if the response status is not 2xx
+func PostJSON(ctx context.Context, rawURL string, headers map[string]string, userAgent string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// RunCommand runs the command with the data on stdin and the extra
+// environment variables. The output is included in the error if it fails.
+func RunCommand(ctx context.Context, command []string, env []string, data []byte) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > maxOutput {
+			out = out[:maxOutput]
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
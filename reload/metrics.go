@@ -0,0 +1,26 @@
+package reload
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricTriggers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_reload_triggers_total",
+			Help: "Number of reload triggers by action kind (command, url or socket) and result (ok or failed)",
+		},
+		[]string{"kind", "result"},
+	)
+	metricPDNSIndexScans = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "lightningstream_reload_powerdns_index_scans_total",
+			Help: "Number of full scans of the PowerDNS domains index to resolve domain IDs to zone names",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricTriggers)
+	prometheus.MustRegister(metricPDNSIndexScans)
+}
@@ -0,0 +1,155 @@
+package reload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/lmdb-go/lmdbscan"
+)
+
+// DBI names of the PowerDNS Auth LMDB backend schema version 5.
+// The records are stored in separate shard LMDBs.
+const (
+	pdnsRecordsDBI      = "records_v5"
+	pdnsDomainsDBI      = "domains_v5"
+	pdnsDomainsIndexDBI = "domains_v5_0" // by name
+)
+
+// pdnsDomainID returns the domain ID at the start of the key of a record or
+// domain entry
+func pdnsDomainID(key []byte) (id uint32, ok bool) {
+	if len(key) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(key), true
+}
+
+// pdnsParseIndexKey parses a key of the domains index, which consists of the
+// length of the name, the name with reversed labels and the domain ID.
+func pdnsParseIndexKey(key []byte) (name string, id uint32, ok bool) {
+	if len(key) < 6 {
+		return "", 0, false
+	}
+	n := int(binary.BigEndian.Uint16(key))
+	if len(key) != 2+n+4 {
+		return "", 0, false
+	}
+	return pdnsDisplayName(key[2 : 2+n]), binary.BigEndian.Uint32(key[2+n:]), true
+}
+
+// pdnsDisplayName converts a name with reversed zero terminated labels, like
+// "com\x00example\x00", to "example.com".
+func pdnsDisplayName(b []byte) string {
+	p := bytes.Split(b, []byte{0})
+	var labels []string
+	for i := len(p) - 1; i >= 0; i-- {
+		if len(p[i]) == 0 {
+			continue
+		}
+		labels = append(labels, string(p[i]))
+	}
+	if len(labels) == 0 {
+		return "."
+	}
+	return strings.Join(labels, ".")
+}
+
+// pdnsZones adds the zone names and domain IDs affected by the changed keys
+// of a DBI. Keys of other DBIs are ignored.
+func pdnsZones(dbiName string, keys [][]byte, zones map[string]bool, ids map[uint32]bool) {
+	switch dbiName {
+	case pdnsRecordsDBI, pdnsDomainsDBI:
+		for _, key := range keys {
+			if id, ok := pdnsDomainID(key); ok {
+				ids[id] = true
+			}
+		}
+	case pdnsDomainsIndexDBI:
+		for _, key := range keys {
+			if name, _, ok := pdnsParseIndexKey(key); ok {
+				zones[name] = true
+			}
+		}
+	}
+}
+
+// pdnsIDCacheMax limits the number of domains index keys cached per LMDB
+const pdnsIDCacheMax = 500_000
+
+// pdnsIDCache caches the domains index keys of an LMDB by domain ID, so that
+// the domain IDs of a trigger can be resolved with lookups, instead of a scan
+// of the whole domains index every time.
+// It is only used by the trigger loop.
+type pdnsIDCache struct {
+	keys      map[uint32][]byte // domains index keys by domain ID
+	scanTxnID uintptr           // LMDB transaction of the last scan
+	complete  bool              // all index keys of the last scan are cached
+}
+
+// resolve looks up the names of the domain IDs in the domains index of the
+// LMDB, if it has one, and removes the IDs that were found. Deleted index
+// entries are included, because the zone was still affected.
+//
+// Cached index keys are verified with a lookup, because a domain can be
+// removed or renamed locally. The index is only scanned if some IDs remain,
+// and the LMDB changed since the last scan or the cache is not complete.
+func (c *pdnsIDCache) resolve(env *lmdb.Env, ids map[uint32]bool, zones map[string]bool) error {
+	return lmdbenv.View(env, func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI(pdnsDomainsIndexDBI, 0)
+		if lmdb.IsNotFound(err) {
+			return nil // not the main LMDB
+		}
+		if err != nil {
+			return err
+		}
+		for id := range ids {
+			key, ok := c.keys[id]
+			if !ok {
+				continue
+			}
+			if _, err := txn.Get(dbi, key); err != nil {
+				if !lmdb.IsNotFound(err) {
+					return err
+				}
+				delete(c.keys, id)
+				continue
+			}
+			name, _, _ := pdnsParseIndexKey(key)
+			zones[name] = true
+			delete(ids, id)
+		}
+		if len(ids) == 0 || (c.complete && txn.ID() == c.scanTxnID) {
+			return nil
+		}
+
+		metricPDNSIndexScans.Inc()
+		c.keys = make(map[uint32][]byte)
+		c.scanTxnID = 0
+		c.complete = true
+		scan := lmdbscan.New(txn, dbi)
+		defer scan.Close()
+		for scan.Scan() {
+			name, id, ok := pdnsParseIndexKey(scan.Key())
+			if !ok {
+				continue
+			}
+			if ids[id] {
+				zones[name] = true
+				delete(ids, id)
+			}
+			if len(c.keys) >= pdnsIDCacheMax {
+				c.complete = false
+				continue
+			}
+			c.keys[id] = bytes.Clone(scan.Key())
+		}
+		if err := scan.Err(); err != nil {
+			return err
+		}
+		c.scanTxnID = txn.ID()
+		return nil
+	})
+}
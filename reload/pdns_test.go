@@ -0,0 +1,187 @@
+package reload
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pdnsIndexKey returns a domains index key for the reversed name
+func pdnsIndexKey(reversed string, id uint32) []byte {
+	key := binary.BigEndian.AppendUint16(nil, uint16(len(reversed)))
+	key = append(key, reversed...)
+	return binary.BigEndian.AppendUint32(key, id)
+}
+
+func pdnsIDKey(id uint32, rest string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, id), rest...)
+}
+
+func TestPdnsParseIndexKey(t *testing.T) {
+	name, id, ok := pdnsParseIndexKey(pdnsIndexKey("com\x00example\x00", 42))
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", name)
+	assert.Equal(t, uint32(42), id)
+
+	name, _, ok = pdnsParseIndexKey(pdnsIndexKey("", 1))
+	assert.True(t, ok)
+	assert.Equal(t, ".", name)
+
+	_, _, ok = pdnsParseIndexKey([]byte("\x00\x10short"))
+	assert.False(t, ok)
+}
+
+func TestPdnsZones(t *testing.T) {
+	zones := make(map[string]bool)
+	ids := make(map[uint32]bool)
+	pdnsZones(pdnsRecordsDBI, [][]byte{pdnsIDKey(1, "www"), pdnsIDKey(2, ""), []byte("x")}, zones, ids)
+	pdnsZones(pdnsDomainsDBI, [][]byte{pdnsIDKey(3, "")}, zones, ids)
+	pdnsZones(pdnsDomainsIndexDBI, [][]byte{pdnsIndexKey("org\x00example\x00", 4)}, zones, ids)
+	pdnsZones("metadata_v5", [][]byte{pdnsIDKey(5, "")}, zones, ids)
+	assert.Equal(t, map[string]bool{"example.org": true}, zones)
+	assert.Equal(t, map[uint32]bool{1: true, 2: true, 3: true}, ids)
+}
+
+// pdnsKeyConv converts a name like PowerDNS keyConv does for LMDB keys:
+// "www.example.com" becomes "com\x00example\x00www\x00", and the root
+// becomes "\x00".
+func pdnsKeyConv(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return "\x00"
+	}
+	labels := strings.Split(name, ".")
+	var b strings.Builder
+	for i := len(labels) - 1; i >= 0; i-- {
+		b.WriteString(labels[i])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// pdnsFixture writes the domains and some records like the PowerDNS Auth
+// LMDB backend with schema version 5 and Lightning Stream headers:
+//   - domains_v5: 4 byte big endian domain ID
+//   - domains_v5_0: 2 byte big endian name length, the converted zone name
+//     and the 4 byte big endian domain ID, with an empty value
+//   - records_v5: 4 byte big endian domain ID, the converted name relative to
+//     the zone, a zero byte and the 2 byte big endian record type
+func pdnsFixture(t *testing.T, env *lmdb.Env, domains map[uint32]string) {
+	const (
+		qtypeA   = 1
+		qtypeSOA = 6
+	)
+	hdr := func(txn *lmdb.Txn, val string) []byte {
+		b := make([]byte, header.MinHeaderSize, header.MinHeaderSize+len(val))
+		header.PutBasic(b, header.TimestampFromTime(time.Now()), header.TxnID(txn.ID()), header.NoFlags)
+		return append(b, val...)
+	}
+	recordKey := func(id uint32, name string, qtype uint16) []byte {
+		key := pdnsIDKey(id, pdnsKeyConv(name)+"\x00")
+		return binary.BigEndian.AppendUint16(key, qtype)
+	}
+	err := env.Update(func(txn *lmdb.Txn) error {
+		domainsDBI, err := txn.OpenDBI(pdnsDomainsDBI, lmdb.Create)
+		require.NoError(t, err)
+		indexDBI, err := txn.OpenDBI(pdnsDomainsIndexDBI, lmdb.Create)
+		require.NoError(t, err)
+		recordsDBI, err := txn.OpenDBI(pdnsRecordsDBI, lmdb.Create)
+		require.NoError(t, err)
+		for id, zone := range domains {
+			require.NoError(t, txn.Put(domainsDBI, pdnsIDKey(id, ""), hdr(txn, "domaininfo"), 0))
+			require.NoError(t, txn.Put(indexDBI, pdnsIndexKey(pdnsKeyConv(zone), id), hdr(txn, ""), 0))
+			require.NoError(t, txn.Put(recordsDBI, recordKey(id, "", qtypeSOA), hdr(txn, "soa"), 0))
+			require.NoError(t, txn.Put(recordsDBI, recordKey(id, "www", qtypeA), hdr(txn, "a"), 0))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPdnsFixture(t *testing.T) {
+	assert.Equal(t, "com\x00example\x00www\x00", pdnsKeyConv("www.example.com."))
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		pdnsFixture(t, env, map[uint32]string{1: "example.com", 2: "example.org"})
+		zones := make(map[string]bool)
+		ids := make(map[uint32]bool)
+		err := env.View(func(txn *lmdb.Txn) error {
+			for _, name := range []string{pdnsRecordsDBI, pdnsDomainsDBI, pdnsDomainsIndexDBI} {
+				dbi, err := txn.OpenDBI(name, 0)
+				require.NoError(t, err)
+				kvs, err := lmdbenv.ReadDBI(txn, dbi)
+				require.NoError(t, err)
+				var keys [][]byte
+				for _, kv := range kvs {
+					keys = append(keys, kv.Key)
+				}
+				pdnsZones(name, keys, zones, ids)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"example.com": true, "example.org": true}, zones)
+		assert.Equal(t, map[uint32]bool{1: true, 2: true}, ids)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPdnsIDCache_resolve(t *testing.T) {
+	scans := func() float64 {
+		var m dto.Metric
+		require.NoError(t, metricPDNSIndexScans.Write(&m))
+		return m.Counter.GetValue()
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		var c pdnsIDCache
+		zones := make(map[string]bool)
+		ids := map[uint32]bool{1: true, 2: true}
+
+		// No domains index, e.g. a records shard
+		scans0 := scans()
+		require.NoError(t, c.resolve(env, ids, zones))
+		assert.Empty(t, zones)
+		assert.Equal(t, scans0, scans())
+
+		pdnsFixture(t, env, map[uint32]string{1: "example.com", 3: "example.net"})
+		require.NoError(t, c.resolve(env, ids, zones))
+		assert.Equal(t, map[string]bool{"example.com": true}, zones)
+		assert.Equal(t, map[uint32]bool{2: true}, ids)
+		assert.Equal(t, scans0+1, scans())
+
+		// Cached IDs and unknown IDs do not scan again while the LMDB is
+		// unchanged
+		zones = make(map[string]bool)
+		ids = map[uint32]bool{1: true, 2: true, 3: true}
+		require.NoError(t, c.resolve(env, ids, zones))
+		assert.Equal(t, map[string]bool{"example.com": true, "example.net": true}, zones)
+		assert.Equal(t, map[uint32]bool{2: true}, ids)
+		assert.Equal(t, scans0+1, scans())
+
+		// A domain renamed locally is found with a new scan
+		err := env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI(pdnsDomainsIndexDBI, 0)
+			require.NoError(t, err)
+			require.NoError(t, txn.Del(dbi, pdnsIndexKey(pdnsKeyConv("example.net"), 3), nil))
+			return txn.Put(dbi, pdnsIndexKey(pdnsKeyConv("example.de"), 3), make([]byte, header.MinHeaderSize), 0)
+		})
+		require.NoError(t, err)
+		zones = make(map[string]bool)
+		ids = map[uint32]bool{1: true, 3: true}
+		require.NoError(t, c.resolve(env, ids, zones))
+		assert.Equal(t, map[string]bool{"example.com": true, "example.de": true}, zones)
+		assert.Empty(t, ids)
+		assert.Equal(t, scans0+2, scans())
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,345 @@
+// Package reload triggers an application reload after loads of remote
+// snapshots changed the LMDBs, for example to let PowerDNS flush the caches
+// of the affected zones. The changed keys per DBI, and optionally the names
+// of the affected PowerDNS zones, are delivered as JSON to a command, an HTTP
+// URL and/or a Unix socket. Consecutive loads are debounced into a single
+// trigger.
+package reload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/notify"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/sirupsen/logrus"
+)
+
+// Payload is the JSON delivered to the reload actions
+type Payload struct {
+	Time     time.Time               `json:"time"`
+	Instance string                  `json:"instance"` // our own instance
+	Loads    int                     `json:"loads"`    // number of loads with changes
+	LMDBs    map[string]*LMDBChanges `json:"lmdbs"`
+}
+
+// LMDBChanges are the changes to a single LMDB
+type LMDBChanges struct {
+	DBIs map[string]*DBIChanges `json:"dbis"`
+	// Zones are the names of the affected PowerDNS zones, if enabled
+	Zones []string `json:"zones,omitempty"`
+	// DomainIDs are affected PowerDNS domain IDs that could not be resolved
+	// to a zone name
+	DomainIDs []uint32 `json:"domain_ids,omitempty"`
+}
+
+// DBIChanges are the changes to a single DBI
+type DBIChanges struct {
+	Changed int      `json:"changed"`        // entries added, updated or deleted
+	Keys    [][]byte `json:"keys,omitempty"` // base64 encoded, sorted
+	// Truncated is set if not all changed keys are included, in which case
+	// the application should assume that everything changed.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// watchedEnv is the env of a watched LMDB
+type watchedEnv struct {
+	env *lmdb.Env
+	ids pdnsIDCache
+}
+
+// dbiPending collects the changes to a DBI until the next trigger
+type dbiPending struct {
+	changed   int
+	keys      map[string]struct{}
+	truncated bool
+}
+
+// Reloader debounces loads with changes and triggers the reload actions
+type Reloader struct {
+	conf      config.Reload
+	instance  string
+	userAgent string
+
+	mu      sync.Mutex
+	loads   int                               // loads since the last trigger
+	pending map[string]map[string]*dbiPending // by LMDB and DBI name
+
+	// envsMu protects envs, which are used to resolve PowerDNS domain IDs
+	envsMu sync.RWMutex
+	envs   map[string]*watchedEnv // by LMDB name
+
+	kick    chan struct{} // signals new pending changes
+	stop    chan struct{}
+	watches []<-chan struct{} // closed when done
+	ctx     context.Context   // canceled on Close
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the trigger loop is done
+}
+
+// New creates a Reloader for the actions in the config, which must be
+// enabled, and starts the trigger loop.
+func New(c config.Config) *Reloader {
+	r := &Reloader{
+		conf:      c.Reload,
+		instance:  c.Instance,
+		userAgent: "lightningstream/" + c.Version,
+		pending:   make(map[string]map[string]*dbiPending),
+		envs:      make(map[string]*watchedEnv),
+		kick:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run()
+	logrus.WithFields(logrus.Fields{
+		"debounce":       r.conf.Debounce,
+		"max_delay":      r.conf.MaxDelay,
+		"powerdns_zones": r.conf.PowerDNSZones,
+	}).Info("Reload trigger enabled")
+	return r
+}
+
+// Watch collects the changes of the loads by the syncer of the named LMDB
+// until the Reloader is closed. The env is used to resolve PowerDNS domain
+// IDs, and must be released with Forget before it is closed. An env reopened
+// by the syncer replaces it.
+func (r *Reloader) Watch(lmdbName string, env *lmdb.Env, ev *events.Events) {
+	r.setEnv(lmdbName, env)
+	r.watches = append(r.watches, ev.UpdateLoaded.HandleUntil(r.stop, func(info events.UpdateInfo) {
+		r.add(lmdbName, info.DBIStats)
+	}))
+	r.watches = append(r.watches, ev.EnvReopened.HandleUntil(r.stop, func(env *lmdb.Env) {
+		r.setEnv(lmdbName, env)
+	}))
+}
+
+func (r *Reloader) setEnv(lmdbName string, env *lmdb.Env) {
+	r.envsMu.Lock()
+	r.envs[lmdbName] = &watchedEnv{env: env}
+	r.envsMu.Unlock()
+}
+
+// Forget stops using the env of the named LMDB
+func (r *Reloader) Forget(lmdbName string) {
+	r.envsMu.Lock()
+	delete(r.envs, lmdbName)
+	r.envsMu.Unlock()
+}
+
+// add adds the changes of a load to the pending changes
+func (r *Reloader) add(lmdbName string, stats map[string]events.DBIStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := false
+	for dbiName, st := range stats {
+		if st.Changed() == 0 {
+			continue
+		}
+		changed = true
+		dbis := r.pending[lmdbName]
+		if dbis == nil {
+			dbis = make(map[string]*dbiPending)
+			r.pending[lmdbName] = dbis
+		}
+		dp := dbis[dbiName]
+		if dp == nil {
+			dp = &dbiPending{keys: make(map[string]struct{})}
+			dbis[dbiName] = dp
+		}
+		dp.changed += st.Changed()
+		dp.truncated = dp.truncated || st.KeysTruncated
+		for _, key := range st.ChangedKeys {
+			if len(dp.keys) >= r.conf.MaxKeys {
+				dp.truncated = true
+				break
+			}
+			dp.keys[string(key)] = struct{}{}
+		}
+	}
+	if !changed {
+		return
+	}
+	r.loads++
+	select {
+	case r.kick <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// run triggers the actions once no new changes were added for the debounce
+// duration, or when the changes have been pending for the max delay.
+func (r *Reloader) run() {
+	defer close(r.done)
+	timer := time.NewTimer(0)
+	timer.Stop()
+	var first time.Time // of the pending changes
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.kick:
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			deadline := now.Add(r.conf.Debounce)
+			if maxDeadline := first.Add(r.conf.MaxDelay); deadline.After(maxDeadline) {
+				deadline = maxDeadline
+			}
+			timer.Reset(time.Until(deadline))
+		case <-timer.C:
+			first = time.Time{}
+			r.trigger()
+		}
+	}
+}
+
+// trigger delivers the pending changes to the actions
+func (r *Reloader) trigger() {
+	p := r.takePending()
+	if p.Loads == 0 {
+		return // already delivered with the previous trigger
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		logrus.WithError(err).Error("Reload payload marshal failed") // should never happen
+		return
+	}
+	l := logrus.WithField("loads", p.Loads)
+	r.deliver(l, "command", len(r.conf.Command) > 0, func(ctx context.Context) error {
+		return notify.RunCommand(ctx, r.conf.Command, nil, data)
+	})
+	r.deliver(l, "url", r.conf.URL != "", func(ctx context.Context) error {
+		return notify.PostJSON(ctx, r.conf.URL, r.conf.Headers, r.userAgent, data)
+	})
+	r.deliver(l, "socket", r.conf.Socket != "", func(ctx context.Context) error {
+		return writeSocket(ctx, r.conf.Socket, data)
+	})
+}
+
+func (r *Reloader) deliver(l logrus.FieldLogger, kind string, enabled bool, fn func(ctx context.Context) error) {
+	if !enabled {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, r.conf.Timeout)
+	defer cancel()
+	if err := fn(ctx); err != nil {
+		metricTriggers.WithLabelValues(kind, "failed").Inc()
+		l.WithError(err).WithField("kind", kind).Warn("Reload trigger failed")
+		return
+	}
+	metricTriggers.WithLabelValues(kind, "ok").Inc()
+	l.WithField("kind", kind).Info("Reload triggered")
+}
+
+// takePending returns the payload for the pending changes and resets them
+func (r *Reloader) takePending() Payload {
+	r.mu.Lock()
+	pending := r.pending
+	loads := r.loads
+	r.pending = make(map[string]map[string]*dbiPending)
+	r.loads = 0
+	r.mu.Unlock()
+
+	p := Payload{
+		Time:     time.Now().UTC(),
+		Instance: r.instance,
+		Loads:    loads,
+		LMDBs:    make(map[string]*LMDBChanges, len(pending)),
+	}
+	for lmdbName, dbis := range pending {
+		lc := &LMDBChanges{
+			DBIs: make(map[string]*DBIChanges, len(dbis)),
+		}
+		zones := make(map[string]bool)
+		ids := make(map[uint32]bool)
+		for dbiName, dp := range dbis {
+			keys := make([][]byte, 0, len(dp.keys))
+			for key := range dp.keys {
+				keys = append(keys, []byte(key))
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			})
+			lc.DBIs[dbiName] = &DBIChanges{
+				Changed:   dp.changed,
+				Keys:      keys,
+				Truncated: dp.truncated,
+			}
+			if r.conf.PowerDNSZones {
+				pdnsZones(dbiName, keys, zones, ids)
+			}
+		}
+		if len(ids) > 0 {
+			r.resolveDomainIDs(ids, zones)
+		}
+		for name := range zones {
+			lc.Zones = append(lc.Zones, name)
+		}
+		for id := range ids {
+			lc.DomainIDs = append(lc.DomainIDs, id)
+		}
+		slices.Sort(lc.Zones)
+		slices.Sort(lc.DomainIDs)
+		p.LMDBs[lmdbName] = lc
+	}
+	return p
+}
+
+// resolveDomainIDs looks up the PowerDNS zone names of the domain IDs in all
+// LMDBs, because the records shards do not have the domains index.
+func (r *Reloader) resolveDomainIDs(ids map[uint32]bool, zones map[string]bool) {
+	r.envsMu.RLock()
+	defer r.envsMu.RUnlock()
+	for lmdbName, we := range r.envs {
+		if len(ids) == 0 {
+			return
+		}
+		if err := we.ids.resolve(we.env, ids, zones); err != nil {
+			logrus.WithError(err).WithField("db", lmdbName).Warn(
+				"Could not resolve PowerDNS domain IDs")
+		}
+	}
+}
+
+// writeSocket writes the data as a single line to a Unix stream socket
+func writeSocket(ctx context.Context, path string, data []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// Close stops collecting changes and stops the trigger loop. Pending changes
+// are discarded.
+func (r *Reloader) Close() {
+	close(r.stop)
+	for _, done := range r.watches {
+		<-done
+	}
+	r.cancel()
+	<-r.done
+}
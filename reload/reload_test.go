@@ -0,0 +1,147 @@
+package reload
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() config.Config {
+	c := config.Default()
+	c.Instance = "self"
+	c.Version = "test"
+	c.Reload.Debounce = 100 * time.Millisecond
+	return c
+}
+
+// listen returns a Unix socket path and a channel with the received lines
+func listen(t *testing.T) (string, <-chan []byte) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	lines := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sc := bufio.NewScanner(conn)
+			sc.Buffer(nil, 1<<20)
+			for sc.Scan() {
+				lines <- append([]byte(nil), sc.Bytes()...)
+			}
+			_ = conn.Close()
+		}
+	}()
+	return path, lines
+}
+
+func receive(t *testing.T, lines <-chan []byte) Payload {
+	var p Payload
+	select {
+	case line := <-lines:
+		require.NoError(t, json.Unmarshal(line, &p))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reload trigger")
+	}
+	return p
+}
+
+func TestReloader_socket(t *testing.T) {
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		path, lines := listen(t)
+		c := testConfig()
+		c.Reload.Socket = path
+		c.Reload.MaxKeys = 2
+		r := New(c)
+		defer r.Close()
+
+		ev := events.New()
+		r.Watch("main", env, ev)
+		defer r.Forget("main")
+
+		// Consecutive loads are combined into a single trigger
+		ev.UpdateLoaded.Publish(events.UpdateInfo{DBIStats: map[string]events.DBIStats{
+			"foo": {Added: 1, ChangedKeys: [][]byte{[]byte("b")}},
+			"bar": {Unchanged: 5},
+		}})
+		ev.UpdateLoaded.Publish(events.UpdateInfo{DBIStats: map[string]events.DBIStats{
+			"bar": {Skipped: 1}, // no changes
+		}})
+		ev.UpdateLoaded.Publish(events.UpdateInfo{DBIStats: map[string]events.DBIStats{
+			"foo": {Updated: 1, Deleted: 1, ChangedKeys: [][]byte{[]byte("b"), []byte("a")}},
+		}})
+		ev.UpdateLoaded.Publish(events.UpdateInfo{DBIStats: map[string]events.DBIStats{
+			"foo": {Updated: 1, ChangedKeys: [][]byte{[]byte("c")}},
+		}})
+
+		p := receive(t, lines)
+		assert.False(t, p.Time.IsZero())
+		p.Time = time.Time{}
+		assert.Equal(t, Payload{
+			Instance: "self",
+			Loads:    3,
+			LMDBs: map[string]*LMDBChanges{
+				"main": {
+					DBIs: map[string]*DBIChanges{
+						"foo": {
+							Changed:   4,
+							Keys:      [][]byte{[]byte("a"), []byte("b")},
+							Truncated: true,
+						},
+					},
+				},
+			},
+		}, p)
+		assert.Empty(t, lines)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestReloader_powerDNSZones(t *testing.T) {
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		pdnsFixture(t, env, map[uint32]string{1: "example.com"})
+
+		path, lines := listen(t)
+		c := testConfig()
+		c.Reload.Socket = path
+		c.Reload.PowerDNSZones = true
+		r := New(c)
+		defer r.Close()
+
+		ev := events.New()
+		r.Watch("main", env, ev)
+		defer r.Forget("main")
+		shardEv := events.New()
+		err := lmdbenv.TestEnv(func(shardEnv *lmdb.Env) error {
+			r.Watch("shard", shardEnv, shardEv)
+			defer r.Forget("shard")
+			shardEv.UpdateLoaded.Publish(events.UpdateInfo{DBIStats: map[string]events.DBIStats{
+				pdnsRecordsDBI: {Added: 2, ChangedKeys: [][]byte{pdnsIDKey(1, "www"), pdnsIDKey(7, "www")}},
+			}})
+			return nil
+		})
+		require.NoError(t, err)
+
+		p := receive(t, lines)
+		shard := p.LMDBs["shard"]
+		require.NotNil(t, shard)
+		assert.Equal(t, []string{"example.com"}, shard.Zones)
+		assert.Equal(t, []uint32{7}, shard.DomainIDs)
+		return nil
+	})
+	require.NoError(t, err)
+}
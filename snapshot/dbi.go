@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -154,6 +155,13 @@ func (d *DBI) Marshal() []byte {
 	return d.data[:l:l]
 }
 
+// Digest returns the SHA-256 digest of the protobuf message, which includes
+// the top-level fields.
+func (d *DBI) Digest() []byte {
+	sum := sha256.Sum256(d.Marshal())
+	return sum[:]
+}
+
 // Size returns the size of the protobuf message
 // This implicitly calls flushFields, which will prevent further changes to
 // the top-level fields.
@@ -284,7 +292,7 @@ func (d *DBI) indexData() error {
 			offset += n
 			d.flags = v
 		default:
-			n, err := skipTag(data, wireType)
+			n, err := skipTag(data[offset:], wireType)
 			if err != nil {
 				return err
 			}
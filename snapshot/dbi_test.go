@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/CrowdStrike/csproto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func makeTestDBI(n int) *DBI {
@@ -66,6 +68,43 @@ func TestDBI(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 }
 
+func TestDBI_unknownFields(t *testing.T) {
+	pbdata := makeTestDBI(10).Marshal()
+
+	// Unknown fields from a future version are skipped, also before known fields
+	extra := make([]byte, 100)
+	offset := 0
+	offset += csproto.EncodeTag(extra[offset:], 15, csproto.WireTypeLengthDelimited)
+	offset += csproto.EncodeVarint(extra[offset:], 3)
+	offset += copy(extra[offset:], "abc")
+	offset += csproto.EncodeTag(extra[offset:], 14, csproto.WireTypeVarint)
+	offset += csproto.EncodeVarint(extra[offset:], 12345)
+	pbdata = append(extra[:offset], pbdata...)
+
+	d, err := NewDBIFromData(pbdata)
+	require.NoError(t, err)
+	assert.Equal(t, "test-name", d.Name())
+	assert.Equal(t, uint64(42), d.Flags())
+	n := 0
+	for {
+		_, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, 10, n)
+}
+
+func TestDBI_Digest(t *testing.T) {
+	d1 := makeTestDBI(10)
+	d2 := makeTestDBI(10)
+	assert.Len(t, d1.Digest(), 32)
+	assert.Equal(t, d1.Digest(), d2.Digest())
+	assert.NotEqual(t, d1.Digest(), makeTestDBI(11).Digest())
+}
+
 func BenchmarkDBI_Next(b *testing.B) {
 	d := makeTestDBI(1_000_000)
 	d.ResetCursor()
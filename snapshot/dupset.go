@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/CrowdStrike/csproto"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+)
+
+// Protobuf field numbers
+const (
+	FieldDupSetValues = 1
+)
+
+// DupValue is a single value of a DupSort key, with its own timestamp and
+// flags. A DupSort key with all its values is stored as a single KV with the
+// TransformDupSortSetV1 transform, with the encoded set as the value.
+type DupValue struct {
+	Value         []byte
+	TimestampNano uint64
+	Flags         uint32
+}
+
+func (v *DupValue) MaskedFlags() header.Flags {
+	return header.Flags(v.Flags).Masked()
+}
+
+// EncodeDupSet encodes a set of DupSort values.
+// The encoding is a protobuf message with a repeated KV field (1), without the
+// KV.Key. The values are expected to be sorted by value, this is not checked.
+// Unlike keys, values of any size are supported.
+func EncodeDupSet(vals []DupValue) []byte {
+	size := 0
+	for _, v := range vals {
+		msgSize := dupValueSize(v)
+		size += TagSize0To15 + csproto.SizeOfVarint(uint64(msgSize)) + msgSize
+	}
+	data := make([]byte, size)
+	offset := 0
+	for _, v := range vals {
+		offset += csproto.EncodeTag(data[offset:], FieldDupSetValues, csproto.WireTypeLengthDelimited)
+		offset += csproto.EncodeVarint(data[offset:], uint64(dupValueSize(v)))
+		if len(v.Value) > 0 {
+			offset += csproto.EncodeTag(data[offset:], FieldKVValue, csproto.WireTypeLengthDelimited)
+			offset += csproto.EncodeVarint(data[offset:], uint64(len(v.Value)))
+			offset += copy(data[offset:], v.Value)
+		}
+		if v.Flags > 0 {
+			offset += csproto.EncodeTag(data[offset:], FieldKVFlags, csproto.WireTypeVarint)
+			offset += csproto.EncodeVarint(data[offset:], uint64(v.Flags))
+		}
+		if v.TimestampNano > 0 {
+			offset += csproto.EncodeTag(data[offset:], FieldKVTimestampNano, csproto.WireTypeFixed64)
+			binary.LittleEndian.PutUint64(data[offset:offset+8], v.TimestampNano)
+			offset += 8
+		}
+	}
+	return data
+}
+
+func dupValueSize(v DupValue) int {
+	var msgSize = 0
+	if len(v.Value) > 0 {
+		msgSize += TagSize0To15
+		msgSize += csproto.SizeOfVarint(uint64(len(v.Value)))
+		msgSize += len(v.Value)
+	}
+	if v.Flags > 0 {
+		msgSize += TagSize0To15
+		msgSize += csproto.SizeOfVarint(uint64(v.Flags))
+	}
+	if v.TimestampNano > 0 {
+		msgSize += TagSize0To15 + 8 // fixed
+	}
+	return msgSize
+}
+
+// DecodeDupSet decodes a set of DupSort values encoded with EncodeDupSet.
+// The returned values point into data.
+func DecodeDupSet(data []byte) ([]DupValue, error) {
+	var vals []DupValue
+	offset := 0
+	for offset < len(data) {
+		// Get the tag and type
+		v, n, err := csproto.DecodeVarint(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+		tag := int(v >> 3)
+		wireType := csproto.WireType(v & 0x7)
+
+		if tag != FieldDupSetValues {
+			n, err := skipTag(data[offset:], wireType)
+			if err != nil {
+				return nil, err
+			}
+			offset += n
+			continue
+		}
+		if err := expectWT(tag, wireType, csproto.WireTypeLengthDelimited); err != nil {
+			return nil, err
+		}
+		// Get the length
+		v, n, err = csproto.DecodeVarint(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+		size := int(v)
+		if len(data)-offset < size {
+			return nil, fmt.Errorf("remaining data to short for indicated size")
+		}
+		b := data[offset : offset+size : offset+size]
+		offset += size
+
+		var kv KV
+		if len(b) > 0 { // an empty value without timestamp is an empty message
+			if err := kv.Unmarshal(b); err != nil {
+				return nil, err
+			}
+		}
+		vals = append(vals, DupValue{
+			Value:         kv.Value,
+			TimestampNano: kv.TimestampNano,
+			Flags:         kv.Flags,
+		})
+	}
+	return vals, nil
+}
@@ -0,0 +1,37 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDupSet(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 2000)
+	vals := []DupValue{
+		{Value: []byte(""), TimestampNano: 0, Flags: 0},
+		{Value: []byte("a"), TimestampNano: 1234, Flags: 0},
+		{Value: []byte("b"), TimestampNano: 5678, Flags: 1},
+		{Value: large, TimestampNano: 9999, Flags: 0},
+	}
+	data := EncodeDupSet(vals)
+	got, err := DecodeDupSet(data)
+	require.NoError(t, err)
+	require.Len(t, got, len(vals))
+	for i, v := range vals {
+		assert.Equal(t, string(v.Value), string(got[i].Value), "value %d", i)
+		assert.Equal(t, v.TimestampNano, got[i].TimestampNano, "ts %d", i)
+		assert.Equal(t, v.Flags, got[i].Flags, "flags %d", i)
+	}
+
+	// Empty set
+	got, err = DecodeDupSet(EncodeDupSet(nil))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// Truncated data
+	_, err = DecodeDupSet(data[:len(data)-3])
+	assert.Error(t, err)
+}
@@ -41,6 +41,17 @@ func LoadData(data []byte) (*Snapshot, error) {
 
 // DumpData returns a compressed Snapshot.
 func DumpData(msg *Snapshot) ([]byte, DumpDataStats, error) {
+	return DumpDataParallel(msg, 1)
+}
+
+// DumpDataParallel returns a compressed Snapshot, compressed by up to the
+// given number of concurrent workers. With one worker or less, the data is
+// compressed serially as a single gzip stream.
+func DumpDataParallel(msg *Snapshot, workers int) ([]byte, DumpDataStats, error) {
+	if workers > 1 {
+		return dumpDataParallel(msg, workers)
+	}
+
 	var stat DumpDataStats
 	t0 := time.Now()
 
@@ -77,6 +88,79 @@ func DumpData(msg *Snapshot) ([]byte, DumpDataStats, error) {
 	return compressedData, stat, nil
 }
 
+func dumpDataParallel(msg *Snapshot, workers int) ([]byte, DumpDataStats, error) {
+	var stat DumpDataStats
+	t0 := time.Now()
+
+	pw := newParallelGzipWriter(gzip.BestSpeed, workers, ParallelChunkSize)
+	pbSize, err := msg.WriteTo(pw)
+	if err != nil {
+		_, _ = pw.Close() // wait for workers
+		return nil, stat, err
+	}
+	stat.ProtobufSize = datasize.ByteSize(pbSize)
+
+	compressedData, err := pw.Close()
+	if err != nil {
+		return nil, stat, err
+	}
+	stat.TCompressed = time.Since(t0)
+	stat.CompressedSize = datasize.ByteSize(len(compressedData))
+	return compressedData, stat, nil
+}
+
+// DumpDataTo writes a compressed Snapshot to w, compressed by up to the given
+// number of concurrent workers like DumpDataParallel. Unlike DumpData, the
+// compressed data is streamed instead of returned, so that large snapshots
+// do not need to be held in memory twice.
+func DumpDataTo(w io.Writer, msg *Snapshot, workers int) (DumpDataStats, error) {
+	var stat DumpDataStats
+	t0 := time.Now()
+
+	cw := &countingWriter{w: w}
+	var pbSize int64
+	if workers > 1 {
+		pw := newParallelGzipWriterTo(cw, gzip.BestSpeed, workers, ParallelChunkSize)
+		var err error
+		pbSize, err = msg.WriteTo(pw)
+		if err != nil {
+			_, _ = pw.Close() // wait for workers
+			return stat, err
+		}
+		if _, err := pw.Close(); err != nil {
+			return stat, err
+		}
+	} else {
+		gw, err := gzip.NewWriterLevel(cw, gzip.BestSpeed)
+		if err != nil {
+			return stat, err
+		}
+		pbSize, err = msg.WriteTo(gw)
+		if err != nil {
+			return stat, err
+		}
+		if err := gw.Close(); err != nil {
+			return stat, err
+		}
+	}
+	stat.ProtobufSize = datasize.ByteSize(pbSize)
+	stat.TCompressed = time.Since(t0)
+	stat.CompressedSize = datasize.ByteSize(cw.n)
+	return stat, nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 type DumpDataStats struct {
 	TCompressed    time.Duration     // time it took to marshal (near 0) and compress
 	ProtobufSize   datasize.ByteSize // uncompressed protobuf size
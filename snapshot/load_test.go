@@ -1,13 +1,48 @@
 package snapshot
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestDumpDataParallel(t *testing.T) {
+	snap := makeTestSnapshot(200_000)
+	for _, workers := range []int{1, 2, 4} {
+		data, st, err := DumpDataParallel(snap, workers)
+		require.NoError(t, err, "workers %d", workers)
+		if workers > 1 {
+			assert.Greater(t, int(st.ProtobufSize), ParallelChunkSize, "multiple chunks")
+		}
+
+		loaded, err := LoadData(data)
+		require.NoError(t, err, "workers %d", workers)
+		assert.Equal(t, snap.Meta, loaded.Meta)
+		require.Len(t, loaded.Databases, 1)
+		assert.Equal(t, snap.Databases[0].Marshal(), loaded.Databases[0].Marshal())
+	}
+
+	// Empty snapshot
+	data, _, err := DumpDataParallel(&Snapshot{}, 4)
+	require.NoError(t, err)
+	loaded, err := LoadData(data)
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Databases)
+}
+
 func BenchmarkDumpData_1M_entries(b *testing.B) {
+	doBenchmarkDumpData(b, 1)
+}
+
+func BenchmarkDumpData_1M_entries_4_workers(b *testing.B) {
+	doBenchmarkDumpData(b, 4)
+}
+
+func doBenchmarkDumpData(b *testing.B, workers int) {
 	// Keep in mind that is basically just testing the compression speed,
 	// as that is by far the bottleneck here now.
 	const entries = 1_000_000
@@ -18,7 +53,7 @@ func BenchmarkDumpData_1M_entries(b *testing.B) {
 	b.ResetTimer()
 	t := time.Now()
 	for i := 0; i < b.N; i++ {
-		data, st, err := DumpData(snap)
+		data, st, err := DumpDataParallel(snap, workers)
 		if len(data) < 1*MB {
 			b.Fatal("snapshot too small", len(data))
 		}
@@ -32,3 +67,31 @@ func BenchmarkDumpData_1M_entries(b *testing.B) {
 	b.ReportMetric(float64(compressed)/MB/dt.Seconds(), "compressed_MB/s")
 	b.ReportMetric(float64(b.N)/dt.Seconds(), "Mentries/s")
 }
+
+func TestDumpDataTo(t *testing.T) {
+	snap := makeTestSnapshot(200_000)
+	for _, workers := range []int{1, 2, 4} {
+		var buf bytes.Buffer
+		st, err := DumpDataTo(&buf, snap, workers)
+		require.NoError(t, err, "workers %d", workers)
+		assert.Equal(t, buf.Len(), int(st.CompressedSize))
+
+		loaded, err := LoadData(buf.Bytes())
+		require.NoError(t, err, "workers %d", workers)
+		assert.Equal(t, snap.Meta, loaded.Meta)
+		require.Len(t, loaded.Databases, 1)
+		assert.Equal(t, snap.Databases[0].Marshal(), loaded.Databases[0].Marshal())
+	}
+
+	// Errors from the destination are returned
+	_, err := DumpDataTo(failingWriter{}, snap, 4)
+	assert.ErrorIs(t, err, errFailingWriter)
+}
+
+var errFailingWriter = errors.New("write failed")
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errFailingWriter
+}
@@ -15,6 +15,14 @@ const (
 	FieldMetaTimestampNano = 5
 	FieldMetaDatabaseName  = 7
 	FieldMetaFromLMDBTxnID = 8
+	FieldMetaSchemaVersion = 9
+	FieldMetaDBIDigests    = 10
+)
+
+// Protobuf field numbers for DBIDigest
+const (
+	FieldDBIDigestName   = 1
+	FieldDBIDigestSHA256 = 2
 )
 
 type Meta struct {
@@ -25,6 +33,27 @@ type Meta struct {
 	TimestampNano uint64
 	DatabaseName  string
 	FromLmdbTxnID int64
+	SchemaVersion uint32 // application schema version, see config.LMDB
+	DBIDigests    []DBIDigest
+}
+
+// DBIDigest is a digest of the contents of a DBI in the snapshot, which
+// allows a receiver to skip DBIs that did not change since the last snapshot
+// of the same instance. These are stored in the Meta instead of the DBI,
+// because older versions cannot skip unknown DBI fields.
+type DBIDigest struct {
+	Name   string
+	SHA256 []byte
+}
+
+// DBIDigest returns the digest for the named DBI, or nil if not present
+func (m *Meta) DBIDigest(name string) []byte {
+	for _, d := range m.DBIDigests {
+		if d.Name == name {
+			return d.SHA256
+		}
+	}
+	return nil
 }
 
 func (m *Meta) Marshal() []byte {
@@ -43,6 +72,9 @@ func (m *Meta) Marshal() []byte {
 	for _, sf := range stringFields {
 		bufSizeNeeded += len(sf.val) + 20
 	}
+	for _, d := range m.DBIDigests {
+		bufSizeNeeded += len(d.Name) + len(d.SHA256) + 20
+	}
 	bufSizeNeeded += 1000 // generous enough for the numeric fields
 	b := make([]byte, bufSizeNeeded)
 	offset := 0
@@ -68,6 +100,22 @@ func (m *Meta) Marshal() []byte {
 		offset += csproto.EncodeTag(b[offset:], FieldMetaFromLMDBTxnID, csproto.WireTypeVarint)
 		offset += csproto.EncodeVarint(b[offset:], uint64(m.FromLmdbTxnID))
 	}
+	if m.SchemaVersion > 0 {
+		offset += csproto.EncodeTag(b[offset:], FieldMetaSchemaVersion, csproto.WireTypeVarint)
+		offset += csproto.EncodeVarint(b[offset:], uint64(m.SchemaVersion))
+	}
+	for _, d := range m.DBIDigests {
+		size := TagSize0To15 + csproto.SizeOfVarint(uint64(len(d.Name))) + len(d.Name) +
+			TagSize0To15 + csproto.SizeOfVarint(uint64(len(d.SHA256))) + len(d.SHA256)
+		offset += csproto.EncodeTag(b[offset:], FieldMetaDBIDigests, csproto.WireTypeLengthDelimited)
+		offset += csproto.EncodeVarint(b[offset:], uint64(size))
+		offset += csproto.EncodeTag(b[offset:], FieldDBIDigestName, csproto.WireTypeLengthDelimited)
+		offset += csproto.EncodeVarint(b[offset:], uint64(len(d.Name)))
+		offset += copy(b[offset:], d.Name)
+		offset += csproto.EncodeTag(b[offset:], FieldDBIDigestSHA256, csproto.WireTypeLengthDelimited)
+		offset += csproto.EncodeVarint(b[offset:], uint64(len(d.SHA256)))
+		offset += copy(b[offset:], d.SHA256)
+	}
 
 	return b[:offset]
 }
@@ -116,6 +164,49 @@ func (m *Meta) Unmarshal(data []byte) error {
 			if err != nil {
 				return err
 			}
+		case FieldMetaSchemaVersion:
+			m.SchemaVersion, err = getUInt32(d, tag, wireType)
+			if err != nil {
+				return err
+			}
+		case FieldMetaDBIDigests:
+			b, err := getBytes(d, tag, wireType)
+			if err != nil {
+				return err
+			}
+			var dd DBIDigest
+			if err := dd.Unmarshal(b); err != nil {
+				return err
+			}
+			m.DBIDigests = append(m.DBIDigests, dd)
+		default:
+			if _, err := d.Skip(tag, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dd *DBIDigest) Unmarshal(data []byte) error {
+	d := csproto.NewDecoder(data)
+	d.SetMode(csproto.DecoderModeFast)
+	for d.More() {
+		tag, wireType, err := d.DecodeTag()
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case FieldDBIDigestName:
+			dd.Name, err = getString(d, tag, wireType)
+			if err != nil {
+				return err
+			}
+		case FieldDBIDigestSHA256:
+			dd.SHA256, err = getBytes(d, tag, wireType)
+			if err != nil {
+				return err
+			}
 		default:
 			if _, err := d.Skip(tag, wireType); err != nil {
 				return err
@@ -16,6 +16,11 @@ func makeTestMeta() Meta {
 		TimestampNano: ts,
 		DatabaseName:  "db",
 		FromLmdbTxnID: 42,
+		SchemaVersion: 6,
+		DBIDigests: []DBIDigest{
+			{Name: "foo", SHA256: []byte("0123456789abcdef0123456789abcdef")},
+			{Name: "bar", SHA256: []byte("fedcba9876543210fedcba9876543210")},
+		},
 	}
 }
 
@@ -31,4 +36,6 @@ func TestMeta(t *testing.T) {
 	err := loaded.Unmarshal(pb)
 	assert.NoError(t, err)
 	assert.Equal(t, orig, loaded)
+	assert.Equal(t, orig.DBIDigests[1].SHA256, loaded.DBIDigest("bar"))
+	assert.Nil(t, loaded.DBIDigest("missing"))
 }
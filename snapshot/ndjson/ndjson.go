@@ -0,0 +1,164 @@
+// Package ndjson converts snapshot contents to and from newline-delimited JSON
+// (NDJSON), for inspection with tools like jq and for bulk imports.
+//
+// Every line is a self-contained Record. Keys and values are base64 encoded,
+// which is how encoding/json handles []byte. A Record without a key only
+// declares a DBI, which allows empty DBIs to be represented.
+package ndjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/PowerDNS/lightningstream/snapshot"
+)
+
+// MaxLineSize is the maximum size of a single NDJSON line we accept when reading.
+// LMDB values can be large, and base64 adds a third.
+const MaxLineSize = 256 * 1024 * 1024
+
+// Record is a single NDJSON line
+type Record struct {
+	DBI       string `json:"dbi"`
+	Key       []byte `json:"key,omitempty"`
+	Value     []byte `json:"value,omitempty"`
+	Timestamp uint64 `json:"timestamp,omitempty"` // nanoseconds since UNIX epoch
+	Flags     uint32 `json:"flags,omitempty"`     // LS header flags, like deleted
+	DBIFlags  uint64 `json:"dbi_flags,omitempty"` // LMDB DBI flags
+	Transform string `json:"transform,omitempty"` // snapshot DBI transform
+}
+
+// Writer writes snapshot DBIs as NDJSON records
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter creates a new Writer that writes to w
+func NewWriter(w io.Writer) *Writer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &Writer{enc: enc}
+}
+
+// Write writes a single record
+func (w *Writer) Write(r Record) error {
+	return w.enc.Encode(r)
+}
+
+// WriteDBI writes all entries of a snapshot DBI. If the DBI is empty, a
+// single record without key is written to declare the DBI.
+func (w *Writer) WriteDBI(dbiMsg *snapshot.DBI) error {
+	rec := Record{
+		DBI:       dbiMsg.Name(),
+		DBIFlags:  dbiMsg.Flags(),
+		Transform: dbiMsg.Transform(),
+	}
+	n := 0
+	dbiMsg.ResetCursor()
+	for {
+		kv, err := dbiMsg.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		rec.Key = kv.Key
+		rec.Value = kv.Value
+		rec.Timestamp = kv.TimestampNano
+		rec.Flags = kv.Flags
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return w.Write(rec)
+	}
+	return nil
+}
+
+// WriteSnapshot writes all DBIs in the snapshot, optionally filtered by
+// DBI name if dbiName is not empty.
+func (w *Writer) WriteSnapshot(snap *snapshot.Snapshot, dbiName string) error {
+	for _, dbiMsg := range snap.Databases {
+		if dbiName != "" && dbiMsg.Name() != dbiName {
+			continue
+		}
+		if err := w.WriteDBI(dbiMsg); err != nil {
+			return fmt.Errorf("dbi %s: %w", dbiMsg.Name(), err)
+		}
+	}
+	return nil
+}
+
+// ReadOptions influence how NDJSON is converted into a snapshot
+type ReadOptions struct {
+	// DefaultTimestamp is used for records without a timestamp
+	DefaultTimestamp uint64
+}
+
+// Read reads NDJSON records from r into snapshot DBIs. DBIs are returned
+// in the order in which they first appear in the input.
+// All records of a DBI must agree on the DBI flags and transform.
+func Read(r io.Reader, opt ReadOptions) ([]*snapshot.DBI, error) {
+	var dbis []*snapshot.DBI
+	byName := make(map[string]*snapshot.DBI)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if rec.DBI == "" {
+			return nil, fmt.Errorf("line %d: %w", lineNo, ErrNoDBI)
+		}
+
+		dbiMsg, exists := byName[rec.DBI]
+		if !exists {
+			dbiMsg = snapshot.NewDBI()
+			dbiMsg.SetName(rec.DBI)
+			dbiMsg.SetFlags(rec.DBIFlags)
+			dbiMsg.SetTransform(rec.Transform)
+			byName[rec.DBI] = dbiMsg
+			dbis = append(dbis, dbiMsg)
+		} else if dbiMsg.Flags() != rec.DBIFlags || dbiMsg.Transform() != rec.Transform {
+			return nil, fmt.Errorf(
+				"line %d: dbi %s: dbi_flags or transform differ from earlier records",
+				lineNo, rec.DBI)
+		}
+
+		if len(rec.Key) == 0 {
+			continue // only declares the DBI
+		}
+		ts := rec.Timestamp
+		if ts == 0 {
+			ts = opt.DefaultTimestamp
+		}
+		dbiMsg.Append(snapshot.KV{
+			Key:           rec.Key,
+			Value:         rec.Value,
+			TimestampNano: ts,
+			Flags:         rec.Flags,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineNo+1, err)
+	}
+	return dbis, nil
+}
+
+// ErrNoDBI is returned when a record does not have a DBI name
+var ErrNoDBI = errors.New("record without dbi name")
@@ -0,0 +1,71 @@
+package ndjson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	d1 := snapshot.NewDBI()
+	d1.SetName("foo")
+	d1.SetFlags(8)
+	d1.Append(snapshot.KV{Key: []byte("a"), Value: []byte("x\x00y"), TimestampNano: 10})
+	d1.Append(snapshot.KV{Key: []byte("b"), TimestampNano: 20, Flags: 1})
+
+	d2 := snapshot.NewDBI()
+	d2.SetName("empty")
+
+	snap := &snapshot.Snapshot{Databases: []*snapshot.DBI{d1, d2}}
+
+	var buf bytes.Buffer
+	err := NewWriter(&buf).WriteSnapshot(snap, "")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`{"dbi":"foo","key":"YQ==","value":"eAB5","timestamp":10,"dbi_flags":8}`,
+		`{"dbi":"foo","key":"Yg==","timestamp":20,"flags":1,"dbi_flags":8}`,
+		`{"dbi":"empty"}`,
+		``,
+	}, "\n"), buf.String())
+
+	dbis, err := Read(&buf, ReadOptions{DefaultTimestamp: 99})
+	require.NoError(t, err)
+	require.Len(t, dbis, 2)
+	assert.Equal(t, "foo", dbis[0].Name())
+	assert.Equal(t, uint64(8), dbis[0].Flags())
+	kvs, err := dbis[0].AsInefficientKVList()
+	require.NoError(t, err)
+	assert.Equal(t, []snapshot.KV{
+		{Key: []byte("a"), Value: []byte("x\x00y"), TimestampNano: 10},
+		{Key: []byte("b"), TimestampNano: 20, Flags: 1},
+	}, kvs)
+	assert.Equal(t, "empty", dbis[1].Name())
+	kvs, err = dbis[1].AsInefficientKVList()
+	require.NoError(t, err)
+	assert.Empty(t, kvs)
+}
+
+func TestRead_defaultTimestamp(t *testing.T) {
+	in := `{"dbi":"foo","key":"YQ==","value":"YQ=="}` + "\n"
+	dbis, err := Read(strings.NewReader(in), ReadOptions{DefaultTimestamp: 99})
+	require.NoError(t, err)
+	kvs, err := dbis[0].AsInefficientKVList()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(99), kvs[0].TimestampNano)
+}
+
+func TestRead_errors(t *testing.T) {
+	_, err := Read(strings.NewReader(`{"key":"YQ=="}`), ReadOptions{})
+	assert.ErrorIs(t, err, ErrNoDBI)
+
+	in := `{"dbi":"foo","key":"YQ=="}` + "\n" + `{"dbi":"foo","key":"Yg==","dbi_flags":8}`
+	_, err = Read(strings.NewReader(in), ReadOptions{})
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = Read(strings.NewReader(`not json`), ReadOptions{})
+	assert.ErrorContains(t, err, "line 1")
+}
@@ -0,0 +1,184 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// ParallelChunkSize is the amount of uncompressed data compressed by a single
+// worker into a separate gzip member.
+const ParallelChunkSize = 1024 * 1024
+
+// parallelGzipWriter compresses the written data in chunks of ParallelChunkSize
+// using a bounded number of concurrent workers. Every chunk is written as a
+// separate gzip member. Concatenated gzip members form a valid gzip stream
+// that is transparently decompressed by gzip readers (including older
+// Lightning Stream versions), so the output is compatible with a serially
+// compressed snapshot.
+//
+// If dst is set, compressed chunks are written to it in order as soon as they
+// are done, so that no more than about twice the number of workers chunks are
+// held in memory.
+type parallelGzipWriter struct {
+	level     int
+	chunkSize int
+	workers   int
+	dst       io.Writer
+	buf       []byte
+	sem       chan struct{}
+	wg        sync.WaitGroup
+	results   []gzipChunk
+	flushed   bool      // at least one chunk was flushed
+	writers   sync.Pool // *gzip.Writer, reused across chunks
+
+	mu  sync.Mutex
+	err error
+}
+
+// gzipChunk is the compressed output of a single chunk
+type gzipChunk struct {
+	out  *bytes.Buffer
+	done chan struct{} // closed when out is complete
+}
+
+func newParallelGzipWriter(level, workers, chunkSize int) *parallelGzipWriter {
+	return newParallelGzipWriterTo(nil, level, workers, chunkSize)
+}
+
+func newParallelGzipWriterTo(dst io.Writer, level, workers, chunkSize int) *parallelGzipWriter {
+	return &parallelGzipWriter{
+		level:     level,
+		chunkSize: chunkSize,
+		workers:   workers,
+		dst:       dst,
+		buf:       make([]byte, 0, chunkSize),
+		sem:       make(chan struct{}, workers),
+	}
+}
+
+// Write copies p into the current chunk and hands off full chunks to a worker.
+// It blocks when all workers are busy.
+func (w *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := w.getErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		free := w.chunkSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+		if len(w.buf) == w.chunkSize {
+			w.flush()
+			if err := w.writeDone(w.workers); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// writeDone writes compressed chunks to dst in order until no more than keep
+// chunks are left. It waits for the oldest chunk if needed.
+func (w *parallelGzipWriter) writeDone(keep int) error {
+	if w.dst == nil {
+		return nil
+	}
+	for len(w.results) > keep {
+		r := w.results[0]
+		<-r.done
+		if err := w.getErr(); err != nil {
+			return err
+		}
+		if _, err := w.dst.Write(r.out.Bytes()); err != nil {
+			w.setErr(err)
+			return err
+		}
+		w.results[0] = gzipChunk{}
+		w.results = w.results[1:]
+	}
+	return nil
+}
+
+func (w *parallelGzipWriter) flush() {
+	chunk := w.buf
+	w.flushed = true
+	w.buf = make([]byte, 0, w.chunkSize)
+	out := bytes.NewBuffer(make([]byte, 0, len(chunk)/2))
+	done := make(chan struct{})
+	w.results = append(w.results, gzipChunk{out: out, done: done})
+
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			close(done)
+			<-w.sem
+			w.wg.Done()
+		}()
+		gw, ok := w.writers.Get().(*gzip.Writer)
+		if ok {
+			gw.Reset(out)
+		} else {
+			var err error
+			gw, err = gzip.NewWriterLevel(out, w.level)
+			if err != nil {
+				w.setErr(err)
+				return
+			}
+		}
+		defer w.writers.Put(gw)
+		if _, err := gw.Write(chunk); err != nil {
+			w.setErr(err)
+			return
+		}
+		if err := gw.Close(); err != nil {
+			w.setErr(err)
+		}
+	}()
+}
+
+// Close compresses the remaining data, waits for all workers and returns the
+// concatenated compressed data. If dst is set, the remaining data is written
+// to dst instead and the returned data is nil.
+func (w *parallelGzipWriter) Close() ([]byte, error) {
+	if len(w.buf) > 0 || !w.flushed {
+		w.flush() // an empty input still needs a valid gzip member
+	}
+	w.wg.Wait()
+	if err := w.getErr(); err != nil {
+		return nil, err
+	}
+	if w.dst != nil {
+		return nil, w.writeDone(0)
+	}
+	size := 0
+	for _, r := range w.results {
+		size += r.out.Len()
+	}
+	data := make([]byte, 0, size)
+	for _, r := range w.results {
+		data = append(data, r.out.Bytes()...)
+	}
+	w.results = nil
+	return data, nil
+}
+
+func (w *parallelGzipWriter) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *parallelGzipWriter) getErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
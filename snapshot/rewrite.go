@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// RewriteFunc rewrites a single snapshot entry when a snapshot written with
+// a different application schema version is loaded.
+// It returns the new entry, or keep=false to drop the entry.
+// The timestamp and flags of the entry should normally be retained.
+type RewriteFunc func(kv KV) (result KV, keep bool, err error)
+
+var (
+	registeredRewritesMu sync.Mutex
+	registeredRewrites   = map[string]RewriteFunc{}
+)
+
+// RegisterRewrite registers a named RewriteFunc that can be referenced from
+// the rewrite rules in the LMDB configuration.
+// This must be called before the syncers are started, typically from an init
+// function. Registering the same name twice panics.
+func RegisterRewrite(name string, f RewriteFunc) {
+	registeredRewritesMu.Lock()
+	defer registeredRewritesMu.Unlock()
+	if _, exists := registeredRewrites[name]; exists {
+		panic(fmt.Sprintf("rewrite %q already registered", name))
+	}
+	registeredRewrites[name] = f
+}
+
+// GetRewrite returns the RewriteFunc registered under the given name
+func GetRewrite(name string) (f RewriteFunc, ok bool) {
+	registeredRewritesMu.Lock()
+	defer registeredRewritesMu.Unlock()
+	f, ok = registeredRewrites[name]
+	return f, ok
+}
+
+// RegisteredRewrites returns the sorted names of all registered rewrites
+func RegisteredRewrites() []string {
+	registeredRewritesMu.Lock()
+	defer registeredRewritesMu.Unlock()
+	var names []string
+	for name := range registeredRewrites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rewrite creates a new DBI with the given name and the entries rewritten
+// by f. The flags and transform are copied.
+// If f is nil, the entries are copied unmodified.
+func (d *DBI) Rewrite(name string, f RewriteFunc) (*DBI, error) {
+	newDBI := NewDBISize(len(d.data))
+	newDBI.SetName(name)
+	newDBI.SetFlags(d.flags)
+	newDBI.SetTransform(d.transform)
+	d.ResetCursor()
+	for {
+		kv, err := d.Next()
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		if f != nil {
+			var keep bool
+			kv, keep, err = f(kv)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				continue
+			}
+		}
+		newDBI.Append(kv)
+	}
+	return newDBI, nil
+}
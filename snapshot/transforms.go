@@ -10,6 +10,9 @@ const (
 	// TransformDupSortHackV1 is the 'transform' field for the current
 	// dupsort_hack key-value transformation.
 	TransformDupSortHackV1 = "dupsort_hack_v1"
+	// TransformDupSortSetV1 is the 'transform' field for DupSort DBIs stored
+	// as one entry per key, with all values encoded as a set with EncodeDupSet.
+	TransformDupSortSetV1 = "dupsort_set_v1"
 	// TransformNone indicates no transformation
 	TransformNone = ""
 )
@@ -21,6 +24,8 @@ func TransformSupported(transform string) bool {
 		return true
 	case TransformDupSortHackV1:
 		return true
+	case TransformDupSortSetV1:
+		return true
 	default:
 		return false
 	}
@@ -36,18 +41,19 @@ func (d *DBI) ValidateTransform(formatVersion uint32, nativeSchema bool) error {
 		return fmt.Errorf("snapshot dbi %q: transform %q not supported",
 			dbiName, transform)
 	}
-	if nativeSchema && transform != TransformNone {
-		return fmt.Errorf("snapshot dbi %q: no transforms supported "+
-			"for native schema, got %q", dbiName, transform)
+	if nativeSchema && transform != TransformNone && transform != TransformDupSortSetV1 {
+		return fmt.Errorf("snapshot dbi %q: transform %q not supported "+
+			"for native schema", dbiName, transform)
 	}
 	// First formatVersion that has the transform field
 	if formatVersion >= 3 {
 		flagsDupSort := flags&lmdb.DupSort > 0
-		transformDupSort := transform == TransformDupSortHackV1
+		transformDupSort := transform == TransformDupSortHackV1 ||
+			transform == TransformDupSortSetV1
 		if flagsDupSort && !transformDupSort {
 			return fmt.Errorf("snapshot dbi %q: dupsort DBI flag without "+
-				"expected transform (got %q, expected %q)",
-				dbiName, transform, TransformDupSortHackV1)
+				"expected transform (got %q, expected %q or %q)",
+				dbiName, transform, TransformDupSortHackV1, TransformDupSortSetV1)
 		}
 		if !flagsDupSort && transformDupSort {
 			return fmt.Errorf("snapshot dbi %q: non-dupsort DBI flags with "+
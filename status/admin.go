@@ -0,0 +1,244 @@
+package status
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// adminTimeout limits how long an admin request waits for an operation
+const adminTimeout = 10 * time.Minute
+
+// Controller allows the admin API to control a syncer at runtime.
+// The syncer registers it with AddController.
+type Controller interface {
+	// SetPaused pauses or resumes loading remote snapshots and sending local
+	// snapshots.
+	SetPaused(load, send bool)
+	// Paused returns the current pause state
+	Paused() (load, send bool)
+	// ForceSnapshot stores a snapshot of the LMDB right away and returns
+	// its name.
+	ForceSnapshot(ctx context.Context) (name string, err error)
+	// RunCleaner runs the snapshot cleaner once
+	RunCleaner(ctx context.Context) error
+	// RunSweep runs the sweeper of stale deleted entries once
+	RunSweep(ctx context.Context) error
+	// IgnoreSnapshot makes the syncer ignore a remote snapshot
+	IgnoreSnapshot(name string) error
+	// Compact writes a compacted copy of the LMDB, and if swap is set,
+	// replaces the data file with it. Swapping requires a paused syncer.
+	Compact(ctx context.Context, swap bool) (CompactResult, error)
+}
+
+// ErrNotFound is returned by a Controller for unknown names
+var ErrNotFound = errors.New("not found")
+
+// AddController registers a Controller for the admin API
+func AddController(name string, c Controller) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	if gi.controllers == nil {
+		gi.controllers = make(map[string]Controller)
+	}
+	gi.controllers[name] = c
+}
+
+func RemoveController(name string) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	delete(gi.controllers, name)
+}
+
+func (i *info) controller(name string) Controller {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.controllers[name]
+}
+
+// Admin serves the admin API. All requests must be authenticated with the
+// token, a verified client certificate, or both if both are configured.
+type Admin struct {
+	token          string
+	clientCert     bool
+	clientSubjects []string // allowed client cert subjects, empty for any
+}
+
+// PauseState is returned by the pause and resume endpoints
+type PauseState struct {
+	Load bool `json:"load"`
+	Send bool `json:"send"`
+}
+
+// CompactResult is returned by the compact endpoint
+type CompactResult struct {
+	Path    string `json:"path"` // of the compacted copy, unless swapped
+	Size    int64  `json:"size"` // of the compacted copy
+	Swapped bool   `json:"swapped"`
+	// Backup is the path of the old data file after a swap
+	Backup string `json:"backup,omitempty"`
+}
+
+// adminPrefix is the path prefix of all admin API endpoints
+const adminPrefix = apiPrefix + "admin/"
+
+func (a *Admin) register(mux *http.ServeMux) {
+	const p = adminPrefix + "lmdbs/{name}/"
+	mux.HandleFunc("POST "+p+"pause", a.handle(a.pause))
+	mux.HandleFunc("POST "+p+"resume", a.handle(a.resume))
+	mux.HandleFunc("POST "+p+"snapshot", a.handle(a.snapshot))
+	mux.HandleFunc("POST "+p+"cleanup", a.handle(a.cleanup))
+	mux.HandleFunc("POST "+p+"sweep", a.handle(a.sweep))
+	mux.HandleFunc("POST "+p+"ignore", a.handle(a.ignore))
+	mux.HandleFunc("POST "+p+"compact", a.handle(a.compact))
+}
+
+// handle wraps an admin function with authentication and controller lookup
+func (a *Admin) handle(fn func(r *http.Request, c Controller) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			if a.token != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="lightningstream"`)
+			}
+			writeJSON(w, http.StatusUnauthorized, apiError{"unauthorized"})
+			return
+		}
+		name := r.PathValue("name")
+		c := gi.controller(name)
+		if c == nil {
+			writeJSON(w, http.StatusNotFound, apiError{fmt.Sprintf("no syncer for lmdb %q", name)})
+			return
+		}
+		l := logrus.WithFields(logrus.Fields{
+			"db":     name,
+			"path":   r.URL.Path,
+			"remote": r.RemoteAddr,
+		})
+		l.Info("Admin request")
+
+		ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+		defer cancel()
+		v, err := fn(r.WithContext(ctx), c)
+		if err != nil {
+			l.WithError(err).Warn("Admin request failed")
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			writeJSON(w, code, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// authorized checks every configured authentication method
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.token == "" && !a.clientCert {
+		return false // never happens, the admin API is not registered
+	}
+	if a.token != "" && !a.tokenOK(r) {
+		return false
+	}
+	if a.clientCert && !a.clientCertOK(r) {
+		return false
+	}
+	return true
+}
+
+func (a *Admin) tokenOK(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// clientCertOK checks if the request has a client certificate that was
+// verified against the CA, with an allowed subject.
+func (a *Admin) clientCertOK(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	if len(a.clientSubjects) == 0 {
+		return true
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	for _, allowed := range a.clientSubjects {
+		if allowed == subject.CommonName || allowed == subject.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// directions returns which directions a pause or resume applies to, based
+// on the 'load' and 'send' query parameters. Without any, both apply.
+func directions(r *http.Request) (load, send bool) {
+	q := r.URL.Query()
+	load = q.Has("load")
+	send = q.Has("send")
+	if !load && !send {
+		return true, true
+	}
+	return load, send
+}
+
+func (a *Admin) pause(r *http.Request, c Controller) (any, error) {
+	load, send := directions(r)
+	curLoad, curSend := c.Paused()
+	c.SetPaused(curLoad || load, curSend || send)
+	load, send = c.Paused()
+	return PauseState{Load: load, Send: send}, nil
+}
+
+func (a *Admin) resume(r *http.Request, c Controller) (any, error) {
+	load, send := directions(r)
+	curLoad, curSend := c.Paused()
+	c.SetPaused(curLoad && !load, curSend && !send)
+	load, send = c.Paused()
+	return PauseState{Load: load, Send: send}, nil
+}
+
+func (a *Admin) snapshot(r *http.Request, c Controller) (any, error) {
+	name, err := c.ForceSnapshot(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"name": name}, nil
+}
+
+func (a *Admin) cleanup(r *http.Request, c Controller) (any, error) {
+	if err := c.RunCleaner(r.Context()); err != nil {
+		return nil, err
+	}
+	return map[string]string{"result": "ok"}, nil
+}
+
+func (a *Admin) sweep(r *http.Request, c Controller) (any, error) {
+	if err := c.RunSweep(r.Context()); err != nil {
+		return nil, err
+	}
+	return map[string]string{"result": "ok"}, nil
+}
+
+func (a *Admin) ignore(r *http.Request, c Controller) (any, error) {
+	name := r.URL.Query().Get("snapshot")
+	if name == "" {
+		return nil, errors.New("snapshot parameter required")
+	}
+	if err := c.IgnoreSnapshot(name); err != nil {
+		return nil, err
+	}
+	return map[string]string{"ignored": name}, nil
+}
+
+func (a *Admin) compact(r *http.Request, c Controller) (any, error) {
+	return c.Compact(r.Context(), r.URL.Query().Has("swap"))
+}
@@ -0,0 +1,260 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/status/healthtracker"
+)
+
+// The JSON API is versioned by its path. Fields may be added within a
+// version, but existing fields must not be removed or change meaning.
+const apiPrefix = "/api/v1/"
+
+// SyncerState is the state of a syncer as reported by the JSON API.
+// The syncer registers a function that returns it with AddSyncer.
+type SyncerState struct {
+	Instance   string          `json:"instance"` // Own instance ID
+	Paused     PauseState      `json:"paused"`
+	Instances  []InstanceState `json:"instances"`
+	LastStored *SnapshotRef    `json:"last_stored,omitempty"`
+	Receiver   ReceiverState   `json:"receiver"`
+	Cleaner    CleanerState    `json:"cleaner"`
+	Sweeper    *SweeperState   `json:"sweeper,omitempty"` // nil if disabled
+}
+
+// InstanceState describes the snapshots of a single instance
+type InstanceState struct {
+	Instance      string       `json:"instance"`
+	LastSeen      *SnapshotRef `json:"last_seen,omitempty"`
+	LastSeenSize  int64        `json:"last_seen_size,omitempty"`
+	Download      string       `json:"download,omitempty"` // idle, downloading, failed or ready
+	DownloadError string       `json:"download_error,omitempty"`
+	LastLoaded    *SnapshotRef `json:"last_loaded,omitempty"`
+	LoadedAt      time.Time    `json:"loaded_at,omitzero"`
+	LoadedSize    int64        `json:"loaded_size,omitempty"`
+	Hostname      string       `json:"hostname,omitempty"` // From the last loaded snapshot
+	// Waiting is true while we wait for the first snapshot of the instance
+	// to be loaded after startup.
+	Waiting bool `json:"waiting"`
+	// Committed is the time of the last snapshot of the instance that was
+	// loaded and included in one of our own snapshots.
+	Committed time.Time `json:"committed,omitzero"`
+}
+
+// SnapshotRef refers to a snapshot in storage
+type SnapshotRef struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReceiverState describes the snapshot receiver
+type ReceiverState struct {
+	HasSnapshots     bool              `json:"has_snapshots"`
+	ReadyUpdates     int               `json:"ready_updates"`
+	Downloaders      int               `json:"downloaders"`
+	CorruptSnapshots map[string]string `json:"corrupt_snapshots,omitempty"`
+}
+
+// CleanerState describes the snapshot cleaner
+type CleanerState struct {
+	Enabled      bool      `json:"enabled"`
+	LastRun      time.Time `json:"last_run,omitzero"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastTotal    int       `json:"last_total"`
+	LastCleaned  int       `json:"last_cleaned"`
+	LastFailures int       `json:"last_failures"`
+}
+
+// SweeperState describes the sweeper of stale deleted entries
+type SweeperState struct {
+	LastRun         time.Time `json:"last_run,omitzero"`
+	LastError       string    `json:"last_error,omitempty"`
+	TotalEntries    int       `json:"total_entries"`
+	DeletedEntries  int       `json:"deleted_entries"`
+	CleanedEntries  int       `json:"cleaned_entries"`
+	DeletedFraction float64   `json:"deleted_fraction"`
+	Seconds         float64   `json:"seconds"`
+}
+
+// LMDBStatus is the JSON representation of DBInfo
+type LMDBStatus struct {
+	Name       string         `json:"name"`
+	MapSize    int64          `json:"map_size"`
+	Used       uint64         `json:"used"`
+	LastTxnID  int64          `json:"last_txn_id"`
+	LastPNO    int64          `json:"last_pno"`
+	NumReaders uint           `json:"num_readers"`
+	MaxReaders uint           `json:"max_readers"`
+	Freelist   FreelistStatus `json:"freelist"`
+	DBIs       []DBIStatus    `json:"dbis"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// FreelistStatus is the JSON representation of stats.Freelist
+type FreelistStatus struct {
+	Entries       uint64  `json:"entries"`
+	FreePages     uint64  `json:"free_pages"`
+	FreeBytes     uint64  `json:"free_bytes"`
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// DBIStatus is the JSON representation of DBIStat
+type DBIStatus struct {
+	Name          string `json:"name"`
+	Used          uint64 `json:"used"`
+	Entries       uint64 `json:"entries"`
+	Depth         uint   `json:"depth"`
+	BranchPages   uint64 `json:"branch_pages"`
+	LeafPages     uint64 `json:"leaf_pages"`
+	OverflowPages uint64 `json:"overflow_pages"`
+	Flags         uint   `json:"flags"`
+	FlagsDisplay  string `json:"flags_display"`
+}
+
+// BlobStatus is a file in storage
+type BlobStatus struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Status is the full status returned by /api/v1/status
+type Status struct {
+	Version  string                 `json:"version"`
+	Instance string                 `json:"instance"`
+	Time     time.Time              `json:"time"`
+	LMDBs    []LMDBStatus           `json:"lmdbs"`
+	Syncers  map[string]SyncerState `json:"syncers"`
+	Health   []healthtracker.Status `json:"health"`
+}
+
+// LMDBStatus converts the DBInfo to its JSON representation
+func (d DBInfo) LMDBStatus() LMDBStatus {
+	ls := LMDBStatus{
+		Name: d.Name,
+		Used: uint64(d.Used),
+		Freelist: FreelistStatus{
+			Entries:       d.Freelist.Entries,
+			FreePages:     d.Freelist.FreePages,
+			FreeBytes:     d.Freelist.FreeBytes(),
+			Fragmentation: d.Freelist.Fragmentation(),
+		},
+		DBIs: []DBIStatus{},
+	}
+	if d.Info != nil {
+		ls.MapSize = d.Info.MapSize
+		ls.LastTxnID = d.Info.LastTxnID
+		ls.LastPNO = d.Info.LastPNO
+		ls.NumReaders = d.Info.NumReaders
+		ls.MaxReaders = d.Info.MaxReaders
+	}
+	if d.Err != nil {
+		ls.Error = d.Err.Error()
+	}
+	for _, ds := range d.DBIStats {
+		ls.DBIs = append(ls.DBIs, DBIStatus{
+			Name:          ds.Name,
+			Used:          uint64(ds.Used),
+			Entries:       ds.Stat.Entries,
+			Depth:         ds.Stat.Depth,
+			BranchPages:   ds.Stat.BranchPages,
+			LeafPages:     ds.Stat.LeafPages,
+			OverflowPages: ds.Stat.OverflowPages,
+			Flags:         ds.Flags,
+			FlagsDisplay:  ds.FlagsDisplay,
+		})
+	}
+	return ls
+}
+
+// API serves the JSON status API
+type API struct {
+	c config.Config
+}
+
+func (a *API) register(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"status", a.handle(a.status))
+	mux.HandleFunc(apiPrefix+"lmdbs", a.handle(a.lmdbs))
+	mux.HandleFunc(apiPrefix+"instances", a.handle(a.instances))
+	mux.HandleFunc(apiPrefix+"storage", a.handle(a.storage))
+}
+
+// handle wraps an API function that returns the value to encode as JSON
+func (a *API) handle(fn func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+			return
+		}
+		v, err := fn(r)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func (a *API) status(r *http.Request) (any, error) {
+	return Status{
+		Version:  a.c.Version,
+		Instance: a.c.Instance,
+		Time:     time.Now().UTC(),
+		LMDBs:    lmdbStatus(),
+		Syncers:  gi.SyncerStates(),
+		Health:   healthtracker.AllStatus(),
+	}, nil
+}
+
+func (a *API) lmdbs(r *http.Request) (any, error) {
+	return lmdbStatus(), nil
+}
+
+func (a *API) instances(r *http.Request) (any, error) {
+	res := make(map[string][]InstanceState)
+	for name, st := range gi.SyncerStates() {
+		res[name] = st.Instances
+	}
+	return res, nil
+}
+
+func (a *API) storage(r *http.Request) (any, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	list, err := gi.ListBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BlobStatus, 0, len(list))
+	for _, b := range list {
+		res = append(res, BlobStatus{Name: b.Name, Size: b.Size})
+	}
+	return res, nil
+}
+
+func lmdbStatus() []LMDBStatus {
+	res := []LMDBStatus{}
+	for _, d := range gi.DBInfo() {
+		res = append(res, d.LMDBStatus())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
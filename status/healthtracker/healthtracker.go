@@ -2,6 +2,8 @@ package healthtracker
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,9 +34,64 @@ func New(hc HealthConfig, prefix string, activity string) *HealthTracker {
 	// Register duration tracker to healthz
 	ht.RegisterDuration()
 
+	registryMu.Lock()
+	registry[prefix] = ht
+	registryMu.Unlock()
+
 	return ht
 }
 
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*HealthTracker) // by prefix
+)
+
+// Status is the current state of a HealthTracker
+type Status struct {
+	Name                string    `json:"name"`
+	Activity            string    `json:"activity"`
+	State               string    `json:"state"` // "ok", "warning" or "error"
+	ConsecutiveFailures uint32    `json:"consecutive_failures"`
+	FailingSince        time.Time `json:"failing_since,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// Status returns the current state of the tracker, evaluated against the
+// configured warning and error durations.
+func (ht *HealthTracker) Status() Status {
+	st := Status{
+		Name:                ht.prefix,
+		Activity:            ht.activity,
+		State:               "ok",
+		ConsecutiveFailures: ht.sequence.Load(),
+		LastError:           ht.lastErr.Load(),
+	}
+	if st.ConsecutiveFailures > 0 {
+		st.FailingSince = ht.since.Load()
+		failingFor := time.Since(st.FailingSince)
+		if failingFor >= ht.Config.ErrorDuration {
+			st.State = "error"
+		} else if failingFor >= ht.Config.WarnDuration {
+			st.State = "warning"
+		}
+	}
+	return st
+}
+
+// AllStatus returns the status of all health trackers, sorted by name
+func AllStatus() []Status {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	res := make([]Status, 0, len(registry))
+	for _, ht := range registry {
+		res = append(res, ht.Status())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
 func (ht *HealthTracker) RegisterDuration() {
 	// Register healthz
 	healthz.Register(fmt.Sprintf("%s_failed_duration", ht.prefix), ht.Config.EvaluationInterval, func() error {
@@ -2,13 +2,17 @@ package status
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	htmltemplate "html/template"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/PowerDNS/go-tlsconfig"
 	"github.com/c2h5oh/datasize"
+	"github.com/go-logr/logr/funcr"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/wojas/go-healthz"
@@ -16,23 +20,112 @@ import (
 	"github.com/PowerDNS/lightningstream/config"
 )
 
-func StartHTTPServer(c config.Config) {
+// StartHTTPServer starts the HTTP server in the background, if enabled.
+// The context only controls the reloading of TLS certificates.
+func StartHTTPServer(ctx context.Context, c config.Config) error {
 	if c.HTTP.Address == "" {
 		logrus.Info("HTTP stats server disabled")
-		return
+		return nil
 	}
 	page := &Page{
 		c: c,
 	}
-	logrus.WithField("address", c.HTTP.Address).Info("HTTP stats server enabled")
 	http.Handle("/metrics", promhttp.Handler())
 	http.Handle("/healthz", healthz.Handler())
 	http.HandleFunc("/storage", page.BlobListPage)
+	api := &API{
+		c: c,
+	}
+	api.register(http.DefaultServeMux)
+	useTLS := c.HTTP.TLS.HasCertWithKey()
+	if c.HTTP.AdminEnabled() {
+		admin := &Admin{
+			token:          c.HTTP.AdminToken,
+			clientCert:     c.HTTP.AdminClientCert,
+			clientSubjects: c.HTTP.AdminClientSubjects,
+		}
+		admin.register(http.DefaultServeMux)
+		logrus.WithFields(logrus.Fields{
+			"token":       c.HTTP.AdminToken != "",
+			"client_cert": c.HTTP.AdminClientCert,
+		}).Info("HTTP admin API enabled")
+		if c.HTTP.AdminToken != "" && !useTLS {
+			logrus.Warn("HTTP admin API token is sent in plain text without TLS, " +
+				"consider enabling http.tls")
+		}
+	}
 	http.Handle("/", page)
+
+	var handler http.Handler = http.DefaultServeMux
+	if c.HTTP.BasicAuth.Enabled() {
+		handler = &basicAuthHandler{
+			next: handler,
+			auth: c.HTTP.BasicAuth,
+		}
+		logrus.Info("HTTP basic authentication enabled")
+	}
+	server := &http.Server{
+		Addr:              c.HTTP.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if useTLS {
+		tlsManager, err := tlsconfig.NewManager(ctx, c.HTTP.TLS, tlsconfig.Options{
+			IsServer: true,
+			Logr: funcr.New(func(prefix, args string) {
+				logrus.WithField("component", "http-tls").Info(args)
+			}, funcr.Options{}),
+		})
+		if err != nil {
+			return fmt.Errorf("http tls: %w", err)
+		}
+		server.TLSConfig, err = tlsManager.TLSConfig()
+		if err != nil {
+			return fmt.Errorf("http tls: %w", err)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"address":             c.HTTP.Address,
+		"tls":                 useTLS,
+		"require_client_cert": useTLS && c.HTTP.TLS.RequireClientCert,
+	}).Info("HTTP stats server enabled")
 	go func() {
-		err := http.ListenAndServe(c.HTTP.Address, nil)
+		var err error
+		if useTLS {
+			// The certificate is provided by the TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		logrus.Fatalf("HTTP server error: %v", err)
 	}()
+	return nil
+}
+
+// basicAuthHandler requires basic authentication for all requests, except
+// for the admin API, which performs its own authentication.
+type basicAuthHandler struct {
+	next http.Handler
+	auth config.BasicAuth
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, adminPrefix) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	user, pass, ok := r.BasicAuth()
+	// Always compare both to not leak which one was wrong through timing
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(h.auth.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(h.auth.Password)) == 1
+	if !ok || !userOK || !passOK {
+		w.Header().Set("WWW-Authenticate", `Basic realm="lightningstream", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.next.ServeHTTP(w, r)
 }
 
 type Page struct {
@@ -62,6 +155,8 @@ const statusTemplateString = `<!DOCTYPE html>
 		<a href="/metrics">Prometheus metrics</a>
 		|
 		<a href="/healthz">healthz</a>
+		|
+		<a href="/api/v1/status">JSON status</a>
 	</p>
 
 	<h2>LMDBs</h2>
@@ -72,6 +167,8 @@ const statusTemplateString = `<!DOCTYPE html>
 			<th>DB Name</th>
 			<th>MapSize</th>
 			<th>Used</th>
+			<th>Free pages</th>
+			<th>Fragmentation</th>
 			<th>LastTxnID</th>
 			<th>Readers</th>
 			<th>Error</th>
@@ -83,6 +180,8 @@ const statusTemplateString = `<!DOCTYPE html>
 			<td>{{.Name}}</td>
 			<td class="size">{{byteSize .Info.MapSize}}</td>
 			<td class="size">{{.Used.HumanReadable}}</td>
+			<td class="size">{{.Freelist.FreePages}} ({{byteSize .Freelist.FreeBytes}})</td>
+			<td class="size">{{percent .Freelist.Fragmentation}}</td>
 			<td class="size">{{.Info.LastTxnID}}</td>
 			<td>{{.Info.NumReaders}} / {{.Info.MaxReaders}}</td>
 			<td>{{.Err}}</td>
@@ -123,6 +222,46 @@ const statusTemplateString = `<!DOCTYPE html>
 		</table>
 	{{end}}
 
+	<h2>Instances</h2>
+	<p>
+		Age is the time since the snapshot was created. The age of the loaded
+		snapshot is the replication lag for that instance.
+	</p>
+
+	{{range $name, $st := .Syncers}}
+		<h3>Instances for {{$name}}</h3>
+		<table>
+		<thead>
+			<tr>
+				<th>Instance</th>
+				<th>Hostname</th>
+				<th>Latest snapshot</th>
+				<th>Age</th>
+				<th>Size</th>
+				<th>Download</th>
+				<th>Loaded</th>
+				<th>Loaded snapshot age</th>
+				<th>Startup</th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Instances}}
+			<tr>
+				<td>{{.Instance}}{{if eq .Instance $st.Instance}} (this instance){{end}}</td>
+				<td>{{.Hostname}}</td>
+				<td>{{with .LastSeen}}{{.Name}}{{end}}</td>
+				<td class="last">{{with .LastSeen}}{{age .Timestamp}}{{end}}</td>
+				<td class="size">{{if .LastSeenSize}}{{byteSize .LastSeenSize}}{{end}}</td>
+				<td class="{{if eq .Download "failed"}}error{{end}}" title="{{.DownloadError}}">{{.Download}}</td>
+				<td class="last">{{if not .LoadedAt.IsZero}}{{age .LoadedAt}} ago{{end}}</td>
+				<td class="last">{{with .LastLoaded}}{{age .Timestamp}}{{end}}</td>
+				<td>{{if .Waiting}}waiting{{end}}</td>
+			</tr>
+		{{end}}
+		</tbody>
+		</table>
+	{{end}}
+
 	<h2>Storage</h2>
 	<p><a href="storage">Storage snapshot listing (text)</a></p>
 
@@ -134,8 +273,20 @@ var statusTemplate *htmltemplate.Template
 func init() {
 	var err error
 	statusTemplate, err = htmltemplate.New("status").Funcs(htmltemplate.FuncMap{
-		"byteSize": func(size int64) string {
-			return datasize.ByteSize(size).HumanReadable()
+		"byteSize": func(size any) string {
+			switch v := size.(type) {
+			case int64:
+				return datasize.ByteSize(v).HumanReadable()
+			case uint64:
+				return datasize.ByteSize(v).HumanReadable()
+			}
+			return fmt.Sprint(size)
+		},
+		"percent": func(fraction float64) string {
+			return fmt.Sprintf("%.1f %%", 100*fraction)
+		},
+		"age": func(t time.Time) string {
+			return time.Since(t).Round(time.Second).String()
 		},
 	}).Parse(statusTemplateString)
 	if err != nil {
@@ -167,11 +318,13 @@ func (p *Page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		Config config.Config
-		DBInfo []DBInfo
+		Config  config.Config
+		DBInfo  []DBInfo
+		Syncers map[string]SyncerState
 	}{
-		Config: p.c,
-		DBInfo: gi.DBInfo(),
+		Config:  p.c,
+		DBInfo:  gi.DBInfo(),
+		Syncers: gi.SyncerStates(),
 	}
 
 	err := statusTemplate.Execute(w, data)
@@ -3,6 +3,7 @@ package status
 import (
 	"context"
 	"errors"
+	"maps"
 	"sync"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv"
@@ -14,9 +15,11 @@ import (
 )
 
 type info struct {
-	mu  sync.Mutex
-	dbs []dbs
-	st  simpleblob.Interface
+	mu          sync.Mutex
+	dbs         []dbs
+	st          simpleblob.Interface
+	syncers     map[string]func() SyncerState
+	controllers map[string]Controller
 }
 
 type dbs struct {
@@ -29,6 +32,7 @@ type DBInfo struct {
 	Info     *lmdb.EnvInfo
 	DBIStats []DBIStat
 	Used     datasize.ByteSize
+	Freelist stats.Freelist
 	Err      error
 }
 
@@ -60,12 +64,12 @@ func (i *info) DBInfo() (res []DBInfo) {
 		var info DBInfo
 		info.Name = db.name
 		var err error
-		info.Info, err = db.env.Info()
+		info.Info, err = lmdbenv.Info(db.env)
 		if err != nil {
 			info.Err = err
 			continue
 		}
-		info.Err = db.env.View(func(txn *lmdb.Txn) error {
+		info.Err = lmdbenv.View(db.env, func(txn *lmdb.Txn) error {
 			dbiNames, err := lmdbenv.ReadDBINames(txn)
 			if err != nil {
 				return err
@@ -93,7 +97,8 @@ func (i *info) DBInfo() (res []DBInfo) {
 				info.DBIStats = append(info.DBIStats, ds)
 				info.Used += ds.Used
 			}
-			return nil
+			info.Freelist, err = stats.ReadFreelist(db.env, txn)
+			return err
 		})
 		res = append(res, info)
 	}
@@ -123,6 +128,35 @@ func RemoveLMDBEnv(name string) {
 	gi.dbs = dbs
 }
 
+// AddSyncer registers a function that returns the syncer state for the
+// JSON API. It is called for every API request.
+func AddSyncer(name string, state func() SyncerState) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	if gi.syncers == nil {
+		gi.syncers = make(map[string]func() SyncerState)
+	}
+	gi.syncers[name] = state
+}
+
+func RemoveSyncer(name string) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	delete(gi.syncers, name)
+}
+
+// SyncerStates returns the current state of all registered syncers
+func (i *info) SyncerStates() map[string]SyncerState {
+	i.mu.Lock()
+	funcs := maps.Clone(i.syncers)
+	i.mu.Unlock()
+	res := make(map[string]SyncerState, len(funcs))
+	for name, fn := range funcs {
+		res[name] = fn()
+	}
+	return res
+}
+
 func SetStorage(st simpleblob.Interface) {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
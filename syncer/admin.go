@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/status"
+	"github.com/PowerDNS/lightningstream/syncer/receiver"
+	"github.com/sirupsen/logrus"
+)
+
+// snapshotRequest is a forced snapshot requested by the admin API
+type snapshotRequest struct {
+	result chan snapshotResult // buffered, receives exactly one result
+}
+
+type snapshotResult struct {
+	name string
+	err  error
+}
+
+// adminController implements status.Controller for a running syncer
+type adminController struct {
+	s *Syncer
+	r *receiver.Receiver
+}
+
+var _ status.Controller = (*adminController)(nil)
+
+func (a *adminController) SetPaused(load, send bool) {
+	s := a.s
+	s.pauseLoad.Store(load)
+	s.pauseSend.Store(send)
+	metricPaused.WithLabelValues(s.name, "load").Set(boolToFloat(load))
+	metricPaused.WithLabelValues(s.name, "send").Set(boolToFloat(send))
+	s.l.WithFields(logrus.Fields{
+		"pause_load": load,
+		"pause_send": send,
+	}).Warn("Sync pause state changed by admin request")
+}
+
+func (a *adminController) Paused() (load, send bool) {
+	return a.s.pauseLoad.Load(), a.s.pauseSend.Load()
+}
+
+// ForceSnapshot asks the sync loop to store a snapshot and waits for it.
+// This works even if sending is paused.
+func (a *adminController) ForceSnapshot(ctx context.Context) (string, error) {
+	if a.s.opt.ReceiveOnly {
+		return "", errors.New("cannot store snapshots in receive-only mode")
+	}
+	req := snapshotRequest{result: make(chan snapshotResult, 1)}
+	select {
+	case a.s.snapshotRequests <- req:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	select {
+	case res := <-req.result:
+		return res.name, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (a *adminController) RunCleaner(ctx context.Context) error {
+	if !a.s.cleaner.Enabled() {
+		return errors.New("cleanup is disabled in the storage config")
+	}
+	return a.s.cleaner.RunOnce(ctx, time.Now())
+}
+
+func (a *adminController) RunSweep(ctx context.Context) error {
+	a.s.statusMu.Lock()
+	sw := a.s.sweeper
+	a.s.statusMu.Unlock()
+	if sw == nil {
+		return errors.New("the sweeper is disabled")
+	}
+	return sw.RunOnce(ctx)
+}
+
+func (a *adminController) IgnoreSnapshot(name string) error {
+	ni, err := snapshot.ParseName(name)
+	if err != nil {
+		return err
+	}
+	if ni.SyncerName != a.s.name || ni.Kind != snapshot.KindSnapshot {
+		return fmt.Errorf("%w: not a snapshot of this lmdb: %s", status.ErrNotFound, name)
+	}
+	a.r.Ignore(name)
+	return nil
+}
+
+// Compact writes a compacted copy of the LMDB next to its data file. With
+// swap, the sync loop replaces the data file with the copy, which requires
+// that loading and sending are paused and that no other process has the
+// LMDB open.
+func (a *adminController) Compact(ctx context.Context, swap bool) (status.CompactResult, error) {
+	if !swap {
+		return a.s.compactCopy(a.s.Env())
+	}
+	if err := a.s.checkSwap(a.s.Env()); err != nil {
+		return status.CompactResult{}, err
+	}
+	req := compactRequest{result: make(chan compactResult, 1)}
+	select {
+	case a.s.swapRequests <- req:
+	case <-ctx.Done():
+		return status.CompactResult{}, ctx.Err()
+	}
+	select {
+	case res := <-req.result:
+		return res.CompactResult, res.err
+	case <-ctx.Done():
+		return status.CompactResult{}, ctx.Err()
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/klauspost/compress/gzip"
+	"github.com/sirupsen/logrus"
+)
+
+// BackupExtension is the extension of backups of the LMDB data file
+const BackupExtension = "mdb.gz"
+
+// Backup is a backup created by Syncer.Backup
+type Backup struct {
+	Name string // Name the backup was written under
+	Size int64  // Compressed size
+}
+
+// BackupOpener opens the destination for a backup with the given name, for
+// example with simpleblob.NewWriter.
+type BackupOpener func(name string) (io.WriteCloser, error)
+
+// Backup creates a backup of the LMDB without stopping the application.
+//
+// A consistent compacted copy of the LMDB is written to a temporary file in
+// tmpDir using a read transaction. If asSnapshot is true, the copy is
+// converted into a Lightning Stream snapshot, otherwise the backup is the
+// gzip compressed LMDB data file.
+// The name of the backup starts with the configured storage backup prefix,
+// so that it is never mistaken for a snapshot. The compressed backup is
+// streamed to the writer returned by open, which is always closed. On error,
+// the caller is responsible for removing any partially written backup.
+func (s *Syncer) Backup(ctx context.Context, env *lmdb.Env, tmpDir string, asSnapshot bool, open BackupOpener) (*Backup, error) {
+	t0 := time.Now()
+	tmpDir, err := os.MkdirTemp(tmpDir, "lightningstream-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	copyPath := filepath.Join(tmpDir, "data.mdb")
+	if err := lmdbenv.CompactCopy(env, copyPath, 0600); err != nil {
+		return nil, err
+	}
+	tCopied := time.Now()
+
+	ni := snapshot.NameInfo{
+		SyncerName:   s.c.Storage.BackupPrefix + s.name,
+		InstanceID:   s.instanceID(),
+		GenerationID: s.generationID(),
+		Timestamp:    t0,
+	}
+	if asSnapshot {
+		ni.Kind = snapshot.KindSnapshot
+		ni.Extension = snapshot.DefaultExtension
+	} else {
+		ni.Extension = BackupExtension
+	}
+	b := &Backup{
+		Name: ni.BuildName(),
+	}
+
+	w, err := open(b.Name)
+	if err != nil {
+		return nil, err
+	}
+	cw := &countingWriter{w: w}
+	if asSnapshot {
+		err = s.backupSnapshot(ctx, cw, copyPath)
+	} else {
+		err = compressFile(cw, copyPath)
+	}
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	b.Size = cw.n
+
+	s.l.WithFields(logrus.Fields{
+		"name":        b.Name,
+		"size":        datasize.ByteSize(b.Size).HumanReadable(),
+		"as_snapshot": asSnapshot,
+		"time_copy":   tCopied.Sub(t0).Round(time.Millisecond),
+		"time_total":  time.Since(t0).Round(time.Millisecond),
+	}).Info("Created backup")
+	return b, nil
+}
+
+// backupSnapshot writes the LMDB copy at path as a compressed snapshot to w
+func (s *Syncer) backupSnapshot(ctx context.Context, w io.Writer, path string) error {
+	// Nobody else uses this copy, so no lock file is needed, and it does not
+	// need to be durable. It keeps the map size of the original LMDB.
+	copyEnv, err := lmdbenv.NewWithOptions(path, lmdbenv.Options{
+		MaxDBs:   s.lc.Options.MaxDBs,
+		NoSubdir: true,
+		EnvFlags: lmdb.NoLock | lmdb.NoSync,
+	})
+	if err != nil {
+		return fmt.Errorf("open copy: %w", err)
+	}
+	defer func() {
+		_ = copyEnv.Close()
+	}()
+
+	// The shadow DBIs only reflect the state of the last sync, so update
+	// them with the current main DBIs first.
+	if !s.lc.SchemaTracksChanges {
+		err := copyEnv.Update(func(txn *lmdb.Txn) error {
+			return s.mainToShadow(ctx, txn, header.TimestampFromTime(time.Now()))
+		})
+		if err != nil {
+			return fmt.Errorf("update shadow DBIs of copy: %w", err)
+		}
+	}
+
+	msg, err := s.ReadSnapshot(ctx, copyEnv)
+	if err != nil {
+		return err
+	}
+	_, err = snapshot.DumpDataTo(w, msg, s.snapshotWorkers())
+	return err
+}
+
+// compressFile writes the gzip compressed contents of the file at path to w
+func compressFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, f); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,141 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob"
+	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_Backup(t *testing.T) {
+	ctx := context.Background()
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		c := config.Config{}
+		c.Storage.BackupPrefix = config.DefaultBackupPrefix
+		s, err := New("test", env, nil, c, config.LMDB{}, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			if err != nil {
+				return err
+			}
+			if err := txn.Put(dbi, []byte("key"), []byte("val"), 0); err != nil {
+				return err
+			}
+			return s.mainToShadow(ctx, txn, testTS(1))
+		})
+		require.NoError(t, err)
+
+		st := memory.New()
+		open := func(name string) (io.WriteCloser, error) {
+			return simpleblob.NewWriter(ctx, st, name)
+		}
+		load := func(b *Backup) []byte {
+			data, err := st.Load(ctx, b.Name)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), b.Size)
+			return data
+		}
+
+		t.Run("snapshot", func(t *testing.T) {
+			b, err := s.Backup(ctx, env, t.TempDir(), true, open)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(b.Name, "backup-test__"), b.Name)
+			ni, err := snapshot.ParseName(b.Name)
+			require.NoError(t, err)
+			assert.Equal(t, "backup-test", ni.SyncerName)
+
+			snap, err := snapshot.LoadData(load(b))
+			require.NoError(t, err)
+			require.Len(t, snap.Databases, 1)
+			assert.Equal(t, "foo", snap.Databases[0].Name())
+
+			// Local changes since the last sync are included, without
+			// changing the shadow DBIs of the LMDB
+			err = env.Update(func(txn *lmdb.Txn) error {
+				foo, err := txn.OpenDBI("foo", 0)
+				require.NoError(t, err)
+				require.NoError(t, txn.Put(foo, []byte("key2"), []byte("val2"), 0))
+				bar, err := txn.OpenDBI("bar", lmdb.Create)
+				require.NoError(t, err)
+				return txn.Put(bar, []byte("a"), []byte("b"), 0)
+			})
+			require.NoError(t, err)
+			b, err = s.Backup(ctx, env, t.TempDir(), true, open)
+			require.NoError(t, err)
+			snap, err = snapshot.LoadData(load(b))
+			require.NoError(t, err)
+			entries := make(map[string][]string)
+			for _, dbiMsg := range snap.Databases {
+				for {
+					kv, err := dbiMsg.Next()
+					if err != nil {
+						break
+					}
+					entries[dbiMsg.Name()] = append(entries[dbiMsg.Name()], string(kv.Key))
+				}
+			}
+			assert.Equal(t, map[string][]string{"foo": {"key", "key2"}, "bar": {"a"}}, entries)
+			err = env.View(func(txn *lmdb.Txn) error {
+				exists, err := lmdbenv.DBIExists(txn, SyncDBIShadowPrefix+"bar")
+				require.NoError(t, err)
+				assert.False(t, exists)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+
+		t.Run("raw", func(t *testing.T) {
+			b, err := s.Backup(ctx, env, t.TempDir(), false, open)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(b.Name, "backup-test__"), b.Name)
+			assert.True(t, strings.HasSuffix(b.Name, "."+BackupExtension), b.Name)
+
+			gr, err := gzip.NewReader(bytes.NewReader(load(b)))
+			require.NoError(t, err)
+			data, err := io.ReadAll(gr)
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), "data.mdb")
+			require.NoError(t, os.WriteFile(path, data, 0600))
+
+			restored, err := lmdbenv.NewWithOptions(path, lmdbenv.Options{
+				MaxDBs:   10,
+				NoSubdir: true,
+				EnvFlags: lmdb.Readonly | lmdb.NoLock,
+			})
+			require.NoError(t, err)
+			defer func() {
+				_ = restored.Close()
+			}()
+			err = restored.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				if err != nil {
+					return err
+				}
+				val, err := txn.Get(dbi, []byte("key"))
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, "val", string(val))
+				return nil
+			})
+			require.NoError(t, err)
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
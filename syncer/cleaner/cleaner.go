@@ -9,15 +9,18 @@ import (
 
 	"github.com/PowerDNS/lightningstream/config"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/simpleblob"
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 )
 
-func New(name string, st simpleblob.Interface, cc config.Cleanup, logger logrus.FieldLogger) *Worker {
+func New(name string, st simpleblob.Interface, cc config.Cleanup, logger logrus.FieldLogger, ev *events.Events) *Worker {
 	return &Worker{
 		st:               st,
+		events:           ev,
 		name:             name,
 		prefix:           name + "__",
 		l:                logger.WithField("component", "cleaner"),
@@ -39,13 +42,58 @@ type Worker struct {
 	ignoredFilenames map[string]bool
 	snapFirstSeen    map[string]time.Time
 	conf             config.Cleanup
+	events           *events.Events
 
-	// mu protects lastByInstance
+	// runMu prevents concurrent runs and protects ignoredFilenames and
+	// snapFirstSeen
+	runMu sync.Mutex
+
+	// mu protects lastByInstance and lastRun
 	mu sync.Mutex
 	// lastByInstance tracks the last snapshot loaded by instance and
 	// successfully committed to a snapshot, so that the cleaner can make safe
 	// decisions about when to remove stale snapshots.
 	lastByInstance map[string]time.Time
+	// lastRun is the result of the last cleaner run, for the status API
+	lastRun RunState
+}
+
+// RunState describes the result of a cleaner run
+type RunState struct {
+	Time    time.Time // Start time of the run, zero if it never ran
+	Error   string    // Error that aborted the run
+	Total   int       // Number of snapshots considered
+	Cleaned int       // Number of snapshots removed
+	Failed  int       // Number of snapshots that could not be removed
+}
+
+// State is a summary of the cleaner state for the status API
+type State struct {
+	Enabled   bool
+	LastRun   RunState
+	Committed map[string]time.Time // See GetCommitted
+}
+
+// Enabled returns true if cleanup is enabled
+func (w *Worker) Enabled() bool {
+	return w.conf.Enabled
+}
+
+// State returns a copy of the current cleaner state
+func (w *Worker) State() State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return State{
+		Enabled:   w.conf.Enabled,
+		LastRun:   w.lastRun,
+		Committed: maps.Clone(w.lastByInstance),
+	}
+}
+
+func (w *Worker) setLastRun(rs RunState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastRun = rs
 }
 
 // SetCommitted records the snapshot time of the last snapshots loaded
@@ -82,15 +130,25 @@ func (w *Worker) Run(ctx context.Context) error {
 	}
 }
 
-func (w *Worker) RunOnce(ctx context.Context, now time.Time) error {
+// RunOnce performs a single cleanup run. It can be called concurrently with
+// Run, but runs never overlap.
+func (w *Worker) RunOnce(ctx context.Context, now time.Time) (err error) {
 	if !w.conf.Enabled {
 		return nil
 	}
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "cleaner.RunOnce", tracing.AttrDB.String(w.name))
+	defer func() {
+		tracing.End(span, err)
+	}()
 
 	ls, err := w.st.List(ctx, w.prefix)
 	metricListCalls.Inc()
 	if err != nil {
 		metricListFailed.Inc()
+		w.setLastRun(RunState{Time: now, Error: err.Error()})
 		return err
 	}
 	names := ls.Names()
@@ -200,6 +258,7 @@ func (w *Worker) RunOnce(ctx context.Context, now time.Time) error {
 			nError++
 			continue
 		}
+		w.deleted(ni, "newer snapshot")
 		nCleaned++
 	}
 
@@ -226,6 +285,7 @@ func (w *Worker) RunOnce(ctx context.Context, now time.Time) error {
 		}
 		l.WithField("instance", ni.InstanceID).Info(
 			"Cleaning stale instance snapshot, merge proven")
+		w.deleted(ni, "stale instance")
 		nCleaned++
 	}
 
@@ -234,6 +294,24 @@ func (w *Worker) RunOnce(ctx context.Context, now time.Time) error {
 		"failed":  nError,
 		"total":   nTotal,
 	}).Debug("Cleaning stats")
+	span.SetAttributes(
+		tracing.AttrTotal.Int(nTotal),
+		tracing.AttrCleaned.Int(nCleaned),
+		tracing.AttrFailed.Int(nError))
+	w.setLastRun(RunState{
+		Time:    now,
+		Total:   nTotal,
+		Cleaned: nCleaned,
+		Failed:  nError,
+	})
 
 	return nil
 }
+
+// deleted publishes the removal of a snapshot
+func (w *Worker) deleted(ni snapshot.NameInfo, reason string) {
+	w.events.SnapshotDeleted.Publish(events.DeletedInfo{
+		NameInfo: ni,
+		Reason:   reason,
+	})
+}
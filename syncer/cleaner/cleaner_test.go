@@ -8,6 +8,7 @@ import (
 
 	"github.com/PowerDNS/lightningstream/config"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/simpleblob/backends/memory"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -44,13 +45,25 @@ func TestWorker(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Collect the deletion events
+	ev := events.New()
+	sub := ev.SnapshotDeleted.Subscribe(false)
+	deleted := map[string]string{} // name to reason
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for di := range sub.Channel() {
+			deleted[di.NameInfo.FullName] = di.Reason
+		}
+	}()
+
 	// We can control the clock in this test through the 'now' param
 	w := New("test", st, config.Cleanup{
 		Enabled:                    true,
 		Interval:                   time.Minute, // not used in test
 		MustKeepInterval:           10 * time.Minute,
 		RemoveOldInstancesInterval: 7 * 24 * time.Hour,
-	}, logger)
+	}, logger, ev)
 
 	addSnap := func(name string) {
 		assert.NoError(t, st.Store(ctx, name, []byte{'x'}))
@@ -150,4 +163,15 @@ func TestWorker(t *testing.T) {
 		//snap("test", "old", "2020-01-01 05:00:00"),
 	})
 
+	sub.Close()
+	<-done
+	assert.Equal(t, map[string]string{
+		snap("test", "a", "2020-01-30 08:00:00"):   "newer snapshot",
+		snap("test", "a", "2020-01-30 08:01:00"):   "newer snapshot",
+		snap("test", "a", "2020-01-30 08:02:00"):   "newer snapshot",
+		snap("test", "old", "2020-01-01 06:00:00"): "newer snapshot",
+		snap("test", "a", "2020-01-30 08:03:00"):   "newer snapshot",
+		snap("test", "old", "2020-01-01 07:00:00"): "stale instance",
+		snap("test", "old", "2020-01-01 05:00:00"): "stale instance",
+	}, deleted)
 }
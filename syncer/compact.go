@@ -0,0 +1,129 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/status"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/sirupsen/logrus"
+)
+
+// compactRequest is a swap of a compacted copy requested by the admin API
+type compactRequest struct {
+	result chan compactResult // buffered, receives exactly one result
+}
+
+type compactResult struct {
+	status.CompactResult
+	err error
+}
+
+// Env returns the env of the syncer. This is the env passed to New, unless a
+// compacted copy was swapped in, in which case the old env was closed and
+// replaced by a new one. The env must be closed with lmdbenv.Close.
+func (s *Syncer) Env() *lmdb.Env {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.env
+}
+
+// compactPath returns the path of the compacted copy of the data file
+func compactPath(env *lmdb.Env) (string, error) {
+	dataPath, err := lmdbenv.DataPath(env)
+	if err != nil {
+		return "", err
+	}
+	return dataPath + ".compact", nil
+}
+
+// compactCopy writes a compacted copy of the env next to its data file
+func (s *Syncer) compactCopy(env *lmdb.Env) (res status.CompactResult, err error) {
+	res.Path, err = compactPath(env)
+	if err != nil {
+		return res, err
+	}
+	t0 := time.Now()
+	if err := lmdbenv.CompactCopy(env, res.Path, s.lc.Options.WithDefaults().FileMask); err != nil {
+		return res, err
+	}
+	st, err := os.Stat(res.Path)
+	if err != nil {
+		return res, err
+	}
+	res.Size = st.Size()
+	s.l.WithFields(logrus.Fields{
+		"path":      res.Path,
+		"file_size": res.Size,
+		"time_used": time.Since(t0).Round(time.Millisecond),
+	}).Info("Wrote compacted copy")
+	return res, nil
+}
+
+// checkSwap returns an error if a compacted copy cannot be swapped in now
+func (s *Syncer) checkSwap(env *lmdb.Env) error {
+	if !s.pauseLoad.Load() || !s.pauseSend.Load() {
+		return errors.New("pause loading and sending before swapping in a compacted copy")
+	}
+	inUse, err := lmdbenv.InUseByOtherProcess(env)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return errors.New("the LMDB is open in another process, close the " +
+			"application before swapping in a compacted copy")
+	}
+	return nil
+}
+
+// swapCompacted writes a compacted copy of the env and replaces the data file
+// with it. This must be called by the sync loop after stopping the env
+// workers, so that nothing else in this process uses the env.
+// The old env is closed and the new env is returned. If the copy failed, the
+// old env is returned with the error. If the env could not be opened again,
+// the returned env is nil.
+func (s *Syncer) swapCompacted(env *lmdb.Env) (newEnv *lmdb.Env, res status.CompactResult, err error) {
+	res, err = s.compactCopy(env)
+	if err != nil {
+		return env, res, err
+	}
+	dataPath, err := lmdbenv.DataPath(env)
+	if err != nil {
+		return env, res, err
+	}
+	// The application could have opened the LMDB during the copy
+	if err := s.checkSwap(env); err != nil {
+		_ = os.Remove(res.Path)
+		return env, res, err
+	}
+
+	if err := lmdbenv.Close(env); err != nil {
+		return nil, res, fmt.Errorf("close env: %w", err)
+	}
+	backupPath := lmdbenv.BackupPath(dataPath, time.Now())
+	swapErr := lmdbenv.SwapDataFile(dataPath, res.Path, backupPath)
+	// Open the env again, even if the swap failed
+	newEnv, err = OpenEnv(s.l, s.lc)
+	if err != nil {
+		return nil, res, fmt.Errorf("open env after swap: %w", err)
+	}
+	s.statusMu.Lock()
+	s.env = newEnv
+	s.statusMu.Unlock()
+	s.events.EnvReopened.Publish(newEnv)
+	if swapErr != nil {
+		return newEnv, res, swapErr
+	}
+
+	res.Path = dataPath
+	res.Swapped = true
+	res.Backup = backupPath
+	s.l.WithFields(logrus.Fields{
+		"data_file": dataPath,
+		"backup":    backupPath,
+	}).Warn("Swapped in compacted copy, remove the backup when no longer needed")
+	return newEnv, res, nil
+}
@@ -0,0 +1,94 @@
+package syncer
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_Compact_swap(t *testing.T) {
+	t.Run("with-timestamped-schema", func(t *testing.T) {
+		testCompactSwap(t, true)
+	})
+	t.Run("with-shadow", func(t *testing.T) {
+		testCompactSwap(t, false)
+	})
+}
+
+func testCompactSwap(t *testing.T, withHeader bool) {
+	st := memory.New()
+	s, env := createInstance(t, "a", st, withHeader)
+	for _, key := range []string{"foo", "bar", "baz"} {
+		setKey(t, env, key, "v1", withHeader)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	goRunSync(ctx, s)
+	requireSnapshotsLenWait(t, st, 1, "A")
+
+	a := &adminController{s: s}
+	res, err := a.Compact(ctx, false)
+	require.NoError(t, err)
+	assert.False(t, res.Swapped)
+	assert.Greater(t, res.Size, int64(0))
+	_, err = os.Stat(res.Path)
+	require.NoError(t, err)
+
+	// Swapping requires a paused syncer
+	_, err = a.Compact(ctx, true)
+	require.ErrorContains(t, err, "pause loading and sending")
+
+	a.SetPaused(true, true)
+	res, err = a.Compact(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, res.Swapped)
+	_, err = os.Stat(res.Backup)
+	require.NoError(t, err)
+
+	// The old env was closed and replaced
+	assert.ErrorIs(t, lmdbenv.View(env, func(txn *lmdb.Txn) error { return nil }), lmdbenv.ErrEnvClosed)
+	newEnv := s.Env()
+	require.NotSame(t, env, newEnv)
+	kv, err := dumpData(newEnv, withHeader)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "v1", "bar": "v1", "baz": "v1"}, kv)
+
+	// Syncing continues with the new env once resumed
+	a.SetPaused(false, false)
+	setKey(t, newEnv, "new", "v2", withHeader)
+	require.Eventually(t, func() bool {
+		stored, ok := s.events.UpdateStored.Last()
+		if !ok {
+			return false
+		}
+		data, err := st.Load(ctx, stored.NameInfo.BuildName())
+		require.NoError(t, err)
+		msg, err := snapshot.LoadData(data)
+		require.NoError(t, err)
+		for _, dbiMsg := range msg.Databases {
+			if dbiMsg.Name() != testDBIName {
+				continue
+			}
+			for {
+				kv, err := dbiMsg.Next()
+				if err != nil {
+					break
+				}
+				if string(kv.Key) == "new" {
+					return true
+				}
+			}
+		}
+		return false
+	}, 2*time.Second, tick)
+	cancel()
+}
@@ -0,0 +1,327 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/utils"
+)
+
+// dupSortTransform returns the snapshot transform used for DupSort DBIs, or
+// snapshot.TransformNone if DupSort DBIs are not supported by the config.
+func (s *Syncer) dupSortTransform() string {
+	switch {
+	case s.lc.DupSortSet:
+		return snapshot.TransformDupSortSetV1
+	case s.lc.DupSortHack:
+		return snapshot.TransformDupSortHackV1
+	default:
+		return snapshot.TransformNone
+	}
+}
+
+// dupSetKV returns the snapshot KV for a DupSort key with the given values.
+// The values are sorted in place, because values with a native header are not
+// stored in value order.
+// The timestamp is the highest timestamp of the values, and the entry is only
+// marked as deleted if all values are deleted.
+func dupSetKV(key []byte, vals []snapshot.DupValue) snapshot.KV {
+	sort.Slice(vals, func(i, j int) bool {
+		return bytes.Compare(vals[i].Value, vals[j].Value) < 0
+	})
+	kv := snapshot.KV{
+		Key:   key,
+		Value: snapshot.EncodeDupSet(vals),
+	}
+	allDeleted := len(vals) > 0
+	for _, v := range vals {
+		if v.TimestampNano > kv.TimestampNano {
+			kv.TimestampNano = v.TimestampNano
+		}
+		if !v.MaskedFlags().IsDeleted() {
+			allDeleted = false
+		}
+	}
+	if allDeleted {
+		kv.Flags = uint32(header.FlagDeleted)
+	}
+	return kv
+}
+
+// dupSetExpand creates a copy of a snapshot.DBI with dupsort_set_v1 entries
+// expanded into one plain KV per value that is not deleted.
+func dupSetExpand(dbiMsg *snapshot.DBI) (*snapshot.DBI, error) {
+	expanded := snapshot.NewDBISize(dbiMsg.Size())
+	expanded.SetName(dbiMsg.Name())
+	expanded.SetFlags(dbiMsg.Flags())
+	it := &PlainIterator{DBIMsg: dbiMsg}
+	for {
+		key, err := it.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		vals, err := snapshot.DecodeDupSet(it.curKV.Value)
+		if err != nil {
+			return nil, fmt.Errorf("dupsort_set decode for key %s: %w",
+				utils.DisplayASCII(key), err)
+		}
+		for _, v := range vals {
+			if v.MaskedFlags().IsDeleted() {
+				continue
+			}
+			expanded.Append(snapshot.KV{Key: key, Value: v.Value})
+		}
+	}
+	return expanded, nil
+}
+
+// DupSetIterator iterates over a snapshot DBI with the dupsort_set_v1
+// transform and merges the value sets per key.
+// It reuses the NativeIterator for the iteration and its settings, but
+// replaces the merge logic. It can be used in two ways:
+//   - As a strategy.Iterator for shadow DBIs, where each key has a single value
+//     with a native header followed by the encoded set.
+//   - As a strategy.DupIterator for native DupSort DBIs, where each value of a
+//     key is prefixed with its own native header.
+//
+// Deleted values are retained with their application value, so that they can
+// be matched with values in other instances.
+type DupSetIterator struct {
+	*NativeIterator
+}
+
+// dupMergeEntry is a merged DupSort value
+type dupMergeEntry struct {
+	snapshot.DupValue
+	old int // index of the old value if unchanged, or -1
+}
+
+// merge merges the old values with the values of the current snapshot entry.
+// If the current entry has no timestamp, it represents the full current state
+// of the key in the main DBI, and the DefaultTimestampNano is used for any
+// value added or removed.
+func (it *DupSetIterator) merge(old []snapshot.DupValue, clean bool) (result []dupMergeEntry, changed bool, err error) {
+	entry := it.curKV
+	var newVals []snapshot.DupValue
+	if !clean {
+		newVals, err = snapshot.DecodeDupSet(entry.Value)
+		if err != nil {
+			return nil, false, fmt.Errorf("dupsort_set decode for key %s: %w",
+				utils.DisplayASCII(entry.Key), err)
+		}
+	}
+
+	result = make([]dupMergeEntry, 0, len(old)+len(newVals))
+	index := make(map[string]int, len(old)+len(newVals))
+	for i, v := range old {
+		index[string(v.Value)] = len(result)
+		result = append(result, dupMergeEntry{DupValue: v, old: i})
+	}
+
+	remote := !clean && entry.TimestampNano != 0
+	if !remote {
+		// Full state of the key in the main DBI
+		defaultTS := uint64(it.DefaultTimestampNano)
+		present := make(map[string]bool, len(newVals))
+		for _, v := range newVals {
+			present[string(v.Value)] = true
+			i, exists := index[string(v.Value)]
+			if !exists {
+				index[string(v.Value)] = len(result)
+				result = append(result, dupMergeEntry{
+					DupValue: snapshot.DupValue{Value: v.Value, TimestampNano: defaultTS},
+					old:      -1,
+				})
+				continue
+			}
+			if result[i].MaskedFlags().IsDeleted() {
+				result[i].DupValue = snapshot.DupValue{Value: v.Value, TimestampNano: defaultTS}
+				result[i].old = -1
+			}
+		}
+		for i := range result {
+			if present[string(result[i].Value)] || result[i].MaskedFlags().IsDeleted() {
+				continue
+			}
+			result[i].TimestampNano = defaultTS
+			result[i].Flags = uint32(header.FlagDeleted)
+			result[i].old = -1
+		}
+	} else {
+		// Remote snapshot with timestamps
+		for _, v := range newVals {
+			v.Flags = uint32(v.MaskedFlags())
+			if v.TimestampNano == 0 {
+				v.TimestampNano = entry.TimestampNano
+			}
+			i, exists := index[string(v.Value)]
+			if !exists {
+				index[string(v.Value)] = len(result)
+				result = append(result, dupMergeEntry{DupValue: v, old: -1})
+				continue
+			}
+			cur := result[i]
+			if v.TimestampNano < cur.TimestampNano {
+				continue // current value is newer
+			}
+			if v.TimestampNano == cur.TimestampNano && v.Flags <= uint32(cur.MaskedFlags()) {
+				// Same timestamp, higher flags (deleted) win for deterministic
+				// values, so keep the current one if it was higher or equal.
+				continue
+			}
+			result[i] = dupMergeEntry{DupValue: v, old: -1}
+		}
+	}
+
+	// Values that are present, to tell which changes are visible. A newer
+	// deletion marker for an absent value is stored, but is not visible.
+	oldVisible := make(map[string]bool, len(old))
+	for _, v := range old {
+		if !v.MaskedFlags().IsDeleted() {
+			oldVisible[string(v.Value)] = true
+		}
+	}
+
+	// Remove stale deleted values that may already have been swept
+	visibleChanged := false
+	cleaned := result[:0]
+	for _, e := range result {
+		deleted := e.MaskedFlags().IsDeleted()
+		if deleted && header.Timestamp(e.TimestampNano) < it.DeletedCutoff {
+			continue
+		}
+		if e.old < 0 {
+			changed = true
+			if deleted == oldVisible[string(e.Value)] {
+				visibleChanged = true
+				if remote {
+					it.observeLatency(e.TimestampNano)
+				}
+			}
+		}
+		cleaned = append(cleaned, e)
+	}
+	result = cleaned
+	if len(result) != len(old) {
+		changed = true // only deleted values are removed
+	}
+	if remote {
+		switch {
+		case !visibleChanged:
+			it.Stats.Unchanged++
+		case len(oldVisible) == 0:
+			it.Stats.Added++
+		default:
+			it.Stats.Updated++
+		}
+		if visibleChanged {
+			it.keyChanged()
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Value, result[j].Value) < 0
+	})
+	return result, changed, nil
+}
+
+// Merge merges the value set in a shadow DBI value with the current entry
+func (it *DupSetIterator) Merge(oldval []byte) (val []byte, err error) {
+	return it.mergeShadow(oldval, false)
+}
+
+// Clean marks all values in a shadow DBI value as deleted
+func (it *DupSetIterator) Clean(oldval []byte) (val []byte, err error) {
+	return it.mergeShadow(oldval, true)
+}
+
+func (it *DupSetIterator) mergeShadow(oldval []byte, clean bool) (val []byte, err error) {
+	var old []snapshot.DupValue
+	if len(oldval) > 0 {
+		_, appVal, err := header.Parse(oldval)
+		if err != nil {
+			it.logDebugValue(oldval)
+			return nil, fmt.Errorf("merge: oldval header parse error (%v = %v): %v",
+				it.curKV.Key, oldval, err)
+		}
+		old, err = snapshot.DecodeDupSet(appVal)
+		if err != nil {
+			return nil, fmt.Errorf("merge: oldval dupsort_set decode: %w", err)
+		}
+	}
+	result, changed, err := it.merge(old, clean)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		if len(oldval) == 0 {
+			return nil, nil
+		}
+		return oldval, nil
+	}
+	if len(result) == 0 {
+		return nil, nil // remove
+	}
+	vals := make([]snapshot.DupValue, len(result))
+	for i, e := range result {
+		vals[i] = e.DupValue
+	}
+	kv := dupSetKV(nil, vals)
+	return it.dupHeader(kv.Value, header.Timestamp(kv.TimestampNano), kv.MaskedFlags()), nil
+}
+
+// MergeDups merges the values of a native DupSort key with the current entry.
+// Unchanged values are returned as is, so that they retain their header.
+func (it *DupSetIterator) MergeDups(oldvals [][]byte) (vals [][]byte, changed bool, err error) {
+	old := make([]snapshot.DupValue, len(oldvals))
+	for i, oldval := range oldvals {
+		h, appVal, err := header.Parse(oldval)
+		if err != nil {
+			it.logDebugValue(oldval)
+			return nil, false, fmt.Errorf("merge: oldval header parse error (%v = %v): %v",
+				it.curKV.Key, oldval, err)
+		}
+		old[i] = snapshot.DupValue{
+			Value:         appVal,
+			TimestampNano: uint64(h.Timestamp),
+			Flags:         uint32(h.Flags.Masked()),
+		}
+	}
+	result, changed, err := it.merge(old, false)
+	if err != nil || !changed {
+		return nil, false, err
+	}
+	vals = make([][]byte, len(result))
+	for i, e := range result {
+		if e.old >= 0 {
+			vals[i] = oldvals[e.old]
+			continue
+		}
+		vals[i] = it.dupHeader(e.Value, header.Timestamp(e.TimestampNano), e.MaskedFlags())
+	}
+	return vals, true, nil
+}
+
+// dupHeader returns a new value with a native header prepended to the plain
+// value. Unlike NativeIterator.addHeader, it retains the plain value for
+// deleted entries and always allocates a new slice.
+func (it *DupSetIterator) dupHeader(plainVal []byte, ts header.Timestamp, flags header.Flags) []byte {
+	size := header.MinHeaderSize
+	if it.HeaderPaddingBlock {
+		size += 8
+	}
+	val := make([]byte, size, size+len(plainVal))
+	header.PutBasic(val, ts, it.TxnID, flags)
+	if it.HeaderPaddingBlock {
+		// Add an extra all-zero padding block to test application handling
+		val[header.NumExtraOffsetLow] = 1
+	}
+	return append(val, plainVal...)
+}
@@ -0,0 +1,266 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dupDeleted = uint32(header.FlagDeleted)
+
+// dupSetSnapshot returns a remote snapshot update with a single DupSort DBI
+func dupSetSnapshot(entries map[string][]snapshot.DupValue, keys ...string) snapshot.Update {
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	dbiMsg.SetFlags(uint64(lmdb.DupSort))
+	dbiMsg.SetTransform(snapshot.TransformDupSortSetV1)
+	for _, k := range keys {
+		dbiMsg.Append(dupSetKV(b(k), entries[k]))
+	}
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+	return snapshot.Update{Snapshot: snap}
+}
+
+// readDupSets reads the DupSort DBI "foo" as sets using readDBI
+func readDupSets(t *testing.T, s *Syncer, env *lmdb.Env, dbiName string) map[string][]snapshot.DupValue {
+	sets := make(map[string][]snapshot.DupValue)
+	err := env.View(func(txn *lmdb.Txn) error {
+		dbiMsg, err := s.readDBI(txn, dbiName, "foo", false)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.TransformDupSortSetV1, dbiMsg.Transform())
+		kvs, err := dbiMsg.AsInefficientKVList()
+		require.NoError(t, err)
+		for _, kv := range kvs {
+			vals, err := snapshot.DecodeDupSet(kv.Value)
+			require.NoError(t, err)
+			for _, v := range vals {
+				sets[string(kv.Key)] = append(sets[string(kv.Key)], snapshot.DupValue{
+					Value:         append([]byte(nil), v.Value...),
+					TimestampNano: v.TimestampNano,
+					Flags:         v.Flags,
+				})
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return sets
+}
+
+func dv(val string, ts header.Timestamp, flags uint32) snapshot.DupValue {
+	return snapshot.DupValue{Value: b(val), TimestampNano: uint64(ts), Flags: flags}
+}
+
+func TestSyncer_dupSet_shadow(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+	ts3 := testTS(3)
+	long := string(rep('K', 400))
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{DupSortSet: true}, Options{})
+		require.NoError(t, err)
+
+		// Initial data, including a key that dupsort_hack cannot handle
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create|lmdb.DupSort)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("a"), b("1"), 0))
+			require.NoError(t, txn.Put(dbi, b("a"), b("2"), 0))
+			require.NoError(t, txn.Put(dbi, b("b"), b("x"), 0))
+			require.NoError(t, txn.Put(dbi, b(long), b("v"), 0))
+			return s.mainToShadow(ctx, txn, ts1)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]snapshot.DupValue{
+			"a":  {dv("1", ts1, 0), dv("2", ts1, 0)},
+			"b":  {dv("x", ts1, 0)},
+			long: {dv("v", ts1, 0)},
+		}, readDupSets(t, s, env, "_sync_shadow_foo"))
+
+		// Local changes
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			require.NoError(t, txn.Del(dbi, b("a"), b("1")))
+			require.NoError(t, txn.Put(dbi, b("a"), b("3"), 0))
+			require.NoError(t, txn.Del(dbi, b("b"), b("x")))
+			return s.mainToShadow(ctx, txn, ts2)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]snapshot.DupValue{
+			"a":  {dv("1", ts2, dupDeleted), dv("2", ts1, 0), dv("3", ts2, 0)},
+			"b":  {dv("x", ts2, dupDeleted)},
+			long: {dv("v", ts1, 0)},
+		}, readDupSets(t, s, env, "_sync_shadow_foo"))
+
+		// Remote changes: older additions lose, newer changes win
+		update := dupSetSnapshot(map[string][]snapshot.DupValue{
+			"a": {dv("1", ts1, 0), dv("2", ts3, dupDeleted)},
+			"b": {dv("x", ts1, 0), dv("y", ts3, 0)},
+		}, "a", "b")
+		_, _, err = s.LoadOnce(ctx, env, "remote", update, 0)
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: long, Val: "v"},
+				{Key: "a", Val: "3"},
+				{Key: "b", Val: "y"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// A remote dupsort_hack snapshot is rejected
+		update = dupSetSnapshot(nil)
+		update.Snapshot.Databases[0].SetTransform(snapshot.TransformDupSortHackV1)
+		_, _, err = s.LoadOnce(ctx, env, "remote", update, 0)
+		assert.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_dupSet_native(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{DupSortSet: true, SchemaTracksChanges: true}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		// Local native data, with a header per value
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create|lmdb.DupSort)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("a"), b(h(ts1, 1, 0)+"1"), 0))
+			require.NoError(t, txn.Put(dbi, b("a"), b(h(ts1, 1, 0)+"2"), 0))
+			return nil
+		})
+		require.NoError(t, err)
+
+		update := dupSetSnapshot(map[string][]snapshot.DupValue{
+			"a": {dv("1", ts2, dupDeleted), dv("3", ts2, 0)},
+			"b": {dv("x", ts1, 0)},
+		}, "a", "b")
+		txnID, _, err := s.LoadOnce(ctx, env, "remote", update, 0)
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "a", Val: h(ts1, 1, 0) + "2"}, // unchanged
+				{Key: "a", Val: h(ts2, txnID, 0) + "3"},
+				{Key: "a", Val: h(ts2, txnID, header.FlagDeleted) + "1"},
+				{Key: "b", Val: h(ts1, txnID, 0) + "x"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string][]snapshot.DupValue{
+			"a": {dv("1", ts2, dupDeleted), dv("2", ts1, 0), dv("3", ts2, 0)},
+			"b": {dv("x", ts1, 0)},
+		}, readDupSets(t, s, env, "foo"))
+
+		// Loading the same snapshot again does not change anything
+		txnID2, _, err := s.LoadOnce(ctx, env, "remote", update, txnID)
+		require.NoError(t, err)
+		assert.Equal(t, txnID, txnID2)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDupSetIterator_merge_stats(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+	tt := []struct {
+		Name     string
+		Old      []snapshot.DupValue
+		New      []snapshot.DupValue
+		Changed  bool
+		Observed int // replication latencies observed
+		Stats    events.DBIStats
+	}{
+		{
+			Name:     "add",
+			New:      []snapshot.DupValue{dv("1", ts1, 0)},
+			Changed:  true,
+			Observed: 1,
+			Stats:    events.DBIStats{Added: 1},
+		},
+		{
+			Name:    "deleted-absent-value",
+			New:     []snapshot.DupValue{dv("1", ts1, dupDeleted)},
+			Changed: true,
+			Stats:   events.DBIStats{Unchanged: 1},
+		},
+		{
+			Name:    "newer-deleted-value",
+			Old:     []snapshot.DupValue{dv("1", ts1, dupDeleted), dv("2", ts1, 0)},
+			New:     []snapshot.DupValue{dv("1", ts2, dupDeleted)},
+			Changed: true,
+			Stats:   events.DBIStats{Unchanged: 1},
+		},
+		{
+			Name:     "delete-value",
+			Old:      []snapshot.DupValue{dv("1", ts1, 0), dv("2", ts1, 0)},
+			New:      []snapshot.DupValue{dv("1", ts2, dupDeleted)},
+			Changed:  true,
+			Observed: 1,
+			Stats:    events.DBIStats{Updated: 1},
+		},
+		{
+			Name:  "same",
+			Old:   []snapshot.DupValue{dv("1", ts1, 0)},
+			New:   []snapshot.DupValue{dv("1", ts1, 0)},
+			Stats: events.DBIStats{Unchanged: 1},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var latencies observations
+			it := &DupSetIterator{NativeIterator: &NativeIterator{
+				TxnID:         123,
+				FormatVersion: snapshot.CurrentFormatVersion,
+				Latency:       &latencies,
+				ApplyTime:     time.Now(),
+				KeyLimit:      10,
+			}}
+			it.curKV = dupSetKV(b("key"), tc.New)
+			_, changed, err := it.merge(tc.Old, false)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Changed, changed)
+			assert.Len(t, latencies, tc.Observed)
+			assert.Len(t, it.Stats.ChangedKeys, tc.Stats.Changed())
+			it.Stats.ChangedKeys = nil
+			assert.Equal(t, tc.Stats, it.Stats)
+		})
+	}
+}
@@ -17,7 +17,7 @@ func OpenEnv(l logrus.FieldLogger, lc config.LMDB) (env *lmdb.Env, err error) {
 	}
 
 	// Print some env info
-	info, err := env.Info()
+	info, err := lmdbenv.Info(env)
 	if err != nil {
 		return nil, err
 	}
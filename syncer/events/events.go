@@ -1,8 +1,12 @@
 package events
 
 import (
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/snapshot"
 	"github.com/PowerDNS/lightningstream/utils/topics"
+	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/PowerDNS/simpleblob"
 )
 
@@ -14,6 +18,9 @@ func New() *Events {
 		UpdateLoaded:               topics.New[UpdateInfo](),
 		UpdateStored:               topics.New[UpdateInfo](),
 		SnapshotOverdue:            topics.New[struct{}](),
+		SnapshotDeleted:            topics.New[DeletedInfo](),
+		EntriesSwept:               topics.New[SweepInfo](),
+		EnvReopened:                topics.New[*lmdb.Env](),
 	}
 }
 
@@ -37,9 +44,80 @@ type Events struct {
 	// SnapshotOverdue is triggered when we force a snapshot due to a
 	// forced snapshot interval.
 	SnapshotOverdue *topics.Topic[struct{}]
+
+	// SnapshotDeleted is triggered when the cleaner removed a snapshot from
+	// storage.
+	SnapshotDeleted *topics.Topic[DeletedInfo]
+
+	// EntriesSwept is triggered after a sweep that removed stale deleted
+	// entries from the LMDB.
+	EntriesSwept *topics.Topic[SweepInfo]
+
+	// EnvReopened is triggered with the new env after a compacted copy of
+	// the data file was swapped in. The old env is closed.
+	EnvReopened *topics.Topic[*lmdb.Env]
 }
 
 type UpdateInfo struct {
 	NameInfo snapshot.NameInfo
 	Meta     snapshot.Meta
+
+	// TxnID is the LMDB transaction the update was loaded in.
+	// Only set for UpdateLoaded, see Meta.LmdbTxnID for UpdateStored.
+	TxnID header.TxnID
+	// Size is the compressed size of the snapshot
+	Size int64
+	// LocalChanged is true if the load changed the local LMDB.
+	// Only set for UpdateLoaded.
+	LocalChanged bool
+	// DBIStats describes the changes a loaded update made per DBI name.
+	// Only set for UpdateLoaded.
+	DBIStats map[string]DBIStats
+	// DBIEntries is the number of entries per DBI name in the snapshot.
+	// Only set for UpdateStored.
+	DBIEntries map[string]int64
+}
+
+// DeletedInfo describes a snapshot removed by the cleaner
+type DeletedInfo struct {
+	NameInfo snapshot.NameInfo
+	Reason   string // "newer snapshot" or "stale instance"
+}
+
+// SweepInfo describes the stale deleted entries removed by a sweep
+type SweepInfo struct {
+	Cutoff  time.Time      // deleted entries older than this were removed
+	Cleaned map[string]int // by DBI name, only DBIs with removals
+}
+
+// DBIStats counts how the entries of a snapshot DBI were merged into the LMDB
+type DBIStats struct {
+	Added     int `json:"added"`     // keys that did not exist or were deleted
+	Updated   int `json:"updated"`   // keys that got a newer value
+	Deleted   int `json:"deleted"`   // keys that were marked as deleted
+	Skipped   int `json:"skipped"`   // entries older than the value in the LMDB
+	Unchanged int `json:"unchanged"` // entries that did not change the visible value
+	Dropped   int `json:"dropped"`   // deleted entries older than the deleted cutoff
+
+	// ChangedKeys are the keys that were added, updated or deleted, if
+	// collected. KeysTruncated is set if not all of them were collected.
+	ChangedKeys   [][]byte `json:"-"`
+	KeysTruncated bool     `json:"-"`
+}
+
+// Changed returns the number of entries that changed the LMDB
+func (st DBIStats) Changed() int {
+	return st.Added + st.Updated + st.Deleted
+}
+
+// Add adds the counts of other to the stats
+func (st *DBIStats) Add(other DBIStats) {
+	st.Added += other.Added
+	st.Updated += other.Updated
+	st.Deleted += other.Deleted
+	st.Skipped += other.Skipped
+	st.Unchanged += other.Unchanged
+	st.Dropped += other.Dropped
+	st.ChangedKeys = append(st.ChangedKeys, other.ChangedKeys...)
+	st.KeysTruncated = st.KeysTruncated || other.KeysTruncated
 }
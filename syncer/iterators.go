@@ -5,9 +5,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
@@ -61,6 +64,19 @@ type NativeIterator struct {
 	HeaderPaddingBlock   bool             // Extra padding block for testing
 	DeletedCutoff        header.Timestamp // Older deleted entries are considered stale
 
+	// Latency, if set, observes the time between the timestamp of every
+	// remote entry that changes the LMDB and ApplyTime, which is the
+	// end-to-end replication latency of that change. Loads use a
+	// latencyBuffer, so that only committed changes are observed.
+	Latency   prometheus.Observer
+	ApplyTime time.Time
+
+	// Stats counts the merge results
+	Stats events.DBIStats
+	// KeyLimit is the maximum number of changed keys to collect in the
+	// Stats, 0 to not collect any
+	KeyLimit int
+
 	current int
 	started bool
 	buf     []byte
@@ -98,10 +114,19 @@ func (it *NativeIterator) Merge(oldval []byte) (val []byte, err error) {
 		entryFlags := entry.MaskedFlags()
 		if entryFlags.IsDeleted() && header.Timestamp(entry.TimestampNano) < it.DeletedCutoff {
 			// Remove (effectively 'do not add', because it does not exist)
+			it.Stats.Dropped++
 			return nil, nil
 		}
 
 		// Add with header
+		if entryFlags.IsDeleted() {
+			// The deletion marker is stored, but the key was already absent
+			it.Stats.Unchanged++
+		} else {
+			it.Stats.Added++
+			it.keyChanged()
+			it.observeLatency(entry.TimestampNano)
+		}
 		return it.addHeader(
 			entryVal,
 			header.Timestamp(entry.TimestampNano),
@@ -122,23 +147,79 @@ func (it *NativeIterator) Merge(oldval []byte) (val []byte, err error) {
 	if newTS == 0 {
 		// Special handling for main to shadow copy that uses a default timestamp
 		if bytes.Equal(actualOldVal, entryVal) {
+			it.Stats.Unchanged++
 			return oldval, nil // do not update timestamp
 		}
 		newTS = it.DefaultTimestampNano
 	}
 	if newTS < oldTS {
 		// Current LMDB value has a higher timestamp, so keep that one
+		it.Stats.Skipped++
 		return oldval, nil
 	}
 	if newTS == oldTS && bytes.Compare(actualOldVal, entryVal) <= 0 {
 		// Same timestamp, lexicographic lower app value wins for deterministic values,
 		// so return the old value if the plain value was lower or equal.
+		if bytes.Equal(actualOldVal, entryVal) {
+			it.Stats.Unchanged++
+		} else {
+			it.Stats.Skipped++
+		}
 		return oldval, nil
 	}
 	// Update LMDB value
+	oldDeleted := h.Flags.IsDeleted()
+	newDeleted := entry.MaskedFlags().IsDeleted()
+	switch {
+	case newDeleted && oldDeleted:
+		// Newer deletion marker, but the key remains absent
+		it.Stats.Unchanged++
+		return it.addHeader(entryVal, newTS, entry.MaskedFlags(), false)
+	case newDeleted:
+		it.Stats.Deleted++
+	case oldDeleted:
+		it.Stats.Added++
+	default:
+		it.Stats.Updated++
+	}
+	it.keyChanged()
+	it.observeLatency(entry.TimestampNano)
 	return it.addHeader(entryVal, newTS, entry.MaskedFlags(), false)
 }
 
+// keyChanged collects the current key as changed, up to the KeyLimit
+func (it *NativeIterator) keyChanged() {
+	if it.KeyLimit == 0 {
+		return
+	}
+	if len(it.Stats.ChangedKeys) >= it.KeyLimit {
+		it.Stats.KeysTruncated = true
+		return
+	}
+	// The key may refer to snapshot memory that is reused
+	it.Stats.ChangedKeys = append(it.Stats.ChangedKeys, bytes.Clone(it.curKV.Key))
+}
+
+// observeLatency observes the replication latency of a remote entry with
+// the given timestamp.
+func (it *NativeIterator) observeLatency(tsNano uint64) {
+	observeLatency(it.Latency, it.ApplyTime, tsNano)
+}
+
+// observeLatency observes the time between the timestamp of an entry and
+// the time it was applied, if o is not nil. Entries without a timestamp are
+// local and ignored.
+func observeLatency(o prometheus.Observer, applyTime time.Time, tsNano uint64) {
+	if o == nil || tsNano == 0 {
+		return
+	}
+	dt := applyTime.Sub(header.Timestamp(tsNano).Time())
+	if dt < 0 {
+		dt = 0 // clock skew between instances
+	}
+	o.Observe(dt.Seconds())
+}
+
 func (it *NativeIterator) Clean(oldval []byte) (val []byte, err error) {
 	// Clean effectively instructs us to delete the entry
 	h, _, err := header.Parse(oldval)
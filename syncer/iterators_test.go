@@ -2,9 +2,11 @@ package syncer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,6 +24,8 @@ func TestNativeIterator_Merge(t *testing.T) {
 		OldVal        []byte
 		Expected      []byte
 		ExpectedError bool
+		Observed      bool // replication latency observed
+		Stats         events.DBIStats
 	}{
 		{
 			Name: "add-new-entry",
@@ -32,6 +36,8 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   nil,
 			Expected: makeVal(30, 0, "val"),
+			Observed: true,
+			Stats:    events.DBIStats{Added: 1},
 		},
 		{
 			Name: "add-new-entry-that-is-old",
@@ -42,6 +48,8 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   nil,
 			Expected: makeVal(5, 0, "val"),
+			Observed: true,
+			Stats:    events.DBIStats{Added: 1},
 		},
 		{
 			Name: "add-new-entry-default-ts",
@@ -52,6 +60,7 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   nil,
 			Expected: makeVal(42, 0, "val"),
+			Stats:    events.DBIStats{Added: 1},
 		},
 		{
 			Name: "db-retains-newer-entry",
@@ -62,6 +71,7 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   makeVal(40, 0, "newer"),
 			Expected: makeVal(40, 0, "newer"),
+			Stats:    events.DBIStats{Skipped: 1},
 		},
 		{
 			Name: "add-deleted-entry",
@@ -72,6 +82,7 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   nil,
 			Expected: makeVal(30, header.FlagDeleted, ""),
+			Stats:    events.DBIStats{Unchanged: 1}, // key was already absent
 		},
 		{
 			Name: "skip-stale-deleted-entry",
@@ -82,6 +93,7 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   nil,
 			Expected: nil,
+			Stats:    events.DBIStats{Dropped: 1},
 		},
 		{
 			Name: "conflict-lexicographic-lower",
@@ -92,6 +104,8 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   makeVal(30, 0, "bbb"),
 			Expected: makeVal(30, 0, "aaa"),
+			Observed: true,
+			Stats:    events.DBIStats{Updated: 1},
 		},
 		{
 			Name: "conflict-lexicographic-higher",
@@ -102,6 +116,7 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   makeVal(30, 0, "bbb"),
 			Expected: makeVal(30, 0, "bbb"),
+			Stats:    events.DBIStats{Skipped: 1},
 		},
 		{
 			Name: "same",
@@ -112,6 +127,42 @@ func TestNativeIterator_Merge(t *testing.T) {
 			},
 			OldVal:   makeVal(30, 0, "val"),
 			Expected: makeVal(30, 0, "val"),
+			Stats:    events.DBIStats{Unchanged: 1},
+		},
+		{
+			Name: "delete-existing-entry",
+			KV: snapshot.KV{
+				Value:         nil,
+				TimestampNano: 30,
+				Flags:         uint32(header.FlagDeleted),
+			},
+			OldVal:   makeVal(20, 0, "val"),
+			Expected: makeVal(30, header.FlagDeleted, ""),
+			Observed: true,
+			Stats:    events.DBIStats{Deleted: 1},
+		},
+		{
+			Name: "newer-deleted-entry",
+			KV: snapshot.KV{
+				Value:         nil,
+				TimestampNano: 30,
+				Flags:         uint32(header.FlagDeleted),
+			},
+			OldVal:   makeVal(20, header.FlagDeleted, ""),
+			Expected: makeVal(30, header.FlagDeleted, ""),
+			Stats:    events.DBIStats{Unchanged: 1}, // key remains absent
+		},
+		{
+			Name: "readd-deleted-entry",
+			KV: snapshot.KV{
+				Value:         []byte("val"),
+				TimestampNano: 30,
+				Flags:         0,
+			},
+			OldVal:   makeVal(20, header.FlagDeleted, ""),
+			Expected: makeVal(30, 0, "val"),
+			Observed: true,
+			Stats:    events.DBIStats{Added: 1},
 		},
 		{
 			Name: "corrupt-old-value",
@@ -127,11 +178,14 @@ func TestNativeIterator_Merge(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.Name, func(t *testing.T) {
+			var latencies observations
 			it := &NativeIterator{
 				DefaultTimestampNano: 42,  // used then the timestamp is zero
 				TxnID:                123, // current txnid
 				FormatVersion:        snapshot.CurrentFormatVersion,
 				DeletedCutoff:        10, // delete markers older than this are ignored
+				Latency:              &latencies,
+				ApplyTime:            time.Unix(1, 30),
 			}
 			tc.KV.Key = []byte("key")
 			it.curKV = tc.KV
@@ -146,7 +200,30 @@ func TestNativeIterator_Merge(t *testing.T) {
 				}
 			}
 			assert.Equal(t, tc.Expected, res)
+			if tc.Observed {
+				assert.Len(t, latencies, 1)
+			} else {
+				assert.Empty(t, latencies)
+			}
+			assert.Equal(t, tc.Stats, it.Stats)
 		})
 	}
 
 }
+
+// observations records observed values
+type observations []float64
+
+func (o *observations) Observe(v float64) {
+	*o = append(*o, v)
+}
+
+func TestObserveLatency(t *testing.T) {
+	var o observations
+	applyTime := time.Unix(100, 0)
+	observeLatency(&o, applyTime, uint64(time.Unix(98, 500_000_000).UnixNano()))
+	observeLatency(&o, applyTime, uint64(time.Unix(101, 0).UnixNano())) // clock skew
+	observeLatency(&o, applyTime, 0)                                    // local entry
+	observeLatency(nil, applyTime, 1)
+	assert.Equal(t, observations{1.5, 0}, o)
+}
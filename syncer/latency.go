@@ -0,0 +1,93 @@
+package syncer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyBuffer collects the replication latency observations of a load,
+// which are made inside the write transaction. They are only passed to the
+// histograms with Flush after the transaction was committed, so that aborted
+// and retried transactions do not record changes that were never applied.
+//
+// The observations are aggregated per histogram bucket, so that the memory
+// use does not depend on the number of changes.
+type latencyBuffer struct {
+	syncerName string
+	observers  map[latencyKey]*bufferedLatency
+}
+
+type latencyKey struct {
+	instance string
+	dbiName  string
+}
+
+func newLatencyBuffer(syncerName string) *latencyBuffer {
+	return &latencyBuffer{
+		syncerName: syncerName,
+		observers:  make(map[latencyKey]*bufferedLatency),
+	}
+}
+
+// Observer returns the observer for the replication latency of changes from
+// a remote instance to a DBI.
+func (b *latencyBuffer) Observer(instance, dbiName string) prometheus.Observer {
+	k := latencyKey{instance: instance, dbiName: dbiName}
+	o, exists := b.observers[k]
+	if !exists {
+		o = &bufferedLatency{
+			// One extra for the +Inf bucket
+			counts: make([]uint64, len(replicationLatencyBuckets)+1),
+			sums:   make([]float64, len(replicationLatencyBuckets)+1),
+		}
+		b.observers[k] = o
+	}
+	return o
+}
+
+// Flush passes all buffered observations to the histograms and empties the
+// buffer. This must only be called after the transaction was committed.
+func (b *latencyBuffer) Flush() {
+	for k, o := range b.observers {
+		h := metricReplicationLatency.WithLabelValues(b.syncerName, k.instance, k.dbiName)
+		o.flushTo(h)
+	}
+	clear(b.observers)
+}
+
+// bufferedLatency counts the observations and their sum per histogram bucket
+type bufferedLatency struct {
+	counts []uint64
+	sums   []float64
+}
+
+func (o *bufferedLatency) Observe(v float64) {
+	// Same bucket as the histogram: the first upper bound >= v
+	i := sort.SearchFloat64s(replicationLatencyBuckets, v)
+	o.counts[i]++
+	o.sums[i] += v
+}
+
+// flushTo observes the average of every bucket as many times as the bucket
+// was observed. This results in the same bucket counts, count and sum as
+// observing the original values.
+func (o *bufferedLatency) flushTo(h prometheus.Observer) {
+	for i, n := range o.counts {
+		if n == 0 {
+			continue
+		}
+		avg := o.sums[i] / float64(n)
+		// Keep the average in the bucket despite rounding errors
+		if i < len(replicationLatencyBuckets) {
+			avg = math.Min(avg, replicationLatencyBuckets[i])
+		}
+		if i > 0 && avg <= replicationLatencyBuckets[i-1] {
+			avg = math.Nextafter(replicationLatencyBuckets[i-1], math.Inf(1))
+		}
+		for j := uint64(0); j < n; j++ {
+			h.Observe(avg)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package syncer
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyBuffer(t *testing.T) {
+	values := []float64{0, 0.1, 0.5, 0.7, 1.5, 1.6, 1.7, 45, 1e7}
+
+	histogram := func(o prometheus.Observer) *dto.Histogram {
+		var m dto.Metric
+		require.NoError(t, o.(prometheus.Histogram).Write(&m))
+		return m.Histogram
+	}
+
+	direct := metricReplicationLatency.WithLabelValues("latencybuf", "direct", "foo")
+	for _, v := range values {
+		direct.Observe(v)
+	}
+
+	buf := newLatencyBuffer("latencybuf")
+	o := buf.Observer("buffered", "foo")
+	for _, v := range values {
+		o.Observe(v)
+	}
+	buffered := metricReplicationLatency.WithLabelValues("latencybuf", "buffered", "foo")
+	assert.Equal(t, uint64(0), histogram(buffered).GetSampleCount(), "not flushed yet")
+
+	buf.Flush()
+	want := histogram(direct)
+	got := histogram(buffered)
+	assert.Equal(t, want.GetSampleCount(), got.GetSampleCount())
+	assert.InDelta(t, want.GetSampleSum(), got.GetSampleSum(), 1e-6)
+	require.Equal(t, len(want.Bucket), len(got.Bucket))
+	for i := range want.Bucket {
+		assert.Equal(t, want.Bucket[i].GetCumulativeCount(), got.Bucket[i].GetCumulativeCount(),
+			"bucket le=%v", want.Bucket[i].GetUpperBound())
+	}
+
+	// A flush empties the buffer
+	buf.Flush()
+	assert.Equal(t, want.GetSampleCount(), histogram(buffered).GetSampleCount())
+}
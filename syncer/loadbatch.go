@@ -0,0 +1,390 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/lmdbenv/strategy"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/tracing"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/sirupsen/logrus"
+)
+
+// InstanceUpdate is a remote update to load, together with the instance it
+// came from.
+type InstanceUpdate struct {
+	Instance string
+	Update   snapshot.Update
+}
+
+// mergeIterator is a k-way merge of several NativeIterators for the same DBI.
+// All snapshot values for a key are merged with the LMDB value in a single
+// Merge call, so every key is only written once.
+//
+// Snapshot keys are sorted, but this is not required for correctness: if a
+// snapshot DBI is not sorted, a key may be returned more than once, which
+// the Update strategy handles like separate loads would.
+type mergeIterator struct {
+	its  []*NativeIterator
+	keys [][]byte // current key of every iterator
+	done []bool   // iterator returned io.EOF
+	cmp  func(a, b []byte) int
+
+	started bool
+	current []int // iterators positioned at the current key
+}
+
+func newMergeIterator(its []*NativeIterator, dbiFlags uint) *mergeIterator {
+	return &mergeIterator{
+		its:  its,
+		keys: make([][]byte, len(its)),
+		done: make([]bool, len(its)),
+		cmp:  strategy.KeyCmpFunc(dbiFlags),
+	}
+}
+
+func (m *mergeIterator) advance(i int) error {
+	key, err := m.its[i].Next()
+	if err == io.EOF {
+		m.done[i] = true
+		m.keys[i] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.keys[i] = key
+	return nil
+}
+
+func (m *mergeIterator) Next() (key []byte, err error) {
+	if !m.started {
+		m.started = true
+		for i := range m.its {
+			if err := m.advance(i); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, i := range m.current {
+			if err := m.advance(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The number of iterators is small, so a linear scan is fine here
+	m.current = m.current[:0]
+	for i, k := range m.keys {
+		if m.done[i] {
+			continue
+		}
+		if len(m.current) == 0 {
+			key = k
+			m.current = append(m.current, i)
+			continue
+		}
+		switch c := m.cmp(k, key); {
+		case c < 0:
+			key = k
+			m.current = append(m.current[:0], i)
+		case c == 0:
+			m.current = append(m.current, i)
+		}
+	}
+	if len(m.current) == 0 {
+		return nil, io.EOF
+	}
+	return key, nil
+}
+
+// Merge merges the values of all iterators at the current key in turn, which
+// gives the same result as loading the snapshots one by one.
+func (m *mergeIterator) Merge(oldval []byte) (val []byte, err error) {
+	val = oldval
+	for _, i := range m.current {
+		val, err = m.its[i].Merge(val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+func (m *mergeIterator) Clean(oldval []byte) (val []byte, err error) {
+	return m.its[m.current[0]].Clean(oldval)
+}
+
+// loadBatchSize returns the maximum number of updates to load in one batch
+func (s *Syncer) loadBatchSize() int {
+	if s.lc.LoadBatchSize < 1 || s.lc.LoadLockDuration > 0 {
+		return 1
+	}
+	return s.lc.LoadBatchSize
+}
+
+// batchDBI is a snapshot DBI to merge as part of a batch
+type batchDBI struct {
+	index    int // of the update in the batch
+	instance string
+	snap     *snapshot.Snapshot
+	dbiMsg   *snapshot.DBI
+}
+
+// LoadBatch loads several remote updates in a single write transaction,
+// which is cheaper than calling LoadOnce for every update, because the write
+// lock is only acquired once and the shadow DBIs are only synced once.
+// DBIs without a transform are merged with a single k-way merge pass over
+// all the updates.
+//
+// A batch of a single update is loaded with LoadOnce.
+func (s *Syncer) LoadBatch(ctx context.Context, env *lmdb.Env, batch []InstanceUpdate, lastTxnID header.TxnID) (txnID header.TxnID, localChanged bool, err error) {
+	if len(batch) == 1 {
+		return s.LoadOnce(ctx, env, batch[0].Instance, batch[0].Update, lastTxnID)
+	}
+
+	var compressedSize datasize.ByteSize
+	instances := make([]string, 0, len(batch))
+	names := make([]string, 0, len(batch))
+	for _, iu := range batch {
+		instances = append(instances, iu.Instance)
+		names = append(names, iu.Update.NameInfo.FullName)
+		compressedSize += iu.Update.BlobSize
+	}
+	ctx, span := tracing.Start(ctx, "LoadBatch",
+		tracing.AttrDB.String(s.name),
+		tracing.AttrInstance.StringSlice(instances),
+		tracing.AttrSnapshotName.StringSlice(names),
+		tracing.AttrSnapshotSize.Int64(int64(compressedSize.Bytes())))
+	defer func() {
+		span.SetAttributes(tracing.AttrTxnID.Int64(int64(txnID)))
+		tracing.End(span, err)
+	}()
+
+	t0 := time.Now() // for performance measurements
+	schemaTracksChanges := s.lc.SchemaTracksChanges
+	deletedCutoff := s.deletedCutoff(t0)
+
+	// Collect the DBIs of all updates by name, in the order we first see them.
+	// Everything is validated before we acquire the write lock.
+	var dbiNames []string
+	dbis := make(map[string][]batchDBI)
+	for i, iu := range batch {
+		snap := iu.Update.Snapshot
+		if s.lc.SkipUnchangedDBIs {
+			snap = s.skipUnchangedDBIs(iu.Instance, snap)
+			batch[i].Update.Snapshot = snap
+		}
+		l := s.l.WithField("snapshot_instance", iu.Instance)
+		databases, err := s.rewriteDatabases(snap, l)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, dbiMsg := range databases {
+			dbiName := dbiMsg.Name()
+			if strings.HasPrefix(dbiName, SyncDBIPrefix) {
+				l.WithField("dbi", dbiName).Warn("Remote snapshot contains private DBI, ignoring")
+				continue // skip our own special dbs
+			}
+			if err := s.checkLoadDBI(snap, dbiMsg); err != nil {
+				return 0, false, err
+			}
+			if _, exists := dbis[dbiName]; !exists {
+				dbiNames = append(dbiNames, dbiName)
+			}
+			dbis[dbiName] = append(dbis[dbiName], batchDBI{
+				index:    i,
+				instance: iu.Instance,
+				snap:     snap,
+				dbiMsg:   dbiMsg,
+			})
+		}
+	}
+
+	stats := make([]map[string]events.DBIStats, len(batch))
+	for i := range stats {
+		stats[i] = make(map[string]events.DBIStats)
+	}
+
+	var tTxnAcquire time.Time
+	var tShadow1Start time.Time
+	var tShadow1End time.Time
+	var tShadow2Start time.Time
+	var tShadow2End time.Time
+	var tLoadStart time.Time
+	var tLoadEnd time.Time
+
+	latencies := newLatencyBuffer(s.name)
+	err = lmdbenv.Update(env, func(txn *lmdb.Txn) error {
+		ts := time.Now()
+		tTxnAcquire = ts
+		tsNano := header.TimestampFromTime(ts)
+		txnID = header.TxnID(txn.ID())
+
+		// See LoadOnce
+		localChanged = lastTxnID < (txnID - 1)
+
+		l := s.l.WithFields(logrus.Fields{
+			"txnID":        txnID,
+			"lastTxnID":    lastTxnID,
+			"updates":      len(batch),
+			"localChanged": localChanged,
+		})
+		l.Debug("Started batch load")
+		span.AddEvent("txn acquired")
+
+		// First update the shadow dbs to reflect the latest local state
+		tShadow1Start = time.Now()
+		if !schemaTracksChanges && localChanged {
+			err := s.mainToShadow(ctx, txn, tsNano)
+			if err != nil {
+				return err
+			}
+		}
+		tShadow1End = time.Now()
+
+		// Apply snapshots
+		tLoadStart = time.Now()
+		for _, dbiName := range dbiNames {
+			ld := l.WithField("dbi", dbiName)
+			ld.Debug("Starting merge of snapshots into DBI")
+			if err := s.mergeBatchDBI(txn, dbis[dbiName], deletedCutoff, ts, latencies, stats, ld); err != nil {
+				return fmt.Errorf("dbi %s: %w", dbiName, err)
+			}
+			ld.Debug("Merge successful")
+
+			if utils.IsCanceled(ctx) {
+				return context.Canceled
+			}
+		}
+		tLoadEnd = time.Now()
+		span.AddEvent("merged")
+
+		// Apply state of shadow dbs to main data
+		tShadow2Start = time.Now()
+		if !schemaTracksChanges {
+			err := s.shadowToMain(ctx, txn)
+			if err != nil {
+				return err
+			}
+		}
+		tShadow2End = time.Now()
+
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	tLoaded := time.Now()
+	latencies.Flush() // only after the commit
+
+	// If no actual changes were made, LMDB will not record the transaction
+	// and reuse the ID the next time, so we need to adjust the txnID we return.
+	info, err := lmdbenv.Info(env)
+	if err != nil {
+		return 0, false, err
+	}
+	if header.TxnID(info.LastTxnID) < txnID {
+		// Transaction was empty, no changes
+		s.l.WithField("prevTxnID", txnID).WithField("txnID", info.LastTxnID).
+			Debug("Adjusting TxnID (no changes)")
+		txnID = header.TxnID(info.LastTxnID)
+	}
+
+	l := s.l.WithFields(logrus.Fields{
+		"time_total":            utils.TimeDiff(tLoaded, t0),
+		"time_write_lock":       utils.TimeDiff(tLoaded, tTxnAcquire),
+		"txnID":                 txnID,
+		"updates":               len(batch),
+		"snapshot_instances":    strings.Join(instances, ","),
+		"compressed_size_bytes": compressedSize.Bytes(),
+	})
+	l.Info("Loaded batch of remote updates")
+
+	l.WithFields(logrus.Fields{
+		"time_acquire":      utils.TimeDiff(tTxnAcquire, t0),
+		"time_copy_shadow1": utils.TimeDiff(tShadow1End, tShadow1Start),
+		"time_copy_shadow2": utils.TimeDiff(tShadow2End, tShadow2Start),
+		"time_load":         utils.TimeDiff(tLoadEnd, tLoadStart),
+	}).Debug("Loaded batch of remote updates (with timings)")
+
+	for i, iu := range batch {
+		s.loadDone(iu.Instance, iu.Update, txnID, localChanged, stats[i])
+	}
+
+	return txnID, localChanged, nil
+}
+
+// mergeBatchDBI merges the snapshot DBIs with the same name from a batch.
+// DBIs without a transform are merged in a single pass, others are merged
+// one after the other. The applyTime is used for the replication latency,
+// which is buffered in latencies until the transaction is committed.
+// The merge stats are added to the stats of the updates in the batch.
+func (s *Syncer) mergeBatchDBI(txn *lmdb.Txn, items []batchDBI, deletedCutoff header.Timestamp, applyTime time.Time, latencies *latencyBuffer, stats []map[string]events.DBIStats, ld logrus.FieldLogger) error {
+	txnID := header.TxnID(txn.ID())
+	var targetDBI lmdb.DBI
+	its := make([]*NativeIterator, 0, len(items))
+	kway := true
+	for _, item := range items {
+		var err error
+		targetDBI, err = s.openLoadTargetDBI(txn, item.snap, item.dbiMsg, ld)
+		if err != nil {
+			return err
+		}
+		it, err := NewNativeIterator(
+			item.snap.FormatVersion,
+			item.snap.CompatVersion,
+			item.dbiMsg,
+			0, // no default timestamp
+			txnID,
+			deletedCutoff,
+		)
+		if err != nil {
+			return fmt.Errorf("create native iterator: %w", err)
+		}
+		if s.lc.HeaderExtraPaddingBlock {
+			it.HeaderPaddingBlock = true
+		}
+		it.Latency = latencies.Observer(item.instance, item.dbiMsg.Name())
+		it.ApplyTime = applyTime
+		it.KeyLimit = s.changedKeyLimit()
+		its = append(its, it)
+		if item.dbiMsg.Transform() != snapshot.TransformNone {
+			kway = false
+		}
+	}
+
+	addStats := func() {
+		for i, it := range its {
+			st := stats[items[i].index][items[i].dbiMsg.Name()]
+			st.Add(it.Stats)
+			stats[items[i].index][items[i].dbiMsg.Name()] = st
+		}
+	}
+
+	if !kway {
+		for i, it := range its {
+			if err := s.mergeLoadDBI(txn, targetDBI, it, items[i].dbiMsg.Transform(), nil); err != nil {
+				return err
+			}
+		}
+		addStats()
+		return nil
+	}
+
+	mit := newMergeIterator(its, uint(items[0].dbiMsg.Flags()))
+	if err := strategy.Update(txn, targetDBI, mit); err != nil {
+		return err
+	}
+	addStats()
+	return nil
+}
@@ -0,0 +1,136 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_LoadBatch(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+	ts3 := testTS(3)
+
+	newSnap := func(dbis ...*snapshot.DBI) snapshot.Update {
+		return snapshot.Update{Snapshot: &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.CompatFormatVersion,
+			Databases:     dbis,
+		}}
+	}
+	newDBI := func(name string, kvs ...snapshot.KV) *snapshot.DBI {
+		dbiMsg := snapshot.NewDBI()
+		dbiMsg.SetName(name)
+		for _, kv := range kvs {
+			dbiMsg.Append(kv)
+		}
+		return dbiMsg
+	}
+	newBatch := func() []InstanceUpdate {
+		return []InstanceUpdate{
+			{Instance: "one", Update: newSnap(
+				newDBI("foo",
+					snapshot.KV{Key: b("a"), Value: b("1"), TimestampNano: uint64(ts1)},
+					snapshot.KV{Key: b("b"), Value: b("1"), TimestampNano: uint64(ts2)},
+					snapshot.KV{Key: b("c"), Value: b("1"), TimestampNano: uint64(ts1)},
+				),
+			)},
+			{Instance: "two", Update: newSnap(
+				newDBI("foo",
+					snapshot.KV{Key: b("a"), Value: b("2"), TimestampNano: uint64(ts2)},
+					snapshot.KV{Key: b("b"), Value: b("2"), TimestampNano: uint64(ts1)},
+					// Same timestamp, the lower value wins
+					snapshot.KV{Key: b("c"), Value: b("0"), TimestampNano: uint64(ts1)},
+				),
+			)},
+			{Instance: "three", Update: newSnap(
+				newDBI("bar",
+					snapshot.KV{Key: b("x"), Value: b("3"), TimestampNano: uint64(ts1)},
+				),
+				newDBI("foo",
+					snapshot.KV{Key: b("b"), Flags: uint32(header.FlagDeleted), TimestampNano: uint64(ts3)},
+					snapshot.KV{Key: b("d"), Value: b("3"), TimestampNano: uint64(ts3)},
+				),
+			)},
+		}
+	}
+
+	t.Run("native", func(t *testing.T) {
+		err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+			ctx := context.Background()
+			lc := config.LMDB{SchemaTracksChanges: true, LoadBatchSize: 10}
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+
+			txnID, localChanged, err := s.LoadBatch(ctx, env, newBatch(), 0)
+			require.NoError(t, err)
+			assert.False(t, localChanged)
+			assert.Equal(t, header.TxnID(1), txnID) // a single transaction
+
+			err = env.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				require.NoError(t, err)
+				vals, err := lmdbenv.ReadDBIString(txn, dbi)
+				require.NoError(t, err)
+				assert.Equal(t, []lmdbenv.KVString{
+					{Key: "a", Val: h(ts2, 1, 0) + "2"},
+					{Key: "b", Val: h(ts3, 1, header.FlagDeleted)},
+					{Key: "c", Val: h(ts1, 1, 0) + "0"},
+					{Key: "d", Val: h(ts3, 1, 0) + "3"},
+				}, vals)
+
+				dbi, err = txn.OpenDBI("bar", 0)
+				require.NoError(t, err)
+				vals, err = lmdbenv.ReadDBIString(txn, dbi)
+				require.NoError(t, err)
+				assert.Equal(t, []lmdbenv.KVString{
+					{Key: "x", Val: h(ts1, 1, 0) + "3"},
+				}, vals)
+				return nil
+			})
+			require.NoError(t, err)
+
+			// Loading the same batch again does not change anything
+			txnID2, _, err := s.LoadBatch(ctx, env, newBatch(), txnID)
+			require.NoError(t, err)
+			assert.Equal(t, txnID, txnID2)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("shadow", func(t *testing.T) {
+		err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+			ctx := context.Background()
+			lc := config.LMDB{LoadBatchSize: 10}
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+
+			_, _, err = s.LoadBatch(ctx, env, newBatch(), 0)
+			require.NoError(t, err)
+
+			err = env.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				require.NoError(t, err)
+				vals, err := lmdbenv.ReadDBIString(txn, dbi)
+				require.NoError(t, err)
+				assert.Equal(t, []lmdbenv.KVString{
+					{Key: "a", Val: "2"},
+					{Key: "c", Val: "0"},
+					{Key: "d", Val: "3"},
+				}, vals)
+				return nil
+			})
+			require.NoError(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,337 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/lmdbenv/limitscanner"
+	"github.com/PowerDNS/lightningstream/lmdbenv/strategy"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/sirupsen/logrus"
+)
+
+// loadLimit limits the time spent in a single write transaction of a
+// chunked load. Like the limitscanner, it only checks the time every
+// LimitDurationCheckEveryDefault entries.
+type loadLimit struct {
+	deadline time.Time
+	count    int
+	reached  bool
+}
+
+// next returns false if the limit was reached and no more entries must be
+// processed in this transaction.
+func (l *loadLimit) next() bool {
+	if l.reached {
+		return false
+	}
+	checkEvery := limitscanner.LimitDurationCheckEveryDefault
+	if l.count > 0 && l.count%checkEvery == 0 && time.Now().After(l.deadline) {
+		l.reached = true
+		return false
+	}
+	l.count++
+	return true
+}
+
+// limitIterator wraps a strategy.Iterator to stop when the loadLimit is
+// reached. The wrapped iterator is not advanced in that case, so it can be
+// resumed in the next transaction.
+type limitIterator struct {
+	strategy.Iterator
+	limit *loadLimit
+}
+
+func (it *limitIterator) Next() (key []byte, err error) {
+	if !it.limit.next() {
+		return nil, io.EOF
+	}
+	return it.Iterator.Next()
+}
+
+// limitDupIterator is the limitIterator for a strategy.DupIterator
+type limitDupIterator struct {
+	strategy.DupIterator
+	limit *loadLimit
+}
+
+func (it *limitDupIterator) Next() (key []byte, err error) {
+	if !it.limit.next() {
+		return nil, io.EOF
+	}
+	return it.DupIterator.Next()
+}
+
+// loadChunked is the LoadOnce implementation for the load_lock_duration
+// option. It applies the snapshot in several write transactions that each
+// take about LoadLockDuration.
+//
+// Every entry is merged independently, so application writes between the
+// transactions are merged like any other local change. All DBIs are
+// validated before the first transaction, so that an invalid snapshot is
+// never partially applied.
+//
+// With shadow DBIs, the entries are merged one key at a time by
+// mergeShadowChunk, which keeps the main and shadow entries of every merged
+// key in sync, so that no transaction needs to scan the whole DBIs.
+//
+// The returned txnID is the last transaction of the load, and localChanged
+// is true if any other transaction was committed before or during the load.
+func (s *Syncer) loadChunked(ctx context.Context, env *lmdb.Env, instance string, update snapshot.Update, databases []*snapshot.DBI, lastTxnID header.TxnID, t0 time.Time) (txnID header.TxnID, localChanged bool, err error) {
+	snap := update.Snapshot
+	deletedCutoff := s.deletedCutoff(t0)
+	schemaTracksChanges := s.lc.SchemaTracksChanges
+	l := s.l.WithFields(logrus.Fields{
+		"lastTxnID":         lastTxnID,
+		"snapshot_instance": instance,
+		"timestamp":         snapshot.NameTimestampFromNano(header.Timestamp(snap.Meta.TimestampNano)),
+	})
+
+	var dbis []*snapshot.DBI
+	for _, dbiMsg := range databases {
+		if strings.HasPrefix(dbiMsg.Name(), SyncDBIPrefix) {
+			l.WithField("dbi", dbiMsg.Name()).Warn("Remote snapshot contains private DBI, ignoring")
+			continue // skip our own special dbs
+		}
+		if err := s.checkLoadDBI(snap, dbiMsg); err != nil {
+			return 0, false, err
+		}
+		dbis = append(dbis, dbiMsg)
+	}
+
+	releaseDuration := s.lc.LoadReleaseDuration
+	if releaseDuration == 0 {
+		releaseDuration = s.lc.LoadLockDuration
+	}
+
+	var timeWriteLock time.Duration
+	var nTxn int
+	prevTxnID := lastTxnID
+	var current int        // index of the DBI being loaded
+	var it *NativeIterator // iterator of the DBI being loaded, if started
+	stats := make(map[string]events.DBIStats, len(dbis))
+	for {
+		done := false
+		var tTxnAcquire time.Time
+		latencies := newLatencyBuffer(s.name)
+		err = lmdbenv.Update(env, func(txn *lmdb.Txn) error {
+			tTxnAcquire = time.Now()
+			txnID = header.TxnID(txn.ID())
+
+			// Any other transaction committed since our last one is a local
+			// change, see LoadOnce.
+			if prevTxnID < (txnID - 1) {
+				localChanged = true
+			}
+
+			limit := &loadLimit{deadline: tTxnAcquire.Add(s.lc.LoadLockDuration)}
+			for ; current < len(dbis); current++ {
+				dbiMsg := dbis[current]
+				ld := l.WithField("dbi", dbiMsg.Name()).WithField("txnID", txnID)
+
+				targetDBI, err := s.openLoadTargetDBI(txn, snap, dbiMsg, ld)
+				if err != nil {
+					return err
+				}
+				if it == nil {
+					ld.Debug("Starting merge of snapshot into DBI")
+					it, err = NewNativeIterator(
+						snap.FormatVersion,
+						snap.CompatVersion,
+						dbiMsg,
+						0, // no default timestamp
+						txnID,
+						deletedCutoff,
+					)
+					if err != nil {
+						return err
+					}
+					if s.lc.HeaderExtraPaddingBlock {
+						it.HeaderPaddingBlock = true
+					}
+				}
+				it.TxnID = txnID // resumed in a new transaction
+				it.Latency = latencies.Observer(instance, dbiMsg.Name())
+				it.ApplyTime = tTxnAcquire
+				it.KeyLimit = s.changedKeyLimit()
+
+				if schemaTracksChanges {
+					err = s.mergeLoadDBI(txn, targetDBI, it, dbiMsg.Transform(), limit)
+				} else {
+					tsNano := header.TimestampFromTime(tTxnAcquire)
+					err = mergeShadowChunk(txn, dbiMsg.Name(), targetDBI, it, limit, tsNano)
+				}
+				if err != nil {
+					return err
+				}
+				if limit.reached {
+					ld.Debug("Load lock duration reached, continuing after pause")
+					break
+				}
+				stats[dbiMsg.Name()] = it.Stats
+				it = nil
+				ld.Debug("Merge successful")
+
+				if utils.IsCanceled(ctx) {
+					return context.Canceled
+				}
+			}
+			done = current == len(dbis)
+			return nil
+		})
+		timeWriteLock += time.Since(tTxnAcquire)
+		nTxn++
+		if err != nil {
+			return 0, false, err
+		}
+		latencies.Flush() // only the changes of the committed transaction
+
+		// If no actual changes were made, LMDB will not record the transaction
+		// and reuse the ID the next time.
+		info, err := lmdbenv.Info(env)
+		if err != nil {
+			return 0, false, err
+		}
+		if header.TxnID(info.LastTxnID) < txnID {
+			txnID = header.TxnID(info.LastTxnID)
+		}
+		prevTxnID = txnID
+
+		if done {
+			break
+		}
+		if err := utils.SleepContext(ctx, releaseDuration); err != nil {
+			return 0, false, err
+		}
+	}
+	tLoaded := time.Now()
+
+	ts := snapshot.NameTimestampFromNano(header.Timestamp(snap.Meta.TimestampNano))
+	s.l.WithFields(logrus.Fields{
+		"time_total":            utils.TimeDiff(tLoaded, t0),
+		"time_write_lock":       timeWriteLock.Round(time.Millisecond),
+		"transactions":          nTxn,
+		"txnID":                 txnID,
+		"localChanged":          localChanged,
+		"snapshot_instance":     instance,
+		"shorthash":             snapshot.ShortHash(snap.Meta.InstanceID, ts),
+		"timestamp":             ts,
+		"kind":                  update.NameInfo.Kind,
+		"extra":                 update.NameInfo.Extra.String(),
+		"compressed_size_bytes": update.BlobSize.Bytes(),
+	}).Info("Loaded remote update")
+
+	s.loadDone(instance, update, txnID, localChanged, stats)
+
+	return txnID, localChanged, nil
+}
+
+// mergeShadowChunk merges a snapshot DBI into a shadow DBI and its main DBI
+// one key at a time, until the iterator or the limit is done. For every key:
+//   - Any local change of the main entry is first recorded in the shadow
+//     entry with the given timestamp, like mainToShadow does.
+//   - The snapshot entry is merged into the shadow entry.
+//   - The result is applied to the main entry, like shadowToMain does.
+//
+// The main and shadow entries of every merged key are thus in sync after the
+// transaction. Local changes of other keys are left for the next mainToShadow.
+// DupSort DBIs are not supported.
+func mergeShadowChunk(txn *lmdb.Txn, dbiName string, shadowDBI lmdb.DBI, it *NativeIterator, limit *loadLimit, tsNano header.Timestamp) error {
+	mainDBI, err := txn.OpenDBI(dbiName, 0)
+	if err != nil {
+		return err
+	}
+	flags, err := txn.Flags(mainDBI)
+	if err != nil {
+		return err
+	}
+	if flags&lmdb.DupSort > 0 {
+		return fmt.Errorf("dbi %s: load_lock_duration does not support DupSort DBIs with shadow DBIs", dbiName)
+	}
+
+	// Records local changes, like in mainToShadow
+	local := &NativeIterator{
+		DefaultTimestampNano: tsNano,
+		TxnID:                it.TxnID,
+		FormatVersion:        snapshot.CurrentFormatVersion,
+	}
+	get := func(dbi lmdb.DBI, key []byte) (val []byte, found bool, err error) {
+		val, err = txn.Get(dbi, key)
+		if lmdb.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return val, err == nil, err
+	}
+	lit := &limitIterator{Iterator: it, limit: limit}
+	for {
+		key, err := lit.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mainVal, mainFound, err := get(mainDBI, key)
+		if err != nil {
+			return fmt.Errorf("get main: %w", err)
+		}
+		shadowVal, shadowFound, err := get(shadowDBI, key)
+		if err != nil {
+			return fmt.Errorf("get shadow: %w", err)
+		}
+
+		// Local change
+		current := shadowVal
+		local.curKV = snapshot.KV{Key: key, Value: mainVal}
+		switch {
+		case mainFound:
+			current, err = local.Merge(shadowVal)
+		case shadowFound:
+			current, err = local.Clean(shadowVal)
+		}
+		if err != nil {
+			return fmt.Errorf("merge local: %w", err)
+		}
+
+		// Remote change
+		val, err := it.Merge(current)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if len(val) == 0 {
+			continue // nothing to add
+		}
+		if !bytes.Equal(val, shadowVal) {
+			if err := txn.Put(shadowDBI, key, val, 0); err != nil {
+				return fmt.Errorf("put shadow: %w", err)
+			}
+		}
+
+		// Apply to main
+		h, appVal, err := header.Parse(val)
+		if err != nil {
+			return err
+		}
+		switch {
+		case h.Flags.IsDeleted():
+			if mainFound {
+				if err := txn.Del(mainDBI, key, nil); err != nil {
+					return fmt.Errorf("del main: %w", err)
+				}
+			}
+		case !mainFound || !bytes.Equal(appVal, mainVal):
+			if err := txn.Put(mainDBI, key, appVal, 0); err != nil {
+				return fmt.Errorf("put main: %w", err)
+			}
+		}
+	}
+}
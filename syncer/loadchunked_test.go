@@ -0,0 +1,213 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_LoadOnce_chunked(t *testing.T) {
+	ts := testTS(1)
+
+	foo := snapshot.NewDBI()
+	foo.SetName("foo")
+	for i := 0; i < 3500; i++ {
+		foo.Append(snapshot.KV{Key: fmt.Appendf(nil, "key-%05d", i), Value: b("val"), TimestampNano: uint64(ts)})
+	}
+	bar := snapshot.NewDBI()
+	bar.SetName("bar")
+	bar.Append(snapshot.KV{Key: b("a"), Value: b("x"), TimestampNano: uint64(ts)})
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{foo, bar},
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{
+			SchemaTracksChanges: true,
+			// Every limit check fails, so every transaction handles the
+			// minimum of 1000 entries.
+			LoadLockDuration:    time.Nanosecond,
+			LoadReleaseDuration: time.Millisecond,
+		}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			return txn.Put(dbi, b("key-00000"), b(h(testTS(2), 1, 0)+"newer"), 0)
+		})
+		require.NoError(t, err)
+
+		txnID, localChanged, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		assert.False(t, localChanged)
+		// 3500 entries in foo, and bar in the last transaction
+		assert.Equal(t, header.TxnID(5), txnID)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			require.Len(t, vals, 3500)
+			assert.Equal(t, lmdbenv.KVString{Key: "key-00000", Val: h(testTS(2), 1, 0) + "newer"}, vals[0])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-00999", Val: h(ts, 2, 0) + "val"}, vals[999])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-01000", Val: h(ts, 3, 0) + "val"}, vals[1000])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-03499", Val: h(ts, 5, 0) + "val"}, vals[3499])
+
+			dbi, err = txn.OpenDBI("bar", 0)
+			require.NoError(t, err)
+			vals, err = lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{{Key: "a", Val: h(ts, 5, 0) + "x"}}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Loading it again does not change anything, and detects local
+		// changes since the last snapshot.
+		txnID2, localChanged, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		assert.True(t, localChanged)
+		assert.Equal(t, txnID, txnID2)
+
+		// An invalid snapshot is rejected before anything is applied
+		bad := snapshot.NewDBI()
+		bad.SetName("bad")
+		bad.SetTransform(snapshot.TransformDupSortHackV1)
+		snap.Databases = append(snap.Databases, bad)
+		_, _, err = s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, txnID)
+		assert.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_LoadOnce_chunked_shadow(t *testing.T) {
+	ts := testTS(1)
+
+	foo := snapshot.NewDBI()
+	foo.SetName("foo")
+	for i := 0; i < 2500; i++ {
+		foo.Append(snapshot.KV{Key: fmt.Appendf(nil, "key-%05d", i), Value: b("val"), TimestampNano: uint64(ts)})
+	}
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{foo},
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{
+			// Every limit check fails, so every transaction handles the
+			// minimum of 1000 entries.
+			LoadLockDuration:    time.Nanosecond,
+			LoadReleaseDuration: 200 * time.Millisecond,
+		}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		// The application writes during the pause after the first
+		// transaction
+		written := make(chan error, 1)
+		go func() {
+			for {
+				info, err := lmdbenv.Info(env)
+				if err != nil {
+					written <- err
+					return
+				}
+				if info.LastTxnID >= 1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			written <- env.Update(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				if err != nil {
+					return err
+				}
+				if err := txn.Put(dbi, b("key-00000"), b("local"), 0); err != nil {
+					return err
+				}
+				// Merged in a later transaction
+				if err := txn.Put(dbi, b("key-02000"), b("later"), 0); err != nil {
+					return err
+				}
+				return txn.Put(dbi, b("local"), b("new"), 0)
+			})
+		}()
+
+		txnID, localChanged, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 0)
+		require.NoError(t, err)
+		require.NoError(t, <-written)
+		assert.True(t, localChanged)
+		// 3 load transactions and the application write
+		assert.Equal(t, header.TxnID(4), txnID)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			require.Len(t, vals, 2501)
+			assert.Equal(t, lmdbenv.KVString{Key: "key-00000", Val: "local"}, vals[0])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-02000", Val: "later"}, vals[2000])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-02499", Val: "val"}, vals[2499])
+			assert.Equal(t, lmdbenv.KVString{Key: "local", Val: "new"}, vals[2500])
+
+			// The shadow DBI has the merged entries. The local changes of
+			// keys that were not merged after the write are left for the
+			// next shadow sync.
+			dbi, err = txn.OpenDBI(SyncDBIShadowPrefix+"foo", 0)
+			require.NoError(t, err)
+			vals, err = lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			require.Len(t, vals, 2500)
+			assert.Equal(t, lmdbenv.KVString{Key: "key-00000", Val: h(ts, 1, 0) + "val"}, vals[0])
+			// The newer local change of a merged key was recorded first
+			hdr, val, err := header.Parse([]byte(vals[2000].Val))
+			require.NoError(t, err)
+			assert.Equal(t, "later", string(val))
+			assert.Greater(t, hdr.Timestamp, ts)
+			assert.Equal(t, lmdbenv.KVString{Key: "key-02499", Val: h(ts, 4, 0) + "val"}, vals[2499])
+			return nil
+		})
+		require.NoError(t, err)
+
+		// The next shadow sync records the local changes, and no merged
+		// entries are mistaken for local changes
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return s.mainToShadow(ctx, txn, testTS(3))
+		})
+		require.NoError(t, err)
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI(SyncDBIShadowPrefix+"foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			require.Len(t, vals, 2501)
+			assert.Equal(t, lmdbenv.KVString{Key: "key-00000", Val: h(testTS(3), 5, 0) + "local"}, vals[0])
+			assert.Equal(t, lmdbenv.KVString{Key: "key-02499", Val: h(ts, 4, 0) + "val"}, vals[2499])
+			assert.Equal(t, lmdbenv.KVString{Key: "local", Val: h(testTS(3), 5, 0) + "new"}, vals[2500])
+			return nil
+		})
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,102 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/sirupsen/logrus"
+)
+
+// maxMapResizedRetries limits how often we adopt a map size change by another
+// process for a single operation.
+const maxMapResizedRetries = 10
+
+// growMapSize multiplies the LMDB map size by the configured factor, up to
+// the configured maximum. It returns false if the map size cannot grow any
+// further.
+// This must not be called while the syncer has an active transaction.
+func (s *Syncer) growMapSize(env *lmdb.Env, reason string) (grown bool, err error) {
+	g := s.lc.MapSizeGrowth
+	used, size, err := lmdbenv.MapUsage(env)
+	if err != nil {
+		return false, err
+	}
+	maxSize := int64(g.Max.Bytes())
+	newSize := int64(float64(size) * g.Factor)
+	if newSize > maxSize {
+		newSize = maxSize
+	}
+	// The map size should be a multiple of the OS page size
+	pageSize := int64(os.Getpagesize())
+	newSize -= newSize % pageSize
+	if newSize <= size {
+		return false, nil
+	}
+
+	if err := lmdbenv.SetMapSize(env, newSize); err != nil {
+		return false, fmt.Errorf("grow map size: %w", err)
+	}
+	s.l.WithFields(logrus.Fields{
+		"reason":       reason,
+		"used":         datasize.ByteSize(used).HumanReadable(),
+		"old_map_size": datasize.ByteSize(size).HumanReadable(),
+		"new_map_size": datasize.ByteSize(newSize).HumanReadable(),
+	}).Warn("Grew LMDB map size")
+	metricMapSizeGrowths.WithLabelValues(s.name, reason).Inc()
+	return true, nil
+}
+
+// checkMapSize proactively grows the map size if the usage exceeds the
+// configured threshold, so that a snapshot load is unlikely to run out of
+// space.
+func (s *Syncer) checkMapSize(env *lmdb.Env) error {
+	g := s.lc.MapSizeGrowth
+	if !g.Enabled() || g.Threshold == 0 {
+		return nil
+	}
+	used, size, err := lmdbenv.MapUsage(env)
+	if err != nil {
+		return err
+	}
+	if float64(used) < g.Threshold*float64(size) {
+		return nil
+	}
+	_, err = s.growMapSize(env, "threshold")
+	return err
+}
+
+// withMapSizeRetry calls fn and calls it again if it failed because the LMDB
+// map is full and the map size could be grown, or because another process
+// grew the map size, in which case we adopt the new map size.
+// Every fn is expected to be safe to retry, like loads and snapshots are.
+func (s *Syncer) withMapSizeRetry(env *lmdb.Env, fn func() error) error {
+	resized := 0
+	for {
+		err := fn()
+		switch {
+		case err == nil:
+			return nil
+		case lmdbenv.IsErrno(err, lmdb.MapResized) && resized < maxMapResizedRetries:
+			resized++
+			s.l.Info("LMDB map size was changed by another process, adopting new map size")
+			if err := lmdbenv.SetMapSize(env, 0); err != nil {
+				return fmt.Errorf("adopt map size: %w", err)
+			}
+		case lmdbenv.IsErrno(err, lmdb.MapFull) && s.lc.MapSizeGrowth.Enabled():
+			grown, gerr := s.growMapSize(env, "map_full")
+			if gerr != nil {
+				return gerr
+			}
+			if !grown {
+				s.l.WithField("max", s.lc.MapSizeGrowth.Max.HumanReadable()).
+					Error("LMDB map is full and reached the maximum map size")
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
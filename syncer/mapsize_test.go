@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/hooks"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_withMapSizeRetry(t *testing.T) {
+	const initialSize = 256 * 1024
+
+	fill := func(env *lmdb.Env, n int) func() error {
+		return func() error {
+			return env.Update(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", lmdb.Create)
+				if err != nil {
+					return err
+				}
+				for i := 0; i < n; i++ {
+					key := fmt.Appendf(nil, "key-%05d", i)
+					if err := txn.Put(dbi, key, make([]byte, 1000), 0); err != nil {
+						return fmt.Errorf("put: %w", err)
+					}
+				}
+				return nil
+			})
+		}
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		require.NoError(t, lmdbenv.SetMapSize(env, initialSize))
+		lc := config.LMDB{
+			MapSizeGrowth: config.MapSizeGrowth{
+				Factor: 2,
+				Max:    4 * datasize.MB,
+			},
+		}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		// About 1 MB of data needs several growths
+		err = s.withMapSizeRetry(env, fill(env, 1000))
+		require.NoError(t, err)
+		info, err := env.Info()
+		require.NoError(t, err)
+		assert.Greater(t, info.MapSize, int64(initialSize))
+		assert.LessOrEqual(t, info.MapSize, int64(4*datasize.MB))
+
+		// More than the maximum
+		err = s.withMapSizeRetry(env, fill(env, 10_000))
+		assert.True(t, lmdbenv.IsErrno(err, lmdb.MapFull), "unexpected error: %v", err)
+		info, err = env.Info()
+		require.NoError(t, err)
+		assert.Equal(t, int64(4*datasize.MB), info.MapSize)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Without growth enabled, the error is returned
+	err = lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		require.NoError(t, lmdbenv.SetMapSize(env, initialSize))
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{}, Options{})
+		require.NoError(t, err)
+		err = s.withMapSizeRetry(env, fill(env, 1000))
+		assert.True(t, lmdbenv.IsErrno(err, lmdb.MapFull), "unexpected error: %v", err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_mapSizeChangeConcurrent(t *testing.T) {
+	ts := testTS(1)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		err := env.Update(func(txn *lmdb.Txn) error {
+			for _, name := range []string{"a", "b", "c", "d"} {
+				dbi, err := txn.OpenDBI(name, lmdb.Create)
+				require.NoError(t, err)
+				for j := 0; j < 1000; j++ {
+					key := fmt.Appendf(nil, "key-%06d", j)
+					require.NoError(t, txn.Put(dbi, key, b(h(ts, 1, 0)+"val"), 0))
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Every DBI read must hold the lock that SetMapSize waits for,
+		// including the reads by parallel workers.
+		var unlockedReads atomic.Int64
+		hk := hooks.New()
+		hk.FilterReadDBI = func(p hooks.FilterReadDBIParams) bool {
+			if !lmdbenv.TxnActive(env) {
+				unlockedReads.Add(1)
+			}
+			return true
+		}
+
+		ctx := context.Background()
+		c := config.Config{StorageRetryCount: 1, SnapshotWorkers: 4}
+		s, err := New("test", env, memory.New(), c, config.LMDB{SchemaTracksChanges: true}, Options{Hooks: hk})
+		require.NoError(t, err)
+		// The shadow schema reads the DBIs in a write transaction on load
+		shadow, err := New("shadow", env, memory.New(), c, config.LMDB{}, Options{Hooks: hk})
+		require.NoError(t, err)
+
+		dbiMsg := snapshot.NewDBI()
+		dbiMsg.SetName("a")
+		for j := 0; j < 1000; j++ {
+			key := fmt.Appendf(nil, "key-%06d", j)
+			dbiMsg.Append(snapshot.KV{Key: key, Value: b("new"), TimestampNano: uint64(testTS(2))})
+		}
+		update := snapshot.Update{Snapshot: &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.CompatFormatVersion,
+			Databases:     []*snapshot.DBI{dbiMsg},
+		}}
+
+		// Grow the map size while transactions are running in other
+		// goroutines, which must wait for each other.
+		info, err := env.Info()
+		require.NoError(t, err)
+		done := make(chan error)
+		go func() {
+			size := info.MapSize
+			for i := 0; i < 50; i++ {
+				size += int64(os.Getpagesize())
+				if err := lmdbenv.SetMapSize(env, size); err != nil {
+					done <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+			done <- nil
+		}()
+
+		var lastTxnID, lastShadowTxnID header.TxnID
+		for {
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+				newInfo, err := env.Info()
+				require.NoError(t, err)
+				assert.Equal(t, info.MapSize+50*int64(os.Getpagesize()), newInfo.MapSize)
+				assert.Zero(t, unlockedReads.Load())
+				return nil
+			default:
+			}
+			_, err := s.SendOnce(ctx, env)
+			require.NoError(t, err)
+			_, err = s.ReadSnapshot(ctx, env)
+			require.NoError(t, err)
+			lastTxnID, _, err = s.LoadOnce(ctx, env, "remote", update, lastTxnID)
+			require.NoError(t, err)
+			lastShadowTxnID, _, err = shadow.LoadOnce(ctx, env, "remote", update, lastShadowTxnID)
+			require.NoError(t, err)
+		}
+	})
+	require.NoError(t, err)
+}
@@ -5,6 +5,13 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// replicationLatencyBuckets are the buckets of metricReplicationLatency
+var replicationLatencyBuckets = []float64{
+	0.5, 1, 2, 5, 10, 20, 30, // seconds
+	60, 120, 300, 600, 1800, // minutes
+	3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600, // hours and days
+}
+
 var (
 	lmdbCollector *stats.Collector
 
@@ -55,6 +62,79 @@ var (
 			Help: "Number of bytes stored successfully",
 		},
 	)
+	metricLoadPreparedStale = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_load_prepared_stale_total",
+			Help: "Number of loads where the changes prepared before the write lock could not be used, because the LMDB changed",
+		},
+		[]string{"lmdb"},
+	)
+	metricLoadPrepareMemoryExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_load_prepare_memory_exceeded_total",
+			Help: "Number of DBIs merged in the write transaction, because their prepared changes did not fit in the prepare_load.max_memory limit",
+		},
+		[]string{"lmdb"},
+	)
+	metricMapSizeGrowths = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_map_size_growths_total",
+			Help: "Number of times the LMDB map size was grown, by reason",
+		},
+		[]string{"lmdb", "reason"},
+	)
+	metricLoadDBIsSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_load_dbis_skipped_total",
+			Help: "Number of snapshot DBIs not loaded, because they did not change since the last snapshot of the instance",
+		},
+		[]string{"lmdb"},
+	)
+	metricPaused = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lightningstream_syncer_paused",
+			Help: "Set to 1 if loading or sending was paused with the admin API",
+		},
+		[]string{"lmdb", "direction"},
+	)
+	metricSeedsPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_seeds_published_total",
+			Help: "Number of seed images published to the storage",
+		},
+		[]string{"lmdb"},
+	)
+	metricSeedsPublishFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_seeds_publish_failed_total",
+			Help: "Number of seed images that could not be published",
+		},
+		[]string{"lmdb"},
+	)
+	metricReplicationLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "lightningstream_syncer_replication_latency_seconds",
+			Help: "Time between a change on a remote instance and applying it locally, " +
+				"for every entry that changed the LMDB when loading a snapshot",
+			Buckets: replicationLatencyBuckets,
+		},
+		[]string{"lmdb", "syncer_instance", "dbi"},
+	)
+	metricLoadEntries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lightningstream_syncer_load_entries_total",
+			Help: "Number of snapshot entries merged into the LMDB by result " +
+				"(added, updated, deleted, skipped, unchanged or dropped)",
+		},
+		[]string{"lmdb", "syncer_instance", "dbi", "result"},
+	)
+	metricSnapshotDBIEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lightningstream_syncer_snapshots_generated_last_dbi_entries",
+			Help: "Number of entries per DBI in the last generated snapshot",
+		},
+		[]string{"lmdb", "dbi"},
+	)
 )
 
 func init() {
@@ -68,4 +148,14 @@ func init() {
 	prometheus.MustRegister(metricSnapshotsStoreFailedPermanently)
 	prometheus.MustRegister(metricSnapshotsStoreCalls)
 	prometheus.MustRegister(metricSnapshotsStoreBytes)
+	prometheus.MustRegister(metricLoadPreparedStale)
+	prometheus.MustRegister(metricLoadPrepareMemoryExceeded)
+	prometheus.MustRegister(metricLoadDBIsSkipped)
+	prometheus.MustRegister(metricMapSizeGrowths)
+	prometheus.MustRegister(metricPaused)
+	prometheus.MustRegister(metricSeedsPublished)
+	prometheus.MustRegister(metricSeedsPublishFailed)
+	prometheus.MustRegister(metricReplicationLatency)
+	prometheus.MustRegister(metricLoadEntries)
+	prometheus.MustRegister(metricSnapshotDBIEntries)
 }
@@ -0,0 +1,343 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/sirupsen/logrus"
+)
+
+// errDryRun is used to roll back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// MigrateDBIStats describes the result of a schema migration for a single DBI
+type MigrateDBIStats struct {
+	DBI     string `json:"dbi"`
+	Entries int    `json:"entries"` // number of live entries
+	Deleted int    `json:"deleted"` // number of deletion markers
+}
+
+// MigrateSchema converts the LMDB between a shadow schema (main DBIs plus
+// shadow DBIs with timestamps) and a native schema (main DBIs with headers)
+// in a single write transaction.
+//
+// When converting to native, any changes in the main DBIs that have not been
+// synced to the shadow DBIs yet are first synced with the current time.
+// The timestamps and deletion markers of the shadow DBIs are then moved into
+// the headers of the main DBIs and the shadow DBIs are removed.
+//
+// When converting to shadow, the headers are moved from the main DBIs into
+// new shadow DBIs and the deleted entries are removed from the main DBIs.
+//
+// DupSort DBIs are migrated using the dupsort_set_v1 transform, which must be
+// enabled with dupsort_set.
+//
+// The migration is refused if the schema_tracks_changes setting or the
+// contents of the LMDB show that it already uses the target schema, because
+// migrating it again would corrupt all values.
+// If dryRun is set, the transaction is rolled back, but the returned stats
+// reflect the changes that would have been made.
+// The schema_tracks_changes setting needs to be changed after the migration.
+func (s *Syncer) MigrateSchema(ctx context.Context, env *lmdb.Env, toNative, dryRun bool) (stats []MigrateDBIStats, err error) {
+	if s.lc.SchemaTracksChanges == toNative {
+		return nil, fmt.Errorf("schema_tracks_changes is already %v for this LMDB, "+
+			"it must reflect the current schema during the migration", toNative)
+	}
+	t0 := time.Now()
+	err = lmdbenv.Update(env, func(txn *lmdb.Txn) error {
+		stats = nil // in case of retries
+
+		dbiNames, err := lmdbenv.ReadDBINames(txn)
+		if err != nil {
+			return err
+		}
+		if err := s.checkMigrateSchema(txn, dbiNames, toNative); err != nil {
+			return err
+		}
+
+		if toNative {
+			// Capture any changes made since the last sync
+			if err := s.mainToShadow(ctx, txn, header.TimestampFromTime(t0)); err != nil {
+				return err
+			}
+		}
+
+		for _, dbiName := range dbiNames {
+			if strings.HasPrefix(dbiName, SyncDBIPrefix) {
+				continue // skip shadow and other special databases
+			}
+			var st MigrateDBIStats
+			if toNative {
+				st, err = s.migrateDBIToNative(txn, dbiName)
+			} else {
+				st, err = s.migrateDBIToShadow(txn, dbiName)
+			}
+			if err != nil {
+				return fmt.Errorf("dbi %s: %w", dbiName, err)
+			}
+			stats = append(stats, st)
+
+			if utils.IsCanceled(ctx) {
+				return context.Canceled
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+
+	s.l.WithFields(logrus.Fields{
+		"to_native":  toNative,
+		"dry_run":    dryRun,
+		"time_total": utils.TimeDiff(time.Now(), t0),
+	}).Info("Schema migration completed")
+	return stats, nil
+}
+
+// checkMigrateSchema checks if the contents of the LMDB match the current
+// schema: a shadow schema must have shadow DBIs, and all values of a native
+// schema must have a header.
+func (s *Syncer) checkMigrateSchema(txn *lmdb.Txn, dbiNames []string, toNative bool) error {
+	var mainDBIs []string
+	hasShadow := false
+	for _, dbiName := range dbiNames {
+		if strings.HasPrefix(dbiName, SyncDBIShadowPrefix) {
+			hasShadow = true
+		}
+		if !strings.HasPrefix(dbiName, SyncDBIPrefix) {
+			mainDBIs = append(mainDBIs, dbiName)
+		}
+	}
+	if toNative {
+		if len(mainDBIs) > 0 && !hasShadow {
+			return fmt.Errorf("no %s* DBIs found, the LMDB does not use the shadow schema "+
+				"or was never synced", SyncDBIShadowPrefix)
+		}
+		return nil
+	}
+	for _, dbiName := range mainDBIs {
+		if err := checkNativeDBI(txn, dbiName); err != nil {
+			return fmt.Errorf("dbi %s: %w, the LMDB does not use the native schema", dbiName, err)
+		}
+	}
+	return nil
+}
+
+// checkNativeDBI checks if all values in the DBI have a native header
+func checkNativeDBI(txn *lmdb.Txn, dbiName string) error {
+	dbi, err := txn.OpenDBI(dbiName, 0)
+	if err != nil {
+		return err
+	}
+	c, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return fmt.Errorf("open cursor: %w", err)
+	}
+	defer c.Close()
+
+	restoreRawRead := txn.RawRead
+	txn.RawRead = true
+	defer func() {
+		txn.RawRead = restoreRawRead
+	}()
+
+	var flag uint = lmdb.First
+	for {
+		key, val, err := c.Get(nil, nil, flag)
+		if err != nil {
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("cursor next: %w", err)
+		}
+		flag = lmdb.Next
+		if _, _, err := header.Parse(val); err != nil {
+			return fmt.Errorf("value of key %s has no valid header: %w",
+				utils.DisplayASCII(key), err)
+		}
+	}
+}
+
+// migrateDBIToNative replaces the contents of a main DBI with the contents
+// of its shadow DBI and drops the shadow DBI.
+func (s *Syncer) migrateDBIToNative(txn *lmdb.Txn, dbiName string) (st MigrateDBIStats, err error) {
+	st.DBI = dbiName
+	dbi, err := txn.OpenDBI(dbiName, 0)
+	if err != nil {
+		return st, err
+	}
+	dbiFlags, err := txn.Flags(dbi)
+	if err != nil {
+		return st, err
+	}
+	isDupSort := dbiFlags&lmdb.DupSort > 0
+	if err := s.checkMigrateDupSort(isDupSort); err != nil {
+		return st, err
+	}
+
+	// At this point the shadow DBI exists, because of the mainToShadow call.
+	shadowName := SyncDBIShadowPrefix + dbiName
+	dbiMsg, err := s.readDBI(txn, shadowName, dbiName, false)
+	if err != nil {
+		return st, err
+	}
+	shadowDBI, err := txn.OpenDBI(shadowName, 0)
+	if err != nil {
+		return st, err
+	}
+
+	if err := txn.Drop(dbi, false); err != nil {
+		return st, err
+	}
+	txnID := header.TxnID(txn.ID())
+	buf := make([]byte, 0, 1024)
+	dbiMsg.ResetCursor()
+	for {
+		kv, err := dbiMsg.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return st, err
+		}
+		vals := []snapshot.DupValue{{
+			Value:         kv.Value,
+			TimestampNano: kv.TimestampNano,
+			Flags:         kv.Flags,
+		}}
+		if isDupSort {
+			// Every value of the set gets its own header, including the
+			// deleted ones
+			vals, err = snapshot.DecodeDupSet(kv.Value)
+			if err != nil {
+				return st, fmt.Errorf("dupsort_set decode for key %s: %w",
+					utils.DisplayASCII(kv.Key), err)
+			}
+		}
+		for _, v := range vals {
+			flags := v.MaskedFlags()
+			if flags.IsDeleted() {
+				st.Deleted++
+			} else {
+				st.Entries++
+			}
+			buf = buf[:header.MinHeaderSize]
+			header.PutBasic(buf, header.Timestamp(v.TimestampNano), txnID, flags)
+			buf = append(buf, v.Value...)
+			if err := txn.Put(dbi, kv.Key, buf, 0); err != nil {
+				return st, err
+			}
+		}
+	}
+
+	return st, txn.Drop(shadowDBI, true)
+}
+
+// migrateDBIToShadow moves the headers of a native DBI into a new shadow DBI
+// and removes the headers and deleted entries from the main DBI.
+func (s *Syncer) migrateDBIToShadow(txn *lmdb.Txn, dbiName string) (st MigrateDBIStats, err error) {
+	st.DBI = dbiName
+	dbi, err := txn.OpenDBI(dbiName, 0)
+	if err != nil {
+		return st, err
+	}
+	dbiFlags, err := txn.Flags(dbi)
+	if err != nil {
+		return st, err
+	}
+	isDupSort := dbiFlags&lmdb.DupSort > 0
+	if err := s.checkMigrateDupSort(isDupSort); err != nil {
+		return st, err
+	}
+
+	// For DupSort DBIs, the values of a key are grouped into a set
+	dbiMsg, err := s.readDBI(txn, dbiName, dbiName, false)
+	if err != nil {
+		return st, err
+	}
+
+	// Any existing shadow DBI must be a leftover from an earlier migration,
+	// since shadow DBIs are not used with a native schema.
+	shadowFlags := dbiFlags & uint(AllowedShadowDBIFlagsMask)
+	shadowDBI, err := txn.OpenDBI(SyncDBIShadowPrefix+dbiName, lmdb.Create|shadowFlags)
+	if err != nil {
+		return st, err
+	}
+	if err := txn.Drop(shadowDBI, false); err != nil {
+		return st, err
+	}
+	if err := txn.Drop(dbi, false); err != nil {
+		return st, err
+	}
+
+	txnID := header.TxnID(txn.ID())
+	buf := make([]byte, 0, 1024)
+	dbiMsg.ResetCursor()
+	for {
+		kv, err := dbiMsg.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return st, err
+		}
+		flags := kv.MaskedFlags()
+		buf = buf[:header.MinHeaderSize]
+		header.PutBasic(buf, header.Timestamp(kv.TimestampNano), txnID, flags)
+		buf = append(buf, kv.Value...)
+		if err := txn.Put(shadowDBI, kv.Key, buf, 0); err != nil {
+			return st, err
+		}
+		if !isDupSort {
+			if flags.IsDeleted() {
+				st.Deleted++
+				continue
+			}
+			st.Entries++
+			if err := txn.Put(dbi, kv.Key, kv.Value, 0); err != nil {
+				return st, err
+			}
+			continue
+		}
+		vals, err := snapshot.DecodeDupSet(kv.Value)
+		if err != nil {
+			return st, fmt.Errorf("dupsort_set decode for key %s: %w",
+				utils.DisplayASCII(kv.Key), err)
+		}
+		for _, v := range vals {
+			if v.MaskedFlags().IsDeleted() {
+				st.Deleted++
+				continue
+			}
+			st.Entries++
+			if err := txn.Put(dbi, kv.Key, v.Value, 0); err != nil {
+				return st, err
+			}
+		}
+	}
+	return st, nil
+}
+
+// checkMigrateDupSort checks if a DupSort DBI can be migrated, which requires
+// the dupsort_set_v1 transform.
+func (s *Syncer) checkMigrateDupSort(isDupSort bool) error {
+	if isDupSort && s.dupSortTransform() != snapshot.TransformDupSortSetV1 {
+		return fmt.Errorf("dupsort DBIs can only be migrated with the %s transform, "+
+			"enable dupsort_set", snapshot.TransformDupSortSetV1)
+	}
+	return nil
+}
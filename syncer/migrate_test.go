@@ -0,0 +1,167 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_MigrateSchema(t *testing.T) {
+	ts1 := testTS(1)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{}, Options{})
+		require.NoError(t, err)
+
+		// Shadow mode data with one deleted entry
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("a"), b("abc"), 0))
+			require.NoError(t, txn.Put(dbi, b("b"), b("xyz"), 0))
+			require.NoError(t, s.mainToShadow(ctx, txn, ts1))
+			require.NoError(t, txn.Del(dbi, b("b"), nil))
+			return s.mainToShadow(ctx, txn, ts1)
+		})
+		require.NoError(t, err)
+
+		readDBI := func(name string) (vals []lmdbenv.KVString) {
+			err := env.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI(name, 0)
+				if err != nil {
+					return err
+				}
+				vals, err = lmdbenv.ReadDBIString(txn, dbi)
+				return err
+			})
+			require.NoError(t, err)
+			return vals
+		}
+		shadowExists := func() (exists bool) {
+			err := env.View(func(txn *lmdb.Txn) (err error) {
+				exists, err = lmdbenv.DBIExists(txn, "_sync_shadow_foo")
+				return err
+			})
+			require.NoError(t, err)
+			return exists
+		}
+
+		// Dry run does not change anything
+		stats, err := s.MigrateSchema(ctx, env, true, true)
+		require.NoError(t, err)
+		assert.Equal(t, []MigrateDBIStats{{DBI: "foo", Entries: 1, Deleted: 1}}, stats)
+		assert.Equal(t, []lmdbenv.KVString{{Key: "a", Val: "abc"}}, readDBI("foo"))
+		assert.True(t, shadowExists())
+
+		// To native
+		stats, err = s.MigrateSchema(ctx, env, true, false)
+		require.NoError(t, err)
+		assert.Equal(t, []MigrateDBIStats{{DBI: "foo", Entries: 1, Deleted: 1}}, stats)
+		assert.Equal(t, []lmdbenv.KVString{
+			{Key: "a", Val: h(ts1, 2, 0) + "abc"},
+			{Key: "b", Val: h(ts1, 2, header.FlagDeleted)},
+		}, readDBI("foo"))
+		assert.False(t, shadowExists())
+
+		// Running it again is refused, also if the config was not updated
+		_, err = s.MigrateSchema(ctx, env, true, false)
+		assert.ErrorContains(t, err, "does not use the shadow schema")
+		s.lc.SchemaTracksChanges = true
+		_, err = s.MigrateSchema(ctx, env, true, false)
+		assert.ErrorContains(t, err, "schema_tracks_changes is already true")
+		assert.Equal(t, []lmdbenv.KVString{
+			{Key: "a", Val: h(ts1, 2, 0) + "abc"},
+			{Key: "b", Val: h(ts1, 2, header.FlagDeleted)},
+		}, readDBI("foo"))
+
+		// And back to shadow
+		stats, err = s.MigrateSchema(ctx, env, false, false)
+		require.NoError(t, err)
+		assert.Equal(t, []MigrateDBIStats{{DBI: "foo", Entries: 1, Deleted: 1}}, stats)
+		assert.Equal(t, []lmdbenv.KVString{{Key: "a", Val: "abc"}}, readDBI("foo"))
+		assert.Equal(t, []lmdbenv.KVString{
+			{Key: "a", Val: h(ts1, 3, 0) + "abc"},
+			{Key: "b", Val: h(ts1, 3, header.FlagDeleted)},
+		}, readDBI("_sync_shadow_foo"))
+
+		// Running it again is refused, because the values have no header
+		_, err = s.MigrateSchema(ctx, env, false, false)
+		assert.ErrorContains(t, err, "does not use the native schema")
+		assert.Equal(t, []lmdbenv.KVString{{Key: "a", Val: "abc"}}, readDBI("foo"))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_MigrateSchema_dupSort(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{DupSortSet: true}, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create|lmdb.DupSort)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("a"), b("1"), 0))
+			require.NoError(t, txn.Put(dbi, b("a"), b("2"), 0))
+			require.NoError(t, txn.Put(dbi, b("b"), b("3"), 0))
+			require.NoError(t, s.mainToShadow(ctx, txn, ts1))
+			require.NoError(t, txn.Del(dbi, b("a"), b("2")))
+			return s.mainToShadow(ctx, txn, ts2)
+		})
+		require.NoError(t, err)
+
+		readDBI := func() (vals []lmdbenv.KVString) {
+			err := env.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				if err != nil {
+					return err
+				}
+				vals, err = lmdbenv.ReadDBIString(txn, dbi)
+				return err
+			})
+			require.NoError(t, err)
+			return vals
+		}
+
+		// Not supported with the dupsort_hack
+		s.lc.DupSortSet = false
+		s.lc.DupSortHack = true
+		_, err = s.MigrateSchema(ctx, env, true, false)
+		assert.ErrorContains(t, err, "dupsort DBIs can only be migrated with the dupsort_set_v1 transform")
+		s.lc.DupSortSet = true
+		s.lc.DupSortHack = false
+
+		// To native, every value gets its own header
+		stats, err := s.MigrateSchema(ctx, env, true, false)
+		require.NoError(t, err)
+		assert.Equal(t, []MigrateDBIStats{{DBI: "foo", Entries: 2, Deleted: 1}}, stats)
+		assert.ElementsMatch(t, []lmdbenv.KVString{
+			{Key: "a", Val: h(ts1, 2, 0) + "1"},
+			{Key: "a", Val: h(ts2, 2, header.FlagDeleted) + "2"},
+			{Key: "b", Val: h(ts1, 2, 0) + "3"},
+		}, readDBI())
+
+		// And back to shadow
+		s.lc.SchemaTracksChanges = true
+		stats, err = s.MigrateSchema(ctx, env, false, false)
+		require.NoError(t, err)
+		assert.Equal(t, []MigrateDBIStats{{DBI: "foo", Entries: 2, Deleted: 1}}, stats)
+		assert.Equal(t, []lmdbenv.KVString{
+			{Key: "a", Val: "1"},
+			{Key: "b", Val: "3"},
+		}, readDBI())
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,287 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/lmdbenv/strategy"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// errPrepareStale is returned when the read transactions used for preparing
+// a load do not all see the same LMDB transaction.
+var errPrepareStale = errors.New("LMDB changed during load preparation")
+
+// preparedChange is a single change to apply to a DBI. A nil Val deletes
+// the key.
+type preparedChange struct {
+	Key []byte
+	Val []byte
+
+	TimestampNano uint64 // of the snapshot entry, for the replication latency
+}
+
+// preparedLoad contains the changes to apply for a snapshot, computed before
+// the write transaction was opened. They are only valid if the write
+// transaction has the expected TxnID, because that guarantees that no other
+// transaction was committed after the changes were prepared.
+type preparedLoad struct {
+	TxnID header.TxnID                // expected write TxnID
+	DBIs  map[string][]preparedChange // by target DBI name, in key order
+	Stats map[string]events.DBIStats  // by target DBI name, of the merge
+}
+
+// prepareLoad merges the snapshot DBIs with the current LMDB contents using
+// read transactions, so that the write transaction only needs to apply the
+// resulting changes. This reduces the time the write lock is held.
+//
+// Every DBI is prepared by a worker with its own read transaction, which all
+// need to see the same LMDB transaction.
+// DBIs that cannot be prepared, like dupsort_set DBIs, or whose changes do
+// not fit in the prepare_load.max_memory limit, are merged in the write
+// transaction as before. It returns nil if the load cannot be prepared at
+// all, for example because it is disabled or because the shadow DBIs first
+// need to be updated with local changes.
+//
+// The snapshot is decompressed as a whole by the downloader before we get
+// here. Snapshots of different instances are decompressed in parallel, but a
+// single snapshot cannot be, because the gzip members it consists of are not
+// indexed.
+func (s *Syncer) prepareLoad(ctx context.Context, env *lmdb.Env, snap *snapshot.Snapshot, databases []*snapshot.DBI, lastTxnID header.TxnID, deletedCutoff header.Timestamp) (*preparedLoad, error) {
+	if s.lc.PrepareLoad.Disabled {
+		return nil, nil
+	}
+	schemaTracksChanges := s.lc.SchemaTracksChanges
+
+	var readTxnID header.TxnID
+	err := lmdbenv.View(env, func(txn *lmdb.Txn) error {
+		readTxnID = header.TxnID(txn.ID())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !schemaTracksChanges && lastTxnID < readTxnID {
+		// Local changes that mainToShadow must first merge into the shadow DBIs
+		return nil, nil
+	}
+
+	// The write transaction we expect to get if no other transaction is
+	// committed in the meantime.
+	txnID := readTxnID + 1
+
+	var candidates []*snapshot.DBI
+	for _, dbiMsg := range databases {
+		if strings.HasPrefix(dbiMsg.Name(), SyncDBIPrefix) {
+			continue
+		}
+		if dbiMsg.Transform() != snapshot.TransformNone {
+			continue // merged in write transaction
+		}
+		candidates = append(candidates, dbiMsg)
+	}
+
+	// Memory left for prepared changes, shared by all workers
+	var memLeft atomic.Int64
+	memLeft.Store(s.lc.PrepareLoad.MaxMemoryBytes())
+
+	results := make([][]preparedChange, len(candidates))
+	stats := make([]events.DBIStats, len(candidates))
+	prepared := make([]bool, len(candidates))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(s.snapshotWorkers())
+	for i, dbiMsg := range candidates {
+		eg.Go(func() error {
+			return lmdbenv.View(env, func(txn *lmdb.Txn) error {
+				if header.TxnID(txn.ID()) != readTxnID {
+					return errPrepareStale
+				}
+				if utils.IsCanceled(egCtx) {
+					return context.Canceled
+				}
+				changes, st, ok, err := s.prepareDBI(txn, snap, dbiMsg, txnID, deletedCutoff, &memLeft)
+				if err != nil {
+					return fmt.Errorf("dbi %s: %w", dbiMsg.Name(), err)
+				}
+				results[i] = changes
+				stats[i] = st
+				prepared[i] = ok
+				return nil
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		if errors.Is(err, errPrepareStale) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	pl := &preparedLoad{
+		TxnID: txnID,
+		DBIs:  make(map[string][]preparedChange, len(candidates)),
+		Stats: make(map[string]events.DBIStats, len(candidates)),
+	}
+	for i, dbiMsg := range candidates {
+		if prepared[i] {
+			pl.DBIs[dbiMsg.Name()] = results[i]
+			pl.Stats[dbiMsg.Name()] = stats[i]
+		}
+	}
+	return pl, nil
+}
+
+// prepareDBI returns the changes that merging the snapshot DBI would make to
+// its target DBI in the given read transaction, and the merge stats. If ok is
+// false, the DBI cannot be prepared and must be merged in the write
+// transaction. The size of the changes is taken from memLeft, and the DBI is
+// not prepared if they do not fit.
+func (s *Syncer) prepareDBI(txn *lmdb.Txn, snap *snapshot.Snapshot, dbiMsg *snapshot.DBI, txnID header.TxnID, deletedCutoff header.Timestamp, memLeft *atomic.Int64) (changes []preparedChange, stats events.DBIStats, ok bool, err error) {
+	dbiName := dbiMsg.Name()
+	targetDBIName := dbiName
+	if !s.lc.SchemaTracksChanges {
+		targetDBIName = SyncDBIShadowPrefix + dbiName
+	}
+
+	// A target DBI that does not exist yet is created empty by the write
+	// transaction.
+	exists, err := lmdbenv.DBIExists(txn, targetDBIName)
+	if err != nil {
+		return nil, stats, false, err
+	}
+	var targetDBI lmdb.DBI
+	if exists {
+		targetDBI, err = txn.OpenDBI(targetDBIName, 0)
+		if err != nil {
+			return nil, stats, false, err
+		}
+	}
+
+	it, err := NewNativeIterator(
+		snap.FormatVersion,
+		snap.CompatVersion,
+		dbiMsg,
+		0, // no default timestamp
+		txnID,
+		deletedCutoff,
+	)
+	if err != nil {
+		return nil, stats, false, fmt.Errorf("create native iterator: %w", err)
+	}
+	if s.lc.HeaderExtraPaddingBlock {
+		it.HeaderPaddingBlock = true
+	}
+	it.KeyLimit = s.changedKeyLimit()
+
+	// The old values only need to be valid until the merge
+	txn.RawRead = true
+
+	// Every key must be unique, because all merges are done against the
+	// state of the read transaction. Keys in snapshots are always sorted,
+	// so we check that to detect duplicates.
+	cmpFunc := strategy.KeyCmpFunc(uint(dbiMsg.Flags()))
+	var prevKey []byte
+	var memUsed int64
+	defer func() {
+		if !ok {
+			// Give back everything this DBI took, the changes are dropped
+			memLeft.Add(memUsed)
+		}
+	}()
+	// reserve takes the size of a change from memLeft, and returns false if
+	// it does not fit.
+	reserve := func(size int) bool {
+		memUsed += int64(size)
+		if memLeft.Add(-int64(size)) < 0 {
+			metricLoadPrepareMemoryExceeded.WithLabelValues(s.name).Inc()
+			return false
+		}
+		return true
+	}
+	for {
+		key, err := it.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, stats, false, err
+		}
+		if prevKey != nil && cmpFunc(prevKey, key) >= 0 {
+			return nil, stats, false, nil // not sorted, merge in write transaction
+		}
+		prevKey = key
+
+		var oldVal []byte
+		if exists {
+			oldVal, err = txn.Get(targetDBI, key)
+			if err != nil && !lmdb.IsNotFound(err) {
+				return nil, stats, false, fmt.Errorf("get: %w", err)
+			}
+		}
+		val, err := it.Merge(oldVal)
+		if err != nil {
+			return nil, stats, false, fmt.Errorf("merge: %w", err)
+		}
+		// Same logic as setNewVal in the strategies
+		if len(val) == 0 {
+			if len(oldVal) > 0 {
+				if !reserve(len(key)) {
+					return nil, stats, false, nil // merge in write transaction
+				}
+				changes = append(changes, preparedChange{
+					Key:           key,
+					TimestampNano: it.curKV.TimestampNano,
+				})
+			}
+			continue
+		}
+		if string(val) == string(oldVal) {
+			continue
+		}
+		if !reserve(len(key) + len(val)) {
+			return nil, stats, false, nil // merge in write transaction
+		}
+		// The iterator reuses its buffer
+		changes = append(changes, preparedChange{
+			Key:           key,
+			Val:           append([]byte(nil), val...),
+			TimestampNano: it.curKV.TimestampNano,
+		})
+	}
+	return changes, it.Stats, true, nil
+}
+
+// applyPrepared applies the prepared changes for a DBI and observes their
+// replication latency with the given apply time. The latency observer must
+// buffer the observations until the transaction is committed.
+func applyPrepared(ctx context.Context, txn *lmdb.Txn, dbi lmdb.DBI, changes []preparedChange, latency prometheus.Observer, applyTime time.Time) error {
+	for i, ch := range changes {
+		observeLatency(latency, applyTime, ch.TimestampNano)
+		if ch.Val == nil {
+			err := txn.Del(dbi, ch.Key, nil)
+			if err != nil && !lmdb.IsNotFound(err) {
+				return fmt.Errorf("del: %w", err)
+			}
+		} else {
+			if err := txn.Put(dbi, ch.Key, ch.Val, 0); err != nil {
+				return fmt.Errorf("put: %w", err)
+			}
+		}
+		if i%10_000 == 0 && utils.IsCanceled(ctx) {
+			return context.Canceled
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package syncer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_prepareLoad(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+	ts3 := testTS(3)
+
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("new"), TimestampNano: uint64(ts2)})
+	dbiMsg.Append(snapshot.KV{Key: b("b"), Value: b("old"), TimestampNano: uint64(ts1)})
+	dbiMsg.Append(snapshot.KV{Key: b("c"), Flags: uint32(header.FlagDeleted), TimestampNano: uint64(ts3)})
+	dbiMsg.Append(snapshot.KV{Key: b("d"), Value: b("added"), TimestampNano: uint64(ts2)})
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{SchemaTracksChanges: true}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("a"), b(h(ts1, 1, 0)+"x"), 0))
+			require.NoError(t, txn.Put(dbi, b("b"), b(h(ts2, 1, 0)+"y"), 0))
+			require.NoError(t, txn.Put(dbi, b("c"), b(h(ts2, 1, 0)+"z"), 0))
+			return nil
+		})
+		require.NoError(t, err)
+
+		prepared, err := s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+		require.NoError(t, err)
+		require.NotNil(t, prepared)
+		assert.Equal(t, header.TxnID(2), prepared.TxnID)
+		assert.Equal(t, []preparedChange{
+			{Key: b("a"), Val: b(h(ts2, 2, 0) + "new"), TimestampNano: uint64(ts2)},
+			// "b" is newer in the LMDB
+			{Key: b("c"), Val: b(h(ts3, 2, header.FlagDeleted)), TimestampNano: uint64(ts3)},
+			{Key: b("d"), Val: b(h(ts2, 2, 0) + "added"), TimestampNano: uint64(ts2)},
+		}, prepared.DBIs["foo"])
+
+		// The prepared changes give the same result as a merge
+		txnID, _, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		assert.Equal(t, prepared.TxnID, txnID)
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "a", Val: h(ts2, 2, 0) + "new"},
+				{Key: "b", Val: h(ts2, 1, 0) + "y"},
+				{Key: "c", Val: h(ts3, 2, header.FlagDeleted)},
+				{Key: "d", Val: h(ts2, 2, 0) + "added"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Loading the same snapshot again does not result in any changes
+		prepared, err = s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+		require.NoError(t, err)
+		require.NotNil(t, prepared)
+		assert.Empty(t, prepared.DBIs["foo"])
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_prepareLoad_shadow(t *testing.T) {
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("new"), TimestampNano: uint64(testTS(2))})
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{}, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			return txn.Put(dbi, b("a"), b("x"), 0)
+		})
+		require.NoError(t, err)
+
+		// Local changes that are not in the shadow DBI yet
+		prepared, err := s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+		require.NoError(t, err)
+		assert.Nil(t, prepared)
+
+		// Without local changes, the shadow DBI is the target
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return s.mainToShadow(ctx, txn, testTS(1))
+		})
+		require.NoError(t, err)
+		prepared, err = s.prepareLoad(ctx, env, snap, snap.Databases, 2, 0)
+		require.NoError(t, err)
+		require.NotNil(t, prepared)
+		assert.Equal(t, []preparedChange{
+			{Key: b("a"), Val: b(h(testTS(2), 3, 0) + "new"), TimestampNano: uint64(testTS(2))},
+		}, prepared.DBIs["foo"])
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_prepareLoad_limits(t *testing.T) {
+	ts := testTS(1)
+	newDBI := func(name string) *snapshot.DBI {
+		dbiMsg := snapshot.NewDBI()
+		dbiMsg.SetName(name)
+		dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("0123456789"), TimestampNano: uint64(ts)})
+		return dbiMsg
+	}
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{newDBI("foo"), newDBI("bar")},
+	}
+	// Size of the key and value of a single prepared change
+	changeSize := len("a") + header.MinHeaderSize + len("0123456789")
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+
+		t.Run("disabled", func(t *testing.T) {
+			lc := config.LMDB{SchemaTracksChanges: true}
+			lc.PrepareLoad.Disabled = true
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+			prepared, err := s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+			require.NoError(t, err)
+			assert.Nil(t, prepared)
+		})
+
+		t.Run("max-memory", func(t *testing.T) {
+			lc := config.LMDB{SchemaTracksChanges: true}
+			lc.PrepareLoad.MaxMemory = datasize.ByteSize(changeSize)
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+			prepared, err := s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+			require.NoError(t, err)
+			require.NotNil(t, prepared)
+			// Only one of the DBIs fits, the other one is merged in the
+			// write transaction
+			assert.Len(t, prepared.DBIs, 1)
+
+			lc.PrepareLoad.MaxMemory = datasize.ByteSize(2 * changeSize)
+			s, err = New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+			prepared, err = s.prepareLoad(ctx, env, snap, snap.Databases, 0, 0)
+			require.NoError(t, err)
+			require.NotNil(t, prepared)
+			assert.Len(t, prepared.DBIs, 2)
+		})
+
+		t.Run("not-sorted", func(t *testing.T) {
+			lc := config.LMDB{SchemaTracksChanges: true}
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+			dbiMsg := snapshot.NewDBI()
+			dbiMsg.SetName("foo")
+			dbiMsg.Append(snapshot.KV{Key: b("b"), Value: b("0123456789"), TimestampNano: uint64(ts)})
+			dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("0123456789"), TimestampNano: uint64(ts)})
+			var memLeft atomic.Int64
+			memLeft.Store(1000)
+			err = env.View(func(txn *lmdb.Txn) error {
+				_, _, ok, err := s.prepareDBI(txn, snap, dbiMsg, 1, 0, &memLeft)
+				require.NoError(t, err)
+				assert.False(t, ok)
+				return nil
+			})
+			require.NoError(t, err)
+			// The memory taken for "b" was given back
+			assert.Equal(t, int64(1000), memLeft.Load())
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,74 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// ReadSnapshot reads the current LMDB contents into a snapshot without
+// modifying the LMDB, for tools like export.
+// With a native schema, the main DBIs are read. In shadow mode, the shadow
+// DBIs are read instead, which reflect the state of the main DBIs as of the
+// last sync performed by a syncer. DBIs without a shadow DBI are skipped.
+func (s *Syncer) ReadSnapshot(ctx context.Context, env *lmdb.Env) (*snapshot.Snapshot, error) {
+	var msg = new(snapshot.Snapshot)
+	msg.FormatVersion = snapshot.CurrentFormatVersion
+	msg.CompatVersion = snapshot.WriteCompatFormatVersion
+	msg.Meta.DatabaseName = s.name
+	msg.Meta.Hostname = hostname
+	msg.Meta.InstanceID = s.instanceID()
+	msg.Meta.GenerationID = s.generationID()
+	msg.Meta.SchemaVersion = s.lc.SchemaVersion
+
+	schemaTracksChanges := s.lc.SchemaTracksChanges
+
+	err := lmdbenv.View(env, func(txn *lmdb.Txn) error {
+		msg.Meta.TimestampNano = uint64(header.TimestampFromTime(time.Now()))
+		msg.Meta.LmdbTxnID = int64(txn.ID())
+
+		dbiNames, err := lmdbenv.ReadDBINames(txn)
+		if err != nil {
+			return err
+		}
+		for _, dbiName := range dbiNames {
+			if strings.HasPrefix(dbiName, SyncDBIPrefix) {
+				continue // skip our own special dbs
+			}
+
+			readDBIName := dbiName
+			if !schemaTracksChanges {
+				readDBIName = SyncDBIShadowPrefix + dbiName
+				exists, err := lmdbenv.DBIExists(txn, readDBIName)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					s.l.WithField("dbi", dbiName).Warn("No shadow DBI found, skipping")
+					continue
+				}
+			}
+			dbiMsg, err := s.readDBI(txn, readDBIName, dbiName, false)
+			if err != nil {
+				return fmt.Errorf("dbi %s: %w", dbiName, err)
+			}
+			msg.Databases = append(msg.Databases, dbiMsg)
+
+			if utils.IsCanceled(ctx) {
+				return context.Canceled
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
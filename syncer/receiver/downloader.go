@@ -21,6 +21,24 @@ type Downloader struct {
 
 	// for signaling new work
 	newSnapshotSignal chan struct{}
+
+	// Download state for the status API, protected by r.mu
+	state   string
+	lastErr error
+}
+
+// Download states of a Downloader
+const (
+	DownloadIdle        = "idle"
+	DownloadDownloading = "downloading"
+	DownloadFailed      = "failed"
+)
+
+func (d *Downloader) setState(state string, err error) {
+	d.r.mu.Lock()
+	defer d.r.mu.Unlock()
+	d.state = state
+	d.lastErr = err
 }
 
 // NotifyNewSnapshot notifies the downloader that a new snapshot is available.
@@ -69,7 +87,9 @@ func (d *Downloader) Run(ctx context.Context) error {
 			}
 
 			// Do one load attempt
+			d.setState(DownloadDownloading, nil)
 			if err := d.LoadOnce(ctx, ni); err != nil {
+				d.setState(DownloadFailed, err)
 				d.l.WithError(err).WithField("filename", ni.FullName).Warn("Load error")
 				if err := utils.SleepContext(ctx, d.c.StorageRetryInterval); err != nil {
 					return err // cancelled
@@ -79,6 +99,7 @@ func (d *Downloader) Run(ctx context.Context) error {
 
 			// Mark this as the last processed one
 			d.last = ni
+			d.setState(DownloadIdle, nil)
 			break // success
 		}
 	}
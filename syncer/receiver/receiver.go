@@ -2,6 +2,7 @@ package receiver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -84,6 +85,7 @@ type Receiver struct {
 	mu                    sync.Mutex
 	snapshotsByInstance   map[string]snapshot.Update
 	lastSeenByInstance    map[string]snapshot.NameInfo
+	lastSeenSize          map[string]int64
 	downloadersByInstance map[string]*Downloader
 	hasSnapshots          bool
 	corruptSnapshots      map[string]error
@@ -148,6 +150,61 @@ func (r *Receiver) SeenInstances() (names []string) {
 	return names
 }
 
+// State is a summary of the receiver state for the status API
+type State struct {
+	HasSnapshots       bool                         // Any snapshots seen for this LMDB
+	ReadyUpdates       int                          // Downloaded snapshots waiting to be loaded
+	Downloaders        int                          // Number of per-instance downloaders
+	LastSeenByInstance map[string]snapshot.NameInfo // Latest snapshot seen by instance
+	LastSeenSize       map[string]int64             // Size of the latest snapshot by instance
+	Downloads          map[string]DownloadState     // Download state by instance
+	CorruptSnapshots   map[string]string            // Errors of corrupt snapshots by filename
+}
+
+// DownloadState is the download state of the latest snapshot of an instance
+type DownloadState struct {
+	State string // "ready" if waiting to be loaded, or a Downloader state
+	Error string // Last download error if the state is DownloadFailed
+}
+
+// DownloadReady is the state of a downloaded snapshot waiting to be loaded
+const DownloadReady = "ready"
+
+// State returns a copy of the current receiver state
+func (r *Receiver) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := State{
+		HasSnapshots:       r.hasSnapshots,
+		ReadyUpdates:       len(r.snapshotsByInstance),
+		Downloaders:        len(r.downloadersByInstance),
+		LastSeenByInstance: make(map[string]snapshot.NameInfo, len(r.lastSeenByInstance)),
+		LastSeenSize:       make(map[string]int64, len(r.lastSeenSize)),
+		Downloads:          make(map[string]DownloadState, len(r.downloadersByInstance)),
+		CorruptSnapshots:   make(map[string]string, len(r.corruptSnapshots)),
+	}
+	for inst, ni := range r.lastSeenByInstance {
+		st.LastSeenByInstance[inst] = ni
+	}
+	for inst, size := range r.lastSeenSize {
+		st.LastSeenSize[inst] = size
+	}
+	for inst, d := range r.downloadersByInstance {
+		ds := DownloadState{State: d.state}
+		if d.lastErr != nil {
+			ds.Error = d.lastErr.Error()
+		}
+		if _, ready := r.snapshotsByInstance[inst]; ready {
+			ds.State = DownloadReady
+		}
+		st.Downloads[inst] = ds
+	}
+	for filename, err := range r.corruptSnapshots {
+		st.CorruptSnapshots[filename] = err.Error()
+	}
+	return st
+}
+
 // MarkCorrupt marks a snapshot as corrupt.
 // We will ignore the filename in the future. This can cause a previous
 // snapshot to be promoted to the latest for an instance, or for the instance
@@ -163,6 +220,29 @@ func (r *Receiver) MarkCorrupt(filename string, err error) {
 		"Snapshot marked as corrupt and will be ignored")
 }
 
+// ErrIgnored is the reason recorded for snapshots ignored with Ignore
+var ErrIgnored = errors.New("ignored by admin request")
+
+// Ignore makes the receiver ignore a snapshot from now on, like a corrupt
+// snapshot. This can be used to skip a snapshot that cannot be loaded.
+// If the snapshot was already downloaded, it is discarded.
+func (r *Receiver) Ignore(filename string) {
+	r.MarkCorrupt(filename, ErrIgnored)
+
+	r.mu.Lock()
+	var discarded []snapshot.Update
+	for inst, u := range r.snapshotsByInstance {
+		if u.NameInfo.FullName == filename {
+			discarded = append(discarded, u)
+			delete(r.snapshotsByInstance, inst)
+		}
+	}
+	r.mu.Unlock()
+	for _, u := range discarded {
+		u.Close() // releases the DecompressedSnapshotToken
+	}
+}
+
 func (r *Receiver) Run(ctx context.Context) error {
 	for {
 		if err := r.RunOnce(ctx, false); err != nil {
@@ -202,8 +282,6 @@ func (r *Receiver) RunOnce(ctx context.Context, includingOwn bool) error {
 	// Signal success to health tracker
 	r.storageListHealth.AddSuccess()
 
-	names := ls.Names()
-
 	// Update ignoredFilenames from corruptSnapshots.
 	// Under normal circumstances the corruptSnapshots map should always be empty.
 	r.mu.Lock()
@@ -216,7 +294,9 @@ func (r *Receiver) RunOnce(ctx context.Context, includingOwn bool) error {
 	// Note that this always includes our own instance, even if includingOwn is false,
 	// which is important during startup in the sync loop.
 	lastSeenByInstance := make(map[string]snapshot.NameInfo)
-	for _, name := range names {
+	lastSeenSize := make(map[string]int64)
+	for _, blob := range ls {
+		name := blob.Name
 		if r.ignoredFilenames[name] {
 			//r.l.WithField("filename", name).Debug("Ignored")
 			continue
@@ -236,6 +316,7 @@ func (r *Receiver) RunOnce(ctx context.Context, includingOwn bool) error {
 		// Since the names are sorted alphabetically, this newer one will
 		// always overwrite older ones.
 		lastSeenByInstance[ni.InstanceID] = ni
+		lastSeenSize[ni.InstanceID] = blob.Size
 	}
 
 	now := time.Now()
@@ -248,6 +329,7 @@ func (r *Receiver) RunOnce(ctx context.Context, includingOwn bool) error {
 	// This map is read by the Downloader.
 	r.mu.Lock()
 	r.lastSeenByInstance = lastSeenByInstance
+	r.lastSeenSize = lastSeenSize
 	r.hasSnapshots = len(lastSeenByInstance) > 0
 	r.mu.Unlock()
 
@@ -307,6 +389,7 @@ func (r *Receiver) getDownloader(ctx context.Context, instance string) *Download
 		lmdbname:          r.lmdbname,
 		last:              snapshot.NameInfo{},
 		newSnapshotSignal: make(chan struct{}, 1),
+		state:             DownloadIdle,
 	}
 
 	go func() {
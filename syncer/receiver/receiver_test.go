@@ -84,3 +84,47 @@ func TestReceiver(t *testing.T) {
 	inst, _ = r.Next()
 	assert.Equal(t, "", inst)
 }
+
+func TestReceiver_Ignore(t *testing.T) {
+	ctx := t.Context()
+
+	st := memory.New()
+	r := New(st, config.Config{
+		StoragePollInterval:         10 * time.Millisecond,
+		MemoryDownloadedSnapshots:   2,
+		MemoryDecompressedSnapshots: 2,
+	}, "test", logrus.New(), "self", events.New(), hooks.New())
+	go func() {
+		err := r.Run(ctx)
+		if err != nil && err != context.Canceled {
+			assert.NoError(t, err)
+		}
+	}()
+
+	name := snapshot.Name("test", "other", "G-0", time.Now())
+	err := st.Store(ctx, name, emptySnapshot())
+	assert.NoError(t, err)
+
+	// Wait until it is downloaded and ready to load
+	ready := false
+	for range 50 {
+		time.Sleep(20 * time.Millisecond)
+		if r.State().Downloads["other"].State == DownloadReady {
+			ready = true
+			break
+		}
+	}
+	assert.True(t, ready, "snapshot not downloaded")
+
+	// Ignoring it discards the downloaded snapshot
+	r.Ignore(name)
+	inst, _ := r.Next()
+	assert.Equal(t, "", inst)
+	state := r.State()
+	assert.Equal(t, ErrIgnored.Error(), state.CorruptSnapshots[name])
+
+	// And it is no longer seen after the next listing
+	time.Sleep(50 * time.Millisecond)
+	_, seen := r.State().LastSeenByInstance["other"]
+	assert.False(t, seen)
+}
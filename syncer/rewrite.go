@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/sirupsen/logrus"
+)
+
+// checkRewrites checks if all rewrite functions referenced by the
+// configuration have been registered.
+func checkRewrites(rewrites []config.Rewrite) error {
+	for i, rw := range rewrites {
+		if rw.Func == "" {
+			continue
+		}
+		if _, ok := snapshot.GetRewrite(rw.Func); !ok {
+			return fmt.Errorf("rewrites[%d]: rewrite func %q not registered (available: %v)",
+				i, rw.Func, snapshot.RegisteredRewrites())
+		}
+	}
+	return nil
+}
+
+// rewritePath returns the rewrite rules to apply, in order, to get from the
+// remote schema version to the local schema version.
+// At every step, the rules with the nearest ToVersion in the direction of
+// the local version are selected. The path ends when the local version is
+// reached, or when no more rules apply.
+// Unversioned data (version 0) is never rewritten.
+func rewritePath(rewrites []config.Rewrite, remote, local uint32) []config.Rewrite {
+	if remote == 0 || local == 0 {
+		return nil
+	}
+	between := func(v, from, to uint32) bool {
+		if from < to {
+			return from < v && v <= to
+		}
+		return to <= v && v < from
+	}
+	var path []config.Rewrite
+	v := remote
+	for v != local {
+		var next uint32
+		for _, rw := range rewrites {
+			if rw.FromVersion != v || !between(rw.ToVersion, v, local) {
+				continue
+			}
+			if next == 0 || between(rw.ToVersion, v, next) {
+				next = rw.ToVersion
+			}
+		}
+		if next == 0 {
+			break // no rules to get closer
+		}
+		for _, rw := range rewrites {
+			if rw.FromVersion == v && rw.ToVersion == next {
+				path = append(path, rw)
+			}
+		}
+		v = next
+	}
+	return path
+}
+
+// rewriteDatabases applies the configured rewrite rules to the DBIs of a
+// snapshot written with a different schema version, and returns the resulting
+// DBIs. The snapshot itself is not modified.
+func (s *Syncer) rewriteDatabases(snap *snapshot.Snapshot, l logrus.FieldLogger) ([]*snapshot.DBI, error) {
+	dbis := snap.Databases
+	path := rewritePath(s.lc.Rewrites, snap.Meta.SchemaVersion, s.lc.SchemaVersion)
+	for _, rw := range path {
+		var f snapshot.RewriteFunc
+		if rw.Func != "" {
+			var ok bool
+			f, ok = snapshot.GetRewrite(rw.Func)
+			if !ok {
+				return nil, fmt.Errorf("rewrite func %q not registered", rw.Func)
+			}
+		}
+		var out []*snapshot.DBI
+		for _, dbiMsg := range dbis {
+			if dbiMsg.Name() != rw.DBI {
+				out = append(out, dbiMsg)
+				continue
+			}
+			l.WithFields(logrus.Fields{
+				"dbi":          rw.DBI,
+				"from_version": rw.FromVersion,
+				"to_version":   rw.ToVersion,
+				"func":         rw.Func,
+				"rename_to":    rw.RenameTo,
+				"drop":         rw.Drop,
+			}).Debug("Applying rewrite to snapshot DBI")
+			if rw.Drop {
+				continue
+			}
+			name := rw.DBI
+			if rw.RenameTo != "" {
+				name = rw.RenameTo
+			}
+			newDBI, err := dbiMsg.Rewrite(name, f)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite dbi %s (%d -> %d): %w",
+					rw.DBI, rw.FromVersion, rw.ToVersion, err)
+			}
+			out = append(out, newDBI)
+		}
+		// A DBI renamed to the name of another DBI in the snapshot would
+		// merge both into the same target DBI.
+		if rw.RenameTo != "" {
+			n := 0
+			for _, dbiMsg := range out {
+				if dbiMsg.Name() == rw.RenameTo {
+					n++
+				}
+			}
+			if n > 1 {
+				return nil, fmt.Errorf("rewrite dbi %s (%d -> %d): rename_to %q "+
+					"is also the name of another dbi in the snapshot",
+					rw.DBI, rw.FromVersion, rw.ToVersion, rw.RenameTo)
+			}
+		}
+		dbis = out
+	}
+	return dbis, nil
+}
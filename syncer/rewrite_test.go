@@ -0,0 +1,102 @@
+package syncer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	snapshot.RegisterRewrite("test_upper_prefix", func(kv snapshot.KV) (snapshot.KV, bool, error) {
+		if bytes.HasPrefix(kv.Key, []byte("drop")) {
+			return kv, false, nil
+		}
+		kv.Key = append([]byte("V2:"), kv.Key...)
+		return kv, true, nil
+	})
+}
+
+func Test_rewritePath(t *testing.T) {
+	r := func(from, to uint32) config.Rewrite {
+		return config.Rewrite{DBI: "foo", FromVersion: from, ToVersion: to, Drop: true}
+	}
+	rewrites := []config.Rewrite{r(1, 2), r(2, 3), r(1, 3), r(3, 2), r(2, 1), r(3, 4)}
+
+	tests := []struct {
+		name          string
+		remote, local uint32
+		want          []config.Rewrite
+	}{
+		{"same version", 2, 2, nil},
+		{"unversioned remote", 0, 2, nil},
+		{"unversioned local", 2, 0, nil},
+		{"single step", 2, 3, []config.Rewrite{r(2, 3)}},
+		{"nearest step first", 1, 3, []config.Rewrite{r(1, 2), r(2, 3)}},
+		{"downgrade", 3, 1, []config.Rewrite{r(3, 2), r(2, 1)}},
+		{"no overshoot", 3, 5, []config.Rewrite{r(3, 4)}},
+		{"no rules", 5, 6, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rewritePath(rewrites, tt.remote, tt.local))
+		})
+	}
+}
+
+func TestSyncer_rewriteDatabases(t *testing.T) {
+	lc := config.LMDB{
+		SchemaVersion: 2,
+		Rewrites: []config.Rewrite{
+			{DBI: "foo", FromVersion: 1, ToVersion: 2, Func: "test_upper_prefix", RenameTo: "foo_v2"},
+			{DBI: "old", FromVersion: 1, ToVersion: 2, Drop: true},
+		},
+	}
+	s := &Syncer{lc: lc, l: logrus.New()}
+
+	foo := snapshot.NewDBI()
+	foo.SetName("foo")
+	foo.SetFlags(8)
+	foo.Append(snapshot.KV{Key: b("a"), Value: b("x"), TimestampNano: 10})
+	foo.Append(snapshot.KV{Key: b("drop-me"), Value: b("y"), TimestampNano: 11})
+	old := snapshot.NewDBI()
+	old.SetName("old")
+	other := snapshot.NewDBI()
+	other.SetName("other")
+	snap := &snapshot.Snapshot{Databases: []*snapshot.DBI{foo, old, other}}
+
+	// Same version: unmodified
+	snap.Meta.SchemaVersion = 2
+	dbis, err := s.rewriteDatabases(snap, s.l)
+	require.NoError(t, err)
+	assert.Equal(t, snap.Databases, dbis)
+
+	// Older version: rewritten
+	snap.Meta.SchemaVersion = 1
+	dbis, err = s.rewriteDatabases(snap, s.l)
+	require.NoError(t, err)
+	require.Len(t, dbis, 2)
+	assert.Equal(t, "foo_v2", dbis[0].Name())
+	assert.Equal(t, uint64(8), dbis[0].Flags())
+	kvs, err := dbis[0].AsInefficientKVList()
+	require.NoError(t, err)
+	assert.Equal(t, []snapshot.KV{{Key: b("V2:a"), Value: b("x"), TimestampNano: 10}}, kvs)
+	assert.Equal(t, "other", dbis[1].Name())
+
+	// Renaming to a DBI that exists in the snapshot is rejected
+	fooV2 := snapshot.NewDBI()
+	fooV2.SetName("foo_v2")
+	snap.Databases = append(snap.Databases, fooV2)
+	_, err = s.rewriteDatabases(snap, s.l)
+	assert.ErrorContains(t, err, `rename_to "foo_v2" is also the name of another dbi`)
+
+	// Unregistered functions are rejected
+	err = checkRewrites([]config.Rewrite{{Func: "does-not-exist"}})
+	assert.Error(t, err)
+	err = checkRewrites(lc.Rewrites)
+	assert.NoError(t, err)
+}
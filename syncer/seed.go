@@ -0,0 +1,309 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/utils"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob"
+	"github.com/klauspost/compress/gzip"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// KindSeed is the kind of seed images, compacted copies of the LMDB data
+	// file that new instances can install instead of loading snapshots.
+	KindSeed = "seed"
+	// SeedExtension is the file extension of seed images
+	SeedExtension = "seed.mdb.gz"
+)
+
+func init() {
+	// Registering the extension allows ParseName to recognize seed images.
+	// The receiver and cleaner only handle snapshots and ignore these.
+	snapshot.RegisterExtension(SeedExtension, KindSeed)
+}
+
+// PublishSeed stores a seed image of the LMDB in the storage and removes
+// older seed images of this instance beyond the configured number to keep.
+// The compacted copy is made with a read transaction in a temporary file.
+func (s *Syncer) PublishSeed(ctx context.Context, env *lmdb.Env) (name string, err error) {
+	t0 := time.Now()
+	tmpDir, err := os.MkdirTemp("", "lightningstream-seed-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	copyPath := filepath.Join(tmpDir, "data.mdb")
+	if err := lmdbenv.CompactCopy(env, copyPath, 0600); err != nil {
+		return "", err
+	}
+
+	name = snapshot.NameInfo{
+		SyncerName:   s.name,
+		InstanceID:   s.instanceID(),
+		GenerationID: s.generationID(),
+		Timestamp:    t0,
+		Extension:    SeedExtension,
+	}.BuildName()
+	if err := s.storeSeed(ctx, name, copyPath); err != nil {
+		return "", fmt.Errorf("store seed %s: %w", name, err)
+	}
+	s.l.WithFields(logrus.Fields{
+		"name":       name,
+		"time_total": time.Since(t0).Round(time.Millisecond),
+	}).Info("Published seed image")
+	metricSeedsPublished.WithLabelValues(s.name).Inc()
+
+	if err := s.removeOldSeeds(ctx); err != nil {
+		s.l.WithError(err).Warn("Failed to remove old seed images")
+	}
+	return name, nil
+}
+
+// storeSeed stores the gzip compressed LMDB copy at path under name
+func (s *Syncer) storeSeed(ctx context.Context, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	w, err := simpleblob.NewWriter(ctx, s.st, name)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, f); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// removeOldSeeds removes all but the latest seed images of this instance
+func (s *Syncer) removeOldSeeds(ctx context.Context) error {
+	seeds, err := listSeeds(ctx, s.st, s.name)
+	if err != nil {
+		return err
+	}
+	var own []snapshot.NameInfo
+	for _, ni := range seeds {
+		if ni.InstanceID == s.instanceID() {
+			own = append(own, ni)
+		}
+	}
+	keep := s.lc.Seed.KeepCount()
+	if len(own) <= keep {
+		return nil
+	}
+	for _, ni := range own[:len(own)-keep] {
+		if err := s.st.Delete(ctx, ni.FullName); err != nil {
+			return fmt.Errorf("delete seed %s: %w", ni.FullName, err)
+		}
+		s.l.WithField("name", ni.FullName).Info("Removed old seed image")
+	}
+	return nil
+}
+
+// runSeedPublisher periodically publishes a seed image, starting when ready
+// is closed. Failures are logged and retried at the next interval.
+func (s *Syncer) runSeedPublisher(ctx context.Context, env *lmdb.Env, ready <-chan struct{}) error {
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for {
+		if _, err := s.PublishSeed(ctx, env); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			s.l.WithError(err).Error("Failed to publish seed image")
+			metricSeedsPublishFailed.WithLabelValues(s.name).Inc()
+		}
+		if err := utils.SleepContext(ctx, s.lc.Seed.PublishInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// listSeeds returns all seed images for the LMDB with the given name in
+// storage, ordered by name, which orders the seeds of an instance by time.
+func listSeeds(ctx context.Context, st simpleblob.Interface, name string) ([]snapshot.NameInfo, error) {
+	ls, err := st.List(ctx, name+"__")
+	if err != nil {
+		return nil, fmt.Errorf("list seeds: %w", err)
+	}
+	var seeds []snapshot.NameInfo
+	for _, fn := range ls.Names() {
+		ni, err := snapshot.ParseName(fn)
+		if err != nil || ni.Kind != KindSeed {
+			continue
+		}
+		seeds = append(seeds, ni)
+	}
+	return seeds, nil
+}
+
+// BootstrapSeed installs the latest seed image from storage as the data file
+// of the LMDB, if the LMDB is empty and not opened by any other process.
+// This must be called before the LMDB is opened for syncing. The instance is
+// expected to catch up by loading snapshots afterwards.
+// It returns true if a seed image was installed.
+func BootstrapSeed(ctx context.Context, l logrus.FieldLogger, st simpleblob.Interface, name string, lc config.LMDB) (installed bool, err error) {
+	env, err := OpenEnv(l, lc)
+	if err != nil {
+		return false, err
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			_ = env.Close()
+		}
+	}()
+
+	empty, err := isEmptyEnv(env)
+	if err != nil {
+		return false, err
+	}
+	if !empty {
+		l.Debug("Not bootstrapping from seed image, because the LMDB is not empty")
+		return false, nil
+	}
+	if inUse, err := lmdbenv.InUseByOtherProcess(env); err != nil || inUse {
+		l.WithError(err).Warn("Not bootstrapping from seed image, because the " +
+			"LMDB could be open in another process")
+		return false, nil
+	}
+
+	seeds, err := listSeeds(ctx, st, name)
+	if err != nil {
+		return false, err
+	}
+	if len(seeds) == 0 {
+		l.Info("No seed image found, loading snapshots instead")
+		return false, nil
+	}
+	// Names are only ordered by time per instance
+	latest := seeds[0]
+	for _, ni := range seeds[1:] {
+		if ni.Timestamp.After(latest.Timestamp) {
+			latest = ni
+		}
+	}
+
+	dataPath, err := lmdbenv.DataPath(env)
+	if err != nil {
+		return false, err
+	}
+	t0 := time.Now()
+	l = l.WithField("seed", latest.FullName)
+	l.Info("Downloading seed image")
+	seedPath := dataPath + ".seed"
+	fileMask := lc.Options.WithDefaults().FileMask
+	if err := downloadSeed(ctx, st, latest.FullName, seedPath, fileMask); err != nil {
+		return false, fmt.Errorf("download seed %s: %w", latest.FullName, err)
+	}
+	defer func() {
+		_ = os.Remove(seedPath) // only exists if we did not swap it in
+	}()
+
+	// Check again right before we close the env, the application could
+	// have started in the meantime. Our env is still open here, so the lock
+	// file must stay open, see lmdbenv.InUseByOtherProcess.
+	if inUse, err := lmdbenv.InUseByOtherProcess(env); err != nil || inUse {
+		l.WithError(err).Warn("Not bootstrapping from seed image, because the " +
+			"LMDB was opened by another process")
+		return false, nil
+	}
+	closed = true
+	if err := env.Close(); err != nil {
+		return false, err
+	}
+	if err := lmdbenv.SwapDataFile(dataPath, seedPath, ""); err != nil {
+		return false, err
+	}
+	l.WithFields(logrus.Fields{
+		"seed_instance": latest.InstanceID,
+		"seed_age":      time.Since(latest.Timestamp).Round(time.Second),
+		"time_total":    time.Since(t0).Round(time.Millisecond),
+	}).Info("Installed seed image, catching up from snapshots")
+	return true, nil
+}
+
+// downloadSeed downloads and decompresses a seed image to a new file at path
+func downloadSeed(ctx context.Context, st simpleblob.Interface, name, path string, mode os.FileMode) error {
+	_ = os.Remove(path) // leftover from an earlier attempt
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r, err := simpleblob.NewReader(ctx, st, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, gr); err != nil {
+		return err
+	}
+	if err := gr.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isEmptyEnv returns true if none of the DBIs in the env contain any entries
+func isEmptyEnv(env *lmdb.Env) (empty bool, err error) {
+	err = lmdbenv.View(env, func(txn *lmdb.Txn) error {
+		names, err := lmdbenv.ReadDBINames(txn)
+		if err != nil {
+			return err
+		}
+		for _, dbiName := range names {
+			dbi, err := txn.OpenDBI(dbiName, 0)
+			if err != nil {
+				return fmt.Errorf("open dbi %s: %w", dbiName, err)
+			}
+			stat, err := txn.Stat(dbi)
+			if err != nil {
+				return fmt.Errorf("stat dbi %s: %w", dbiName, err)
+			}
+			if stat.Entries > 0 {
+				return nil
+			}
+		}
+		empty = true
+		return nil
+	})
+	return empty, err
+}
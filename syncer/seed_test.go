@@ -0,0 +1,164 @@
+package syncer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_PublishSeed_BootstrapSeed(t *testing.T) {
+	ctx := context.Background()
+	st := memory.New()
+	l := logrus.New()
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		err := env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte("key"), []byte("val"), 0)
+		})
+		require.NoError(t, err)
+
+		lc := config.LMDB{Seed: config.Seed{Keep: 1}}
+		s, err := New("test", env, st, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		name1, err := s.PublishSeed(ctx, env)
+		require.NoError(t, err)
+		name2, err := s.PublishSeed(ctx, env)
+		require.NoError(t, err)
+
+		// Only the latest seed is kept
+		seeds, err := listSeeds(ctx, st, "test")
+		require.NoError(t, err)
+		require.Len(t, seeds, 1)
+		assert.Equal(t, name2, seeds[0].FullName)
+		assert.NotEqual(t, name1, name2)
+		return nil
+	})
+	require.NoError(t, err)
+
+	newLC := config.LMDB{
+		Path: t.TempDir(),
+		Options: lmdbenv.Options{
+			Create: true,
+			MaxDBs: 10,
+		},
+	}
+	installed, err := BootstrapSeed(ctx, l, st, "test", newLC)
+	require.NoError(t, err)
+	assert.True(t, installed)
+
+	env, err := OpenEnv(l, newLC)
+	require.NoError(t, err)
+	err = env.View(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("foo", 0)
+		if err != nil {
+			return err
+		}
+		val, err := txn.Get(dbi, []byte("key"))
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, "val", string(val))
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, env.Close())
+
+	// Not installed again, because the LMDB is no longer empty
+	installed, err = BootstrapSeed(ctx, l, st, "test", newLC)
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	// Nothing to install without seeds
+	installed, err = BootstrapSeed(ctx, l, memory.New(), "test", config.LMDB{
+		Path:    t.TempDir(),
+		Options: newLC.Options,
+	})
+	require.NoError(t, err)
+	assert.False(t, installed)
+}
+
+const holdEnvVar = "SYNCER_TEST_HOLD_ENV"
+
+// TestSyncer_BootstrapSeed_inUse checks that a seed image is not installed
+// while another process has the LMDB open.
+func TestSyncer_BootstrapSeed_inUse(t *testing.T) {
+	if path := os.Getenv(holdEnvVar); path != "" {
+		// Child process that keeps the LMDB open until stdin is closed
+		env, err := lmdbenv.New(path, 0)
+		require.NoError(t, err)
+		fmt.Println("ready")
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		require.NoError(t, env.Close())
+		return
+	}
+
+	ctx := context.Background()
+	st := memory.New()
+	l := logrus.New()
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		err := env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte("key"), []byte("val"), 0)
+		})
+		require.NoError(t, err)
+		s, err := New("test", env, st, config.Config{}, config.LMDB{}, Options{})
+		require.NoError(t, err)
+		_, err = s.PublishSeed(ctx, env)
+		return err
+	})
+	require.NoError(t, err)
+
+	lc := config.LMDB{
+		Path: t.TempDir(),
+		Options: lmdbenv.Options{
+			Create: true,
+			MaxDBs: 10,
+		},
+	}
+	env, err := OpenEnv(l, lc)
+	require.NoError(t, err)
+	require.NoError(t, env.Close())
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSyncer_BootstrapSeed_inUse$")
+	cmd.Env = append(os.Environ(), holdEnvVar+"="+lc.Path)
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "ready\n", line)
+
+	// Both checks run while our own env is open, which must not release
+	// our lock or hide the lock of the other process.
+	installed, err := BootstrapSeed(ctx, l, st, "test", lc)
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	require.NoError(t, stdin.Close())
+	require.NoError(t, cmd.Wait())
+	installed, err = BootstrapSeed(ctx, l, st, "test", lc)
+	require.NoError(t, err)
+	assert.True(t, installed)
+}
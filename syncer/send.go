@@ -11,10 +11,12 @@ import (
 	"github.com/PowerDNS/lightningstream/snapshot"
 	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/lightningstream/syncer/hooks"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/c2h5oh/datasize"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 )
 
 func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnID, err error) {
@@ -25,9 +27,18 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 	msg.Meta.Hostname = hostname
 	msg.Meta.InstanceID = s.instanceID()
 	msg.Meta.GenerationID = s.generationID()
+	msg.Meta.SchemaVersion = s.lc.SchemaVersion
 
 	t0 := time.Now() // for performance measurements
 
+	ctx, span := tracing.Start(ctx, "SendOnce",
+		tracing.AttrDB.String(s.name),
+		tracing.AttrInstance.String(s.instanceID()))
+	defer func() {
+		span.SetAttributes(tracing.AttrTxnID.Int64(int64(txnID)))
+		tracing.End(span, err)
+	}()
+
 	// Snapshot timestamp determined within transaction
 	var ts time.Time
 
@@ -39,10 +50,10 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 	txnRawRead := false
 	var inTxn func(lmdb.TxnOp) error
 	if schemaTracksChanges {
-		inTxn = env.View
+		inTxn = func(fn lmdb.TxnOp) error { return lmdbenv.View(env, fn) }
 		txnRawRead = true // []byte will point directly into LMDB, potentially unsafe
 	} else {
-		inTxn = env.Update
+		inTxn = func(fn lmdb.TxnOp) error { return lmdbenv.Update(env, fn) }
 	}
 
 	err = inTxn(func(txn *lmdb.Txn) error {
@@ -79,6 +90,7 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 		// If we update, it may be higher than from Info
 		txnID = header.TxnID(txn.ID())
 		s.l.WithField("txnID", txnID).Debug("Started dump of transaction")
+		span.AddEvent("txn acquired")
 
 		// First update the shadow dbs
 		if !schemaTracksChanges {
@@ -88,6 +100,7 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 			}
 		}
 		tShadow = time.Now()
+		span.AddEvent("shadow synced")
 
 		// Skip the data dump if we are not going to send it anyway
 		if s.opt.ReceiveOnly {
@@ -100,6 +113,19 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 			return err
 		}
 
+		// Read the native DBIs in parallel if enabled. This is only possible
+		// for native DBIs, because changes to the shadow DBIs made in this
+		// write transaction are not visible to other transactions.
+		workers := s.snapshotWorkers()
+		if schemaTracksChanges && workers > 1 && s.hooks.FilterReadDBI == nil {
+			dbiMsgs, err := s.readDBIsParallel(ctx, env, txn, dbiNames, workers)
+			if err != nil {
+				return err
+			}
+			msg.Databases = append(msg.Databases, dbiMsgs...)
+			return nil
+		}
+
 		// Dump all DBIs using their shadow db
 		for _, dbiName := range dbiNames {
 			if strings.HasPrefix(dbiName, SyncDBIPrefix) {
@@ -128,6 +154,7 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 		return 0, err
 	}
 	tDumped := time.Now()
+	span.AddEvent("dumped")
 
 	// If no actual changes were made, LMDB will not record the transaction
 	// and reuse the ID the next time, so we need to adjust the txnID we return.
@@ -136,7 +163,7 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 	// In such case there is a race present, where the application could have
 	// written new data under the txnID we think we have written.
 	// TODO: Further investigate this non-native potential race
-	info, err := env.Info()
+	info, err := lmdbenv.Info(env)
 	if err != nil {
 		return 0, err
 	}
@@ -174,21 +201,41 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 		}
 	}
 	name := ni.BuildName()
+	ni.FullName = name
+	span.SetAttributes(tracing.AttrSnapshotName.String(name))
+
+	// Record a digest for every DBI, so that receivers can skip DBIs that
+	// did not change since the last snapshot they loaded from us.
+	for _, dbiMsg := range msg.Databases {
+		msg.Meta.DBIDigests = append(msg.Meta.DBIDigests, snapshot.DBIDigest{
+			Name:   dbiMsg.Name(),
+			SHA256: dbiMsg.Digest(),
+		})
+	}
 
 	// Compress the snapshot and release memory
-	out, dds, err := snapshot.DumpData(msg)
+	out, dds, err := snapshot.DumpDataParallel(msg, s.snapshotWorkers())
 	if err != nil {
 		return 0, err
 	}
 	tDumpedData := time.Now()
+	span.SetAttributes(tracing.AttrSnapshotSize.Int(len(out)))
+	span.AddEvent("compressed")
 
 	meta := msg.Meta // keep a copy of metadata for events
-	msg = nil        // no longer needed
+	dbiEntries := make(map[string]int64, len(msg.Databases))
+	for _, dbiMsg := range msg.Databases {
+		dbiEntries[dbiMsg.Name()] = dbiMsg.NumWrittenEntries
+	}
+	msg = nil // no longer needed
 	timeGC := utils.GC()
 
 	metricSnapshotsLoaded.WithLabelValues(s.name).Inc()
 	metricSnapshotsLastTimestamp.WithLabelValues(s.name).Set(float64(ts.UnixNano()) / 1e9)
 	metricSnapshotsLastSize.WithLabelValues(s.name).Set(float64(len(out)))
+	for dbiName, n := range dbiEntries {
+		metricSnapshotDBIEntries.WithLabelValues(s.name, dbiName).Set(float64(n))
+	}
 
 	// Send it to storage
 	for i := 0; i < s.c.StorageRetryCount || s.c.StorageRetryForever; i++ {
@@ -211,8 +258,10 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 
 		// UpdateStored hook and event
 		updateInfo := events.UpdateInfo{
-			NameInfo: ni,
-			Meta:     meta,
+			NameInfo:   ni,
+			Meta:       meta,
+			Size:       int64(len(out)),
+			DBIEntries: dbiEntries,
 		}
 		if s.hooks.UpdateStored != nil {
 			err := s.hooks.UpdateStored(updateInfo)
@@ -266,3 +315,71 @@ func (s *Syncer) SendOnce(ctx context.Context, env *lmdb.Env) (txnID header.TxnI
 
 	return txnID, nil
 }
+
+// snapshotWorkers returns the number of workers to use for creating a snapshot
+func (s *Syncer) snapshotWorkers() int {
+	if s.c.SnapshotWorkers < 1 {
+		return 1
+	}
+	return s.c.SnapshotWorkers
+}
+
+// readDBIsParallel reads the native DBIs with up to the given number of
+// concurrent read transactions. A worker transaction is only used if it has
+// the same ID as txn, which guarantees that it sees exactly the same data.
+// Any DBI that could not be read by a worker, because a new transaction was
+// committed in the meantime, is read with txn afterwards.
+// LMDB transactions are bound to an OS thread, so txn is not shared with the
+// workers.
+func (s *Syncer) readDBIsParallel(ctx context.Context, env *lmdb.Env, txn *lmdb.Txn, dbiNames []string, workers int) ([]*snapshot.DBI, error) {
+	txnID := txn.ID()
+	var names []string
+	for _, dbiName := range dbiNames {
+		if strings.HasPrefix(dbiName, SyncDBIPrefix) {
+			continue // skip our own special dbs
+		}
+		names = append(names, dbiName)
+	}
+
+	dbiMsgs := make([]*snapshot.DBI, len(names))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(workers)
+	for i, dbiName := range names {
+		eg.Go(func() error {
+			// The caller holds the shared lock in the txn we wait for
+			return lmdbenv.ViewUnlocked(env, func(wtxn *lmdb.Txn) error {
+				if wtxn.ID() != txnID {
+					return nil // read later with the original txn
+				}
+				if utils.IsCanceled(egCtx) {
+					return context.Canceled
+				}
+				dbiMsg, err := s.readDBI(wtxn, dbiName, dbiName, false)
+				if err != nil {
+					return fmt.Errorf("dbi %s: %w", dbiName, err)
+				}
+				dbiMsgs[i] = dbiMsg
+				return nil
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	for i, dbiName := range names {
+		if dbiMsgs[i] != nil {
+			continue
+		}
+		s.l.WithField("dbi", dbiName).Debug("Reading DBI with original txn, because txnID changed")
+		dbiMsg, err := s.readDBI(txn, dbiName, dbiName, false)
+		if err != nil {
+			return nil, fmt.Errorf("dbi %s: %w", dbiName, err)
+		}
+		dbiMsgs[i] = dbiMsg
+		if utils.IsCanceled(ctx) {
+			return nil, context.Canceled
+		}
+	}
+	return dbiMsgs, nil
+}
@@ -9,12 +9,102 @@ import (
 	"github.com/PowerDNS/lightningstream/config"
 	"github.com/PowerDNS/lightningstream/lmdbenv"
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/hooks"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/PowerDNS/simpleblob/backends/memory"
 	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestSyncer_SendOnce_parallel(t *testing.T) {
+	ts := testTS(1)
+	dbiNames := []string{"a", "b", "c", "d", "e"}
+
+	// loadStored loads the single snapshot in storage and returns its DBIs
+	loadStored := func(st *memory.Backend) map[string][]byte {
+		ctx := context.Background()
+		entries, err := st.List(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		data, err := st.Load(ctx, entries[0].Name)
+		require.NoError(t, err)
+		msg, err := snapshot.LoadData(data)
+		require.NoError(t, err)
+		dbis := make(map[string][]byte)
+		for _, dbiMsg := range msg.Databases {
+			dbis[dbiMsg.Name()] = dbiMsg.Marshal()
+		}
+		return dbis
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		lc := config.LMDB{SchemaTracksChanges: true}
+		err := env.Update(func(txn *lmdb.Txn) error {
+			for i, name := range dbiNames {
+				dbi, err := txn.OpenDBI(name, lmdb.Create)
+				require.NoError(t, err)
+				for j := 0; j < 1000*(i+1); j++ {
+					key := fmt.Appendf(nil, "key-%06d", j)
+					require.NoError(t, txn.Put(dbi, key, b(h(ts, 1, 0)+"val"), 0))
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		c := config.Config{StorageRetryCount: 1, SnapshotWorkers: 1}
+
+		// Serial reference snapshot
+		st := memory.New()
+		s, err := New("test", env, st, c, lc, Options{})
+		require.NoError(t, err)
+		_, err = s.SendOnce(ctx, env)
+		require.NoError(t, err)
+		expected := loadStored(st)
+		assert.Len(t, expected, len(dbiNames))
+
+		// Parallel snapshot, with a transaction committed after the main
+		// read transaction was opened, which forces the workers to fall back
+		// to the original transaction.
+		c.SnapshotWorkers = 4
+		st = memory.New()
+		hk := hooks.New()
+		hk.BeforeRead = func(p hooks.BeforeReadParams) error {
+			done := make(chan error)
+			go func() {
+				done <- env.Update(func(txn *lmdb.Txn) error {
+					dbi, err := txn.OpenDBI("c", 0)
+					require.NoError(t, err)
+					return txn.Put(dbi, b("new"), b(h(ts, 2, 0)+"val"), 0)
+				})
+			}()
+			return <-done
+		}
+		s, err = New("test", env, st, c, lc, Options{Hooks: hk})
+		require.NoError(t, err)
+		_, err = s.SendOnce(ctx, env)
+		require.NoError(t, err)
+		assert.Equal(t, expected, loadStored(st))
+
+		// Parallel snapshot that includes the new transaction
+		st = memory.New()
+		s, err = New("test", env, st, c, lc, Options{})
+		require.NoError(t, err)
+		_, err = s.SendOnce(ctx, env)
+		require.NoError(t, err)
+		got := loadStored(st)
+		assert.NotEqual(t, expected["c"], got["c"])
+		delete(expected, "c")
+		delete(got, "c")
+		assert.Equal(t, expected, got)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 func BenchmarkSyncer_SendOnce_native_100k(b *testing.B) {
 	doBenchmarkSyncerSendOnce(b, true, false)
 }
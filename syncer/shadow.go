@@ -1,15 +1,18 @@
 package syncer
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/dbiflags"
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/lmdbenv/strategy"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/sirupsen/logrus"
@@ -18,8 +21,14 @@ import (
 // mainToShadow syncs the current databases to shadow databases with timestamps.
 // The sync is unidirectional, the state of the main database determines which
 // keys will be present in the shadow database.
-func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.Timestamp) error {
+func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.Timestamp) (err error) {
 	t0 := time.Now()
+	ctx, span := tracing.Start(ctx, "mainToShadow",
+		tracing.AttrDB.String(s.name),
+		tracing.AttrTxnID.Int64(int64(txn.ID())))
+	defer func() {
+		tracing.End(span, err)
+	}()
 
 	// List of DBIs to dump
 	dbiNames, err := lmdbenv.ReadDBINames(txn)
@@ -31,11 +40,6 @@ func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.
 		if strings.HasPrefix(dbiName, SyncDBIPrefix) {
 			continue // skip shadow and other special databases
 		}
-		// raw dump, because main does not have timestamps
-		dbiMsg, err := s.readDBI(txn, dbiName, dbiName, true)
-		if err != nil {
-			return err
-		}
 
 		dbi, err := txn.OpenDBI(dbiName, 0)
 		if err != nil {
@@ -48,14 +52,29 @@ func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.
 		}
 
 		isDupSort := dbiFlags&lmdb.DupSort > 0
-		if isDupSort && !s.lc.DupSortHack {
-			return fmt.Errorf("mainToShadow: dupsort db %q found and dupsort_hack disabled", dbiName)
+		dupSortTransform := s.dupSortTransform()
+		if isDupSort && dupSortTransform == snapshot.TransformNone {
+			return fmt.Errorf("mainToShadow: dupsort db %q found and dupsort_hack and dupsort_set disabled", dbiName)
 		}
 
 		// If the DBI has MDB_INTEGERKEY set, our shadow db will use the same
 		var targetFlags = dbiFlags & uint(AllowedShadowDBIFlagsMask)
 
-		if s.lc.DupSortHack && isDupSort {
+		// The incremental sync merges directly from a cursor on the main DBI,
+		// so DupSort DBIs that need their values grouped and the read filter
+		// hook require a full dump.
+		incremental := s.lc.IncrementalShadowSync && !isDupSort && s.hooks.FilterReadDBI == nil
+
+		var dbiMsg *snapshot.DBI
+		if !incremental {
+			// raw dump, because main does not have timestamps
+			dbiMsg, err = s.readDBI(txn, dbiName, dbiName, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		if isDupSort && dupSortTransform == snapshot.TransformDupSortHackV1 {
 			dbiMsg, err = dupSortHackEncode(dbiMsg)
 			if err != nil {
 				return fmt.Errorf("dupsort_hack error for DBI %s: %w", dbiName, err)
@@ -71,6 +90,24 @@ func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.
 		if err != nil {
 			return err
 		}
+		shadowFlags, err := txn.Flags(targetDBI)
+		if err != nil {
+			return err
+		}
+		if shadowFlags&uint(AllowedShadowDBIFlagsMask) != targetFlags {
+			// Shadow DBIs created by older versions did not have all the
+			// flags that affect the key order, and LMDB ignores the flags
+			// passed for an existing DBI.
+			s.l.WithFields(logrus.Fields{
+				"dbi":          targetDBIName,
+				"flags":        dbiflags.Flags(shadowFlags),
+				"wanted_flags": dbiflags.Flags(targetFlags),
+			}).Warn("Shadow DBI flags do not match, recreating shadow DBI")
+			targetDBI, err = recreateDBI(txn, targetDBI, targetDBIName, targetFlags)
+			if err != nil {
+				return fmt.Errorf("recreate shadow dbi %s: %w", targetDBIName, err)
+			}
+		}
 
 		it, err := NewNativeIterator(
 			snapshot.CurrentFormatVersion,
@@ -83,7 +120,15 @@ func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.
 		if err != nil {
 			return fmt.Errorf("create native iterator: %w", err)
 		}
-		err = strategy.IterUpdate(txn, targetDBI, it)
+		switch {
+		case incremental:
+			err = mainToShadowCursor(txn, dbi, targetDBI, it)
+		case isDupSort && dupSortTransform == snapshot.TransformDupSortSetV1:
+			// Every key is stored with its set of values
+			err = strategy.IterUpdate(txn, targetDBI, &DupSetIterator{NativeIterator: it})
+		default:
+			err = strategy.IterUpdate(txn, targetDBI, it)
+		}
 		if err != nil {
 			return fmt.Errorf("dbi %s strategy %s: %w", targetDBIName, "IterUpdate", err)
 		}
@@ -105,8 +150,14 @@ func (s *Syncer) mainToShadow(ctx context.Context, txn *lmdb.Txn, tsNano header.
 // shadowToMain syncs the current databases from shadow databases with timestamps.
 // The sync is unidirectional. After the sync the main database will contain
 // all the non-deleted key-values present in the shadow database.
-func (s *Syncer) shadowToMain(ctx context.Context, txn *lmdb.Txn) error {
+func (s *Syncer) shadowToMain(ctx context.Context, txn *lmdb.Txn) (err error) {
 	t0 := time.Now()
+	ctx, span := tracing.Start(ctx, "shadowToMain",
+		tracing.AttrDB.String(s.name),
+		tracing.AttrTxnID.Int64(int64(txn.ID())))
+	defer func() {
+		tracing.End(span, err)
+	}()
 
 	// List of DBIs to dump
 	dbiNames, err := lmdbenv.ReadDBINames(txn)
@@ -130,8 +181,8 @@ func (s *Syncer) shadowToMain(ctx context.Context, txn *lmdb.Txn) error {
 		}
 
 		isDupSort := dbiFlags&lmdb.DupSort > 0
-		if isDupSort && !s.lc.DupSortHack {
-			return fmt.Errorf("shadowToMain: dupsort db %q found and dupsort_hack disabled", dbiName)
+		if isDupSort && s.dupSortTransform() == snapshot.TransformNone {
+			return fmt.Errorf("shadowToMain: dupsort db %q found and dupsort_hack and dupsort_set disabled", dbiName)
 		}
 
 		// Dump associated shadow database. We will ignore the timestamps.
@@ -142,7 +193,12 @@ func (s *Syncer) shadowToMain(ctx context.Context, txn *lmdb.Txn) error {
 			return err
 		}
 
-		if isDupSort {
+		if isDupSort && dbiMsg.Transform() == snapshot.TransformDupSortSetV1 {
+			dbiMsg, err = dupSetExpand(dbiMsg)
+			if err != nil {
+				return fmt.Errorf("dupsort_set error for DBI %s: %w", dbiName, err)
+			}
+		} else if isDupSort {
 			dbiMsg, err = dupSortHackDecode(dbiMsg)
 			if err != nil {
 				return fmt.Errorf("dupsort_hack error for DBI %s: %w", dbiName, err)
@@ -182,3 +238,31 @@ func (s *Syncer) shadowToMain(ctx context.Context, txn *lmdb.Txn) error {
 	}).Info("Synced data from shadow")
 	return nil
 }
+
+// recreateDBI recreates a DBI with different flags and restores its entries
+// unchanged, so that the timestamps and deletion markers of a shadow DBI are
+// preserved. LMDB does not allow changing the flags of an existing DBI.
+func recreateDBI(txn *lmdb.Txn, dbi lmdb.DBI, dbiName string, flags uint) (lmdb.DBI, error) {
+	entries, err := lmdbenv.ReadDBI(txn, dbi)
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		// The values must remain valid after the drop
+		entries[i] = lmdbenv.KV{Key: bytes.Clone(e.Key), Val: bytes.Clone(e.Val)}
+	}
+	if err := txn.Drop(dbi, true); err != nil {
+		return 0, fmt.Errorf("drop: %w", err)
+	}
+	dbi, err = txn.OpenDBI(dbiName, lmdb.Create|flags)
+	if err != nil {
+		return 0, err
+	}
+	// The order of the keys changes with the flags, so no append here
+	for _, e := range entries {
+		if err := txn.Put(dbi, e.Key, e.Val, 0); err != nil {
+			return 0, fmt.Errorf("put: %w", err)
+		}
+	}
+	return dbi, nil
+}
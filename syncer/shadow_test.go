@@ -11,6 +11,7 @@ import (
 	"github.com/PowerDNS/lightningstream/snapshot"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func b(s string) []byte {
@@ -185,3 +186,188 @@ func TestSyncer_shadow(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestSyncer_shadow_dbiFlags(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{DupSortSet: true}, Options{})
+		require.NoError(t, err)
+
+		// Keys in MDB_REVERSEKEY order are not in lexicographic order, and
+		// MDB_INTEGERDUP values are not either.
+		err = env.Update(func(txn *lmdb.Txn) error {
+			rev, err := txn.OpenDBI("rev", lmdb.Create|lmdb.ReverseKey)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(rev, b("xa"), b("1"), 0))
+			require.NoError(t, txn.Put(rev, b("yb"), b("2"), 0))
+			require.NoError(t, txn.Put(rev, b("ab"), b("3"), 0))
+
+			// Shadow DBI created by an older version without MDB_REVERSEKEY
+			_, err = txn.OpenDBI("_sync_shadow_rev", lmdb.Create)
+			require.NoError(t, err)
+
+			dups, err := txn.OpenDBI("dups", lmdb.Create|lmdb.DupSort|
+				lmdb.DupFixed|lmdb.IntegerDup|lmdb.ReverseKey)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dups, b("xa"), []byte{1, 1, 0, 0}, 0))
+			require.NoError(t, txn.Put(dups, b("xa"), []byte{2, 0, 0, 0}, 0))
+			require.NoError(t, txn.Put(dups, b("ab"), []byte{3, 0, 0, 0}, 0))
+			return s.mainToShadow(ctx, txn, ts1)
+		})
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			for _, name := range []string{"_sync_shadow_rev", "_sync_shadow_dups"} {
+				dbi, err := txn.OpenDBI(name, 0)
+				require.NoError(t, err)
+				flags, err := txn.Flags(dbi)
+				require.NoError(t, err)
+				assert.Equal(t, uint(lmdb.ReverseKey), flags&0xffff, name)
+			}
+			dbi, err := txn.OpenDBI("_sync_shadow_rev", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "xa", Val: h(ts1, 1, 0) + "1"},
+				{Key: "ab", Val: h(ts1, 1, 0) + "3"},
+				{Key: "yb", Val: h(ts1, 1, 0) + "2"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Load a remote snapshot with changes
+		rev := snapshot.NewDBI()
+		rev.SetName("rev")
+		rev.SetFlags(uint64(lmdb.ReverseKey))
+		rev.Append(snapshot.KV{Key: b("zc"), Value: b("4"), TimestampNano: uint64(ts2)})
+		rev.Append(snapshot.KV{Key: b("ab"), TimestampNano: uint64(ts2), Flags: uint32(header.FlagDeleted)})
+		dups := snapshot.NewDBI()
+		dups.SetName("dups")
+		dups.SetFlags(uint64(lmdb.DupSort | lmdb.DupFixed | lmdb.IntegerDup | lmdb.ReverseKey))
+		dups.SetTransform(snapshot.TransformDupSortSetV1)
+		dups.Append(dupSetKV(b("xa"), []snapshot.DupValue{
+			{Value: []byte{1, 1, 0, 0}, TimestampNano: uint64(ts2), Flags: uint32(header.FlagDeleted)},
+			{Value: []byte{0, 1, 0, 0}, TimestampNano: uint64(ts2)},
+		}))
+		update := snapshot.Update{Snapshot: &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.CompatFormatVersion,
+			Databases:     []*snapshot.DBI{rev, dups},
+		}}
+		_, _, err = s.LoadOnce(ctx, env, "remote", update, 0)
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("rev", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "xa", Val: "1"},
+				{Key: "yb", Val: "2"},
+				{Key: "zc", Val: "4"},
+			}, vals)
+
+			dbi, err = txn.OpenDBI("dups", 0)
+			require.NoError(t, err)
+			vals, err = lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "xa", Val: "\x02\x00\x00\x00"},
+				{Key: "xa", Val: "\x00\x01\x00\x00"},
+				{Key: "ab", Val: "\x03\x00\x00\x00"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// The dupsort_hack cannot handle these flags
+		s.lc.DupSortSet = false
+		s.lc.DupSortHack = true
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return s.mainToShadow(ctx, txn, ts2)
+		})
+		assert.ErrorContains(t, err, "not supported by dupsort_hack")
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_shadow_recreatePreservesHeaders(t *testing.T) {
+	ts1 := testTS(1)
+	ts2 := testTS(2)
+	ts3 := testTS(3)
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		s, err := New("test", env, nil, config.Config{}, config.LMDB{}, Options{})
+		require.NoError(t, err)
+
+		err = env.Update(func(txn *lmdb.Txn) error {
+			rev, err := txn.OpenDBI("rev", lmdb.Create|lmdb.ReverseKey)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(rev, b("xa"), b("1"), 0))
+			require.NoError(t, txn.Put(rev, b("yb"), b("2"), 0))
+
+			// Shadow DBI created by an older version without MDB_REVERSEKEY,
+			// with older timestamps and a deleted entry
+			shadow, err := txn.OpenDBI("_sync_shadow_rev", lmdb.Create)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(shadow, b("ab"), b(h(ts2, 5, header.FlagDeleted)), 0))
+			require.NoError(t, txn.Put(shadow, b("xa"), b(h(ts1, 3, 0)+"1"), 0))
+			require.NoError(t, txn.Put(shadow, b("yb"), b(h(ts2, 4, 0)+"2"), 0))
+			return s.mainToShadow(ctx, txn, ts3)
+		})
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("_sync_shadow_rev", 0)
+			require.NoError(t, err)
+			flags, err := txn.Flags(dbi)
+			require.NoError(t, err)
+			assert.Equal(t, uint(lmdb.ReverseKey), flags&0xffff)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "xa", Val: h(ts1, 3, 0) + "1"},
+				{Key: "ab", Val: h(ts2, 5, header.FlagDeleted)},
+				{Key: "yb", Val: h(ts2, 4, 0) + "2"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// An older remote snapshot must not restore the deleted entry
+		rev := snapshot.NewDBI()
+		rev.SetName("rev")
+		rev.SetFlags(uint64(lmdb.ReverseKey))
+		rev.Append(snapshot.KV{Key: b("ab"), Value: b("old"), TimestampNano: uint64(ts1)})
+		update := snapshot.Update{Snapshot: &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.CompatFormatVersion,
+			Databases:     []*snapshot.DBI{rev},
+		}}
+		_, _, err = s.LoadOnce(ctx, env, "remote", update, 0)
+		require.NoError(t, err)
+
+		err = env.View(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("rev", 0)
+			require.NoError(t, err)
+			vals, err := lmdbenv.ReadDBIString(txn, dbi)
+			require.NoError(t, err)
+			assert.Equal(t, []lmdbenv.KVString{
+				{Key: "xa", Val: "1"},
+				{Key: "yb", Val: "2"},
+			}, vals)
+			return nil
+		})
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
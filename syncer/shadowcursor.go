@@ -0,0 +1,152 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/lmdbenv/strategy"
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+)
+
+// mainToShadowCursor merges a main DBI into its shadow DBI with a cursor on
+// each DBI. This is used for the incremental_shadow_sync option.
+//
+// LMDB does not record in which transaction a DBI or page was last modified,
+// and pages are reused after they have been freed, so we cannot safely skip
+// DBIs or pages based on their page numbers and statistics. Instead, this
+// walks both DBIs in key order without copying any keys or values, and skips
+// every entry whose main value is the same as the shadow value with a plain
+// byte comparison. Only entries that changed are merged and written, which
+// makes a sync with few local changes cheap, even though it still reads every
+// entry.
+//
+// Entries that differ are merged by the iterator, exactly like in a full
+// shadow sync.
+func mainToShadowCursor(txn *lmdb.Txn, dbi, targetDBI lmdb.DBI, it *NativeIterator) error {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return fmt.Errorf("get flags: %w", err)
+	}
+	cmpFunc := strategy.KeyCmpFunc(flags)
+
+	mc, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return fmt.Errorf("open cursor: %w", err)
+	}
+	defer mc.Close()
+	sc, err := txn.OpenCursor(targetDBI)
+	if err != nil {
+		return fmt.Errorf("open shadow cursor: %w", err)
+	}
+	defer sc.Close()
+
+	// The slices returned point into the LMDB pages. Only the main DBI pages
+	// are never modified by the merge, so shadow slices must not be used
+	// after a write to the shadow DBI.
+	restoreRawRead := txn.RawRead
+	txn.RawRead = true
+	defer func() {
+		txn.RawRead = restoreRawRead
+	}()
+
+	get := func(c *lmdb.Cursor, op uint) (key, val []byte, eof bool, err error) {
+		key, val, err = c.Get(nil, nil, op)
+		if lmdb.IsNotFound(err) {
+			return nil, nil, true, nil
+		}
+		return key, val, false, err
+	}
+
+	mKey, mVal, mEOF, err := get(mc, lmdb.First)
+	if err != nil {
+		return fmt.Errorf("main first: %w", err)
+	}
+	sKey, sVal, sEOF, err := get(sc, lmdb.First)
+	if err != nil {
+		return fmt.Errorf("shadow first: %w", err)
+	}
+
+	for !mEOF || !sEOF {
+		cmp := 0
+		switch {
+		case sEOF:
+			cmp = -1
+		case mEOF:
+			cmp = 1
+		default:
+			cmp = cmpFunc(mKey, sKey)
+		}
+
+		advanceMain := cmp <= 0
+		advanceShadow := cmp >= 0
+		var key, oldVal, val []byte
+		switch {
+		case cmp < 0:
+			// New key in main
+			it.curKV = snapshot.KV{Key: mKey, Value: mVal}
+			key = mKey
+			val, err = it.Merge(nil)
+		case cmp > 0:
+			// Key removed from main
+			// The key points into a shadow page that the put can modify
+			key = bytes.Clone(sKey)
+			oldVal = sVal
+			val, err = it.Clean(sVal)
+		default:
+			if sameShadowVal(sVal, mVal) {
+				it.Stats.Unchanged++
+				break // fast path, nothing to merge
+			}
+			it.curKV = snapshot.KV{Key: mKey, Value: mVal}
+			key = mKey
+			oldVal = sVal
+			val, err = it.Merge(sVal)
+		}
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+
+		if key != nil && !bytes.Equal(val, oldVal) {
+			// Other cursors on the DBI are adjusted by LMDB for the write
+			if len(val) == 0 {
+				if err := txn.Del(targetDBI, key, nil); err != nil && !lmdb.IsNotFound(err) {
+					return fmt.Errorf("del: %w", err)
+				}
+			} else if err := txn.Put(targetDBI, key, val, 0); err != nil {
+				return fmt.Errorf("put: %w", err)
+			}
+			if !advanceShadow && !sEOF {
+				// Refresh the shadow slices that the put may have moved
+				sKey, sVal, sEOF, err = get(sc, lmdb.GetCurrent)
+				if err != nil {
+					return fmt.Errorf("shadow current: %w", err)
+				}
+			}
+		}
+		if advanceMain {
+			mKey, mVal, mEOF, err = get(mc, lmdb.Next)
+			if err != nil {
+				return fmt.Errorf("main next: %w", err)
+			}
+		}
+		if advanceShadow {
+			sKey, sVal, sEOF, err = get(sc, lmdb.Next)
+			if err != nil {
+				return fmt.Errorf("shadow next: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// sameShadowVal returns true if the shadow value is not deleted and has the
+// given plain value, without allocating.
+func sameShadowVal(shadowVal, plainVal []byte) bool {
+	h, appVal, err := header.Parse(shadowVal)
+	if err != nil {
+		return false // let the merge return the error
+	}
+	return !h.Flags.IsDeleted() && bytes.Equal(appVal, plainVal)
+}
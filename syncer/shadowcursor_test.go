@@ -0,0 +1,148 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/config"
+	"github.com/PowerDNS/lightningstream/lmdbenv"
+	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lmdb-go/lmdb"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_mainToShadow_incremental(t *testing.T) {
+	// Apply the same changes with a full and an incremental shadow sync, and
+	// compare the resulting shadow DBIs.
+	type change struct {
+		dbi, key, val string // empty val means delete
+	}
+	steps := [][]change{
+		{{"foo", "a", "1"}, {"foo", "b", "2"}, {"foo", "c", "3"}, {"rev", "xa", "1"}, {"rev", "ab", "2"}},
+		{{"foo", "b", ""}, {"foo", "c", "CCC"}, {"foo", "d", "4"}, {"foo", "aa", "mid"}, {"rev", "zc", "3"}, {"rev", "bb", "4"}},
+		{},
+		{{"foo", "b", "again"}, {"rev", "xa", ""}, {"rev", "ab", ""}, {"rev", "zc", ""}},
+	}
+
+	var results [2][][]lmdbenv.KVString
+	for i, incremental := range []bool{false, true} {
+		err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+			lc := config.LMDB{IncrementalShadowSync: incremental}
+			s, err := New("test", env, nil, config.Config{}, lc, Options{})
+			require.NoError(t, err)
+
+			for n, step := range steps {
+				err = env.Update(func(txn *lmdb.Txn) error {
+					foo, err := txn.OpenDBI("foo", lmdb.Create)
+					require.NoError(t, err)
+					rev, err := txn.OpenDBI("rev", lmdb.Create|lmdb.ReverseKey)
+					require.NoError(t, err)
+					for _, c := range step {
+						dbi := foo
+						if c.dbi == "rev" {
+							dbi = rev
+						}
+						if c.val == "" {
+							require.NoError(t, txn.Del(dbi, b(c.key), nil))
+						} else {
+							require.NoError(t, txn.Put(dbi, b(c.key), b(c.val), 0))
+						}
+					}
+					return s.mainToShadow(context.Background(), txn, testTS(n+1))
+				})
+				require.NoError(t, err)
+
+				err = env.View(func(txn *lmdb.Txn) error {
+					for _, name := range []string{"_sync_shadow_foo", "_sync_shadow_rev"} {
+						dbi, err := txn.OpenDBI(name, 0)
+						require.NoError(t, err)
+						vals, err := lmdbenv.ReadDBIString(txn, dbi)
+						require.NoError(t, err)
+						results[i] = append(results[i], vals)
+					}
+					return nil
+				})
+				require.NoError(t, err)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, results[0], results[1])
+	assert.Equal(t, []lmdbenv.KVString{
+		{Key: "a", Val: h(testTS(1), 1, 0) + "1"},
+		{Key: "aa", Val: h(testTS(2), 2, 0) + "mid"},
+		{Key: "b", Val: h(testTS(4), 3, 0) + "again"}, // empty step did not use a txnID
+		{Key: "c", Val: h(testTS(2), 2, 0) + "CCC"},
+		{Key: "d", Val: h(testTS(2), 2, 0) + "4"},
+	}, results[1][len(results[1])-2])
+}
+
+func BenchmarkSyncer_mainToShadow_full_100k(b *testing.B) {
+	doBenchmarkSyncerMainToShadow(b, false)
+}
+
+func BenchmarkSyncer_mainToShadow_incremental_100k(b *testing.B) {
+	doBenchmarkSyncerMainToShadow(b, true)
+}
+
+// doBenchmarkSyncerMainToShadow measures a shadow sync after changing a few
+// entries in a large DBI, which is the common case for a local change.
+func doBenchmarkSyncerMainToShadow(b *testing.B, incremental bool) {
+	t := b
+	const nRecords = 100_000
+	const nChanges = 10
+
+	l, _ := test.NewNullLogger()
+	lc := config.LMDB{
+		IncrementalShadowSync: incremental,
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		syncer, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+		syncer.l = l
+
+		// Fill some data and create the initial shadow DBI
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			for i := range nRecords {
+				key := fmt.Appendf(nil, "key-%020d", i)
+				err := txn.Put(dbi, key, []byte("TESTING-123456789"), 0)
+				if err != nil {
+					return err
+				}
+			}
+			return syncer.mainToShadow(context.Background(), txn, header.TimestampFromTime(time.Now()))
+		})
+		if err != nil {
+			return err
+		}
+
+		// Actual benchmark
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err = env.Update(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI("foo", 0)
+				require.NoError(t, err)
+				for j := range nChanges {
+					key := fmt.Appendf(nil, "key-%020d", (i*nChanges+j)*997%nRecords)
+					val := fmt.Appendf(nil, "CHANGED-%d", i)
+					if err := txn.Put(dbi, key, val, 0); err != nil {
+						return err
+					}
+				}
+				return syncer.mainToShadow(context.Background(), txn, header.TimestampFromTime(time.Now()))
+			})
+			require.NoError(b, err)
+		}
+		return nil
+	})
+	require.NoError(b, err)
+}
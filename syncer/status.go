@@ -0,0 +1,132 @@
+package syncer
+
+import (
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/status"
+	"github.com/PowerDNS/lightningstream/syncer/receiver"
+)
+
+// loadedSnapshot describes the last snapshot loaded from an instance
+type loadedSnapshot struct {
+	NameInfo snapshot.NameInfo
+	LoadedAt time.Time
+	Size     int64  // Compressed size
+	Hostname string // From the snapshot meta
+}
+
+// setWaitingFor records the instances we are still waiting for after startup
+func (s *Syncer) setWaitingFor(set *InstanceSet) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.waitingFor = set.List()
+}
+
+// statusState returns the syncer state for the JSON status API.
+// This is called from the HTTP server, so it must only access state that is
+// safe to read concurrently with the sync loop.
+func (s *Syncer) statusState(r *receiver.Receiver) status.SyncerState {
+	rs := r.State()
+	cs := s.cleaner.State()
+
+	st := status.SyncerState{
+		Instance: s.instanceID(),
+		Paused: status.PauseState{
+			Load: s.pauseLoad.Load(),
+			Send: s.pauseSend.Load(),
+		},
+		Receiver: status.ReceiverState{
+			HasSnapshots:     rs.HasSnapshots,
+			ReadyUpdates:     rs.ReadyUpdates,
+			Downloaders:      rs.Downloaders,
+			CorruptSnapshots: rs.CorruptSnapshots,
+		},
+		Cleaner: status.CleanerState{
+			Enabled:      cs.Enabled,
+			LastRun:      cs.LastRun.Time,
+			LastError:    cs.LastRun.Error,
+			LastTotal:    cs.LastRun.Total,
+			LastCleaned:  cs.LastRun.Cleaned,
+			LastFailures: cs.LastRun.Failed,
+		},
+	}
+	if stored, ok := s.events.UpdateStored.Last(); ok {
+		st.LastStored = snapshotRef(stored.NameInfo)
+	}
+
+	s.statusMu.Lock()
+	lastLoaded := maps.Clone(s.lastLoaded)
+	waitingFor := s.waitingFor
+	sw := s.sweeper
+	s.statusMu.Unlock()
+
+	if sw != nil {
+		ss := sw.State()
+		st.Sweeper = &status.SweeperState{
+			LastRun:         ss.LastRun,
+			LastError:       ss.LastError,
+			TotalEntries:    ss.TotalEntries,
+			DeletedEntries:  ss.DeletedEntries,
+			CleanedEntries:  ss.CleanedEntries,
+			DeletedFraction: ss.DeletedFraction,
+			Seconds:         ss.TimeTaken.Seconds(),
+		}
+	}
+
+	// Instances we have seen in storage or loaded snapshots from
+	instances := make(map[string]*status.InstanceState)
+	get := func(inst string) *status.InstanceState {
+		is, exists := instances[inst]
+		if !exists {
+			is = &status.InstanceState{Instance: inst}
+			instances[inst] = is
+		}
+		return is
+	}
+	for inst, ni := range rs.LastSeenByInstance {
+		is := get(inst)
+		is.LastSeen = snapshotRef(ni)
+		is.LastSeenSize = rs.LastSeenSize[inst]
+	}
+	for inst, ds := range rs.Downloads {
+		is := get(inst)
+		is.Download = ds.State
+		is.DownloadError = ds.Error
+	}
+	for inst, ls := range lastLoaded {
+		is := get(inst)
+		is.LastLoaded = snapshotRef(ls.NameInfo)
+		is.LoadedAt = ls.LoadedAt
+		is.LoadedSize = ls.Size
+		is.Hostname = ls.Hostname
+	}
+	for _, inst := range waitingFor {
+		get(inst).Waiting = true
+	}
+	for inst, t := range cs.Committed {
+		get(inst).Committed = t
+	}
+	st.Instances = make([]status.InstanceState, 0, len(instances))
+	for _, is := range instances {
+		st.Instances = append(st.Instances, *is)
+	}
+	sort.Slice(st.Instances, func(i, j int) bool {
+		return st.Instances[i].Instance < st.Instances[j].Instance
+	})
+	return st
+}
+
+func snapshotRef(ni snapshot.NameInfo) *status.SnapshotRef {
+	name := ni.FullName
+	if name == "" {
+		// Not set for names we built ourselves
+		name = ni.BuildName()
+	}
+	return &status.SnapshotRef{
+		Name:      name,
+		Timestamp: ni.Timestamp,
+	}
+}
@@ -15,6 +15,14 @@ type stats struct {
 	timeTaken time.Duration
 }
 
+// add adds the counts of a committed transaction
+func (s *stats) add(other stats) {
+	s.nEntries += other.nEntries
+	s.nDeleted += other.nDeleted
+	s.nCleaned += other.nCleaned
+	s.nTxn += other.nTxn
+}
+
 func (s stats) logFields() logrus.Fields {
 	return logrus.Fields{
 		"total_entries":    s.nEntries,
@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PowerDNS/lightningstream/config"
 	"github.com/PowerDNS/lightningstream/lmdbenv"
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/lmdbenv/limitscanner"
+	"github.com/PowerDNS/lightningstream/syncer/events"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/sirupsen/logrus"
@@ -23,9 +26,10 @@ const (
 	SyncDBIPrefix = "_sync"
 )
 
-func New(name string, conf config.Sweeper, env *lmdb.Env, l logrus.FieldLogger, schemaTracksChanges bool) *Sweeper {
+func New(name string, conf config.Sweeper, env *lmdb.Env, l logrus.FieldLogger, schemaTracksChanges bool, ev *events.Events) *Sweeper {
 	return &Sweeper{
 		name:                name,
+		events:              ev,
 		l:                   l.WithField("component", "sweeper"),
 		env:                 env,
 		conf:                conf,
@@ -38,14 +42,51 @@ func New(name string, conf config.Sweeper, env *lmdb.Env, l logrus.FieldLogger,
 // You need one Sweeper per LMDB.
 // Do not confuse this with the Cleaner, which cleans snapshots.
 type Sweeper struct {
-	name string
-	l    logrus.FieldLogger
-	env  *lmdb.Env
-	conf config.Sweeper
+	name   string
+	l      logrus.FieldLogger
+	env    *lmdb.Env
+	conf   config.Sweeper
+	events *events.Events
 
 	schemaTracksChanges bool // native schema?
 
-	lastStats stats // mainly for tests
+	// runMu prevents concurrent sweeps
+	runMu sync.Mutex
+
+	// mu protects the fields below, which are also read by the status API
+	mu        sync.Mutex
+	lastStats stats // stats of the last successful sweep
+	lastRun   time.Time
+	lastErr   error
+}
+
+// State is a summary of the sweeper state for the status API
+type State struct {
+	LastRun         time.Time // Start time of the last sweep, zero if it never ran
+	LastError       string    // Error of the last sweep
+	TotalEntries    int       // Entries found in the last successful sweep
+	DeletedEntries  int       // Deleted entries that are still retained
+	CleanedEntries  int       // Deleted entries cleaned in the last successful sweep
+	TimeTaken       time.Duration
+	DeletedFraction float64
+}
+
+// State returns the current sweeper state
+func (s *Sweeper) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := State{
+		LastRun:         s.lastRun,
+		TotalEntries:    s.lastStats.nEntries,
+		DeletedEntries:  s.lastStats.nDeleted,
+		CleanedEntries:  s.lastStats.nCleaned,
+		TimeTaken:       s.lastStats.timeTaken,
+		DeletedFraction: s.lastStats.deletedFraction(),
+	}
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+	}
+	return st
 }
 
 // Run runs the sweeper according to the configured schedule.
@@ -60,7 +101,7 @@ func (s *Sweeper) Run(ctx context.Context) error {
 		wait = s.conf.Interval
 
 		// Do sweep
-		err := s.sweep(ctx)
+		err := s.RunOnce(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return err
@@ -70,6 +111,28 @@ func (s *Sweeper) Run(ctx context.Context) error {
 	}
 }
 
+// RunOnce performs a single full database sweep and records the result.
+// It can be called concurrently with Run, but sweeps never run concurrently.
+func (s *Sweeper) RunOnce(ctx context.Context) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	t0 := time.Now()
+	ctx, span := tracing.Start(ctx, "sweeper.RunOnce", tracing.AttrDB.String(s.name))
+	err := s.sweep(ctx)
+	s.mu.Lock()
+	s.lastRun = t0
+	s.lastErr = err
+	st := s.lastStats
+	s.mu.Unlock()
+	if err == nil {
+		span.SetAttributes(
+			tracing.AttrTotal.Int(st.nEntries),
+			tracing.AttrCleaned.Int(st.nCleaned))
+	}
+	tracing.End(span, err)
+	return err
+}
+
 // sweep performs a single full database sweep.
 func (s *Sweeper) sweep(ctx context.Context) error {
 	t0 := time.Now()
@@ -84,7 +147,7 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 	// Get the list of DBI names to work on.
 	// This needs to be done every time, because new DBIs may be added over time.
 	var dbiNames []string
-	err := s.env.View(func(txn *lmdb.Txn) error {
+	err := lmdbenv.View(s.env, func(txn *lmdb.Txn) error {
 		var err error
 		dbiNames, err = lmdbenv.ReadDBINames(txn)
 		return err
@@ -93,8 +156,20 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 		return err
 	}
 
-	// Stats
+	// Stats, only counting transactions that were committed
 	var st stats
+	cleaned := make(map[string]int) // by DBI name
+	defer func() {
+		// Entries cleaned by committed transactions are gone, even if the
+		// sweep fails or is interrupted later.
+		metricCleanedTotal.WithLabelValues(s.name).Add(float64(st.nCleaned))
+		if len(cleaned) > 0 {
+			s.events.EntriesSwept.Publish(events.SweepInfo{
+				Cutoff:  cutoff,
+				Cleaned: cleaned,
+			})
+		}
+	}()
 
 	for _, dbiName := range dbiNames {
 		// We must not corrupt the data if a non-native schema is used for
@@ -110,8 +185,9 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 		var last limitscanner.LimitCursor
 		var limitReached bool
 		for {
-			err := s.env.Update(func(txn *lmdb.Txn) error {
-				st.nTxn++
+			var chunk stats // of this transaction
+			err := lmdbenv.Update(s.env, func(txn *lmdb.Txn) error {
+				chunk = stats{nTxn: 1}
 
 				dbi, err := txn.OpenDBI(dbiName, 0)
 				if err != nil {
@@ -141,16 +217,16 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 						// TODO: Consider keeping track of a histogram of ages,
 						//       but the standard Prometheus Observe() may be
 						//       too slow to use here.
-						st.nEntries++
+						chunk.nEntries++
 						continue
 					}
 					if h.Timestamp >= cutoffTS {
-						st.nEntries++
-						st.nDeleted++
+						chunk.nEntries++
+						chunk.nDeleted++
 						continue
 					}
 					// Too old deleted entry, clean
-					st.nCleaned++
+					chunk.nCleaned++
 					if err := txn.Del(dbi, ls.Key(), ls.Val()); err != nil {
 						// Should only happen if the mapsize or disk is full
 						return fmt.Errorf("failed to delete key %s: %w",
@@ -161,6 +237,13 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 				last, limitReached = ls.Cursor()
 				return ls.Err()
 			})
+			if err != nil {
+				return fmt.Errorf("failed to sweep dbi %s: %w", dbiName, err)
+			}
+			st.add(chunk)
+			if chunk.nCleaned > 0 {
+				cleaned[dbiName] += chunk.nCleaned
+			}
 			if limitReached {
 				l.Debug("Sweep limit reached, continuing after pause")
 				// Give the app some room to get a write lock before continuing
@@ -169,17 +252,15 @@ func (s *Sweeper) sweep(ctx context.Context) error {
 				}
 				continue
 			}
-			if err != nil {
-				return fmt.Errorf("failed to sweep dbi %s: %w", dbiName, err)
-			}
 
 			// Done with this DBI
 			break
 		}
 	}
 	st.timeTaken = time.Since(t0)
+	s.mu.Lock()
 	s.lastStats = st
-	metricCleanedTotal.WithLabelValues(s.name).Add(float64(st.nCleaned))
+	s.mu.Unlock()
 	metricStatsTotal.WithLabelValues(s.name).Set(float64(st.nEntries))
 	metricStatsDeleted.WithLabelValues(s.name).Set(float64(st.nDeleted))
 	metricStatsAvailable.WithLabelValues(s.name).Set(1)
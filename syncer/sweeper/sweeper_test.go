@@ -1,6 +1,7 @@
 package sweeper
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/PowerDNS/lightningstream/config"
 	"github.com/PowerDNS/lightningstream/lmdbenv"
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
+	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
@@ -25,8 +27,9 @@ func TestSweeper(t *testing.T) {
 
 	l, _ := test.NewNullLogger()
 
+	ev := events.New()
 	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
-		sweeper := New("test", conf, env, l, true)
+		sweeper := New("test", conf, env, l, true, ev)
 
 		t.Run("empty-lmdb", func(t *testing.T) {
 			// Completely empty database sweep
@@ -83,6 +86,9 @@ func TestSweeper(t *testing.T) {
 			assert.Equal(t, 1000, sweeper.lastStats.nDeleted)
 			assert.Equal(t, 1000, sweeper.lastStats.nCleaned)
 			assert.Equal(t, 0.5, sweeper.lastStats.deletedFraction())
+			swept, ok := ev.EntriesSwept.Last()
+			assert.True(t, ok)
+			assert.Equal(t, map[string]int{"test1": 1000}, swept.Cleaned)
 			t.Logf("Cleaning 3000 entries took %s", sweeper.lastStats.timeTaken)
 		})
 
@@ -91,6 +97,60 @@ func TestSweeper(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSweeper_interrupted(t *testing.T) {
+	conf := config.Sweeper{
+		Enabled:         true,
+		RetentionDays:   2,
+		LockDuration:    time.Nanosecond, // limit reached at the first check
+		ReleaseDuration: time.Hour,
+	}
+
+	l, _ := test.NewNullLogger()
+
+	ev := events.New()
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		sweeper := New("test", conf, env, l, true, ev)
+		pastTS := header.TimestampFromTime(time.Now().Add(-50 * time.Hour))
+
+		var dbi lmdb.DBI
+		assert.NoError(t, env.Update(func(txn *lmdb.Txn) error {
+			var err error
+			dbi, err = txn.CreateDBI("stale")
+			if err != nil {
+				return err
+			}
+			for i := range 3000 {
+				key := fmt.Appendf(nil, "key-%08d", i)
+				val := make([]byte, header.MinHeaderSize)
+				header.PutBasic(val, pastTS, 1, header.FlagDeleted)
+				if err := txn.Put(dbi, key, val, 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		// The first chunk is committed, then the sweep is interrupted
+		// during the pause.
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		assert.ErrorIs(t, sweeper.sweep(ctx), context.Canceled)
+
+		var remaining int
+		assert.NoError(t, env.View(func(txn *lmdb.Txn) error {
+			stat, err := txn.Stat(dbi)
+			remaining = int(stat.Entries)
+			return err
+		}))
+		assert.Less(t, remaining, 3000)
+		swept, ok := ev.EntriesSwept.Last()
+		assert.True(t, ok)
+		assert.Equal(t, map[string]int{"stale": 3000 - remaining}, swept.Cleaned)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
 func BenchmarkSweeper(b *testing.B) {
 	// This benchmark creates b.N entries and sweeps them.
 	// Here 1/3 of the entries will be cleaned.
@@ -110,7 +170,7 @@ func BenchmarkSweeper(b *testing.B) {
 	l, _ := test.NewNullLogger()
 
 	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
-		sweeper := New("test", conf, env, l, true)
+		sweeper := New("test", conf, env, l, true, events.New())
 
 		createDBI := func(name string) lmdb.DBI {
 			var dbi lmdb.DBI
@@ -1,9 +1,12 @@
 package syncer
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PowerDNS/lightningstream/lmdbenv"
@@ -15,6 +18,7 @@ import (
 	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/lightningstream/syncer/receiver"
 	"github.com/PowerDNS/lightningstream/syncer/sweeper"
+	"github.com/PowerDNS/lightningstream/tracing"
 	"github.com/PowerDNS/lightningstream/utils"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/sirupsen/logrus"
@@ -29,13 +33,6 @@ const (
 
 // Sync opens the env and starts the two-way sync loop.
 func (s *Syncer) Sync(ctx context.Context) error {
-	env := s.env
-	status.AddLMDBEnv(s.name, env)
-	defer status.RemoveLMDBEnv(s.name)
-
-	s.startStatsLogger(ctx, env)
-	s.registerCollector(env)
-
 	r := receiver.New(
 		s.st,
 		s.c,
@@ -46,13 +43,66 @@ func (s *Syncer) Sync(ctx context.Context) error {
 		s.hooks,
 	)
 
-	return s.syncLoop(ctx, env, r)
+	status.AddSyncer(s.name, func() status.SyncerState {
+		return s.statusState(r)
+	})
+	defer status.RemoveSyncer(s.name)
+	status.AddController(s.name, &adminController{s: s, r: r})
+	defer status.RemoveController(s.name)
+
+	return s.syncLoop(ctx, s.Env(), r)
+}
+
+// startEnvWorkers starts the background work that uses the env, like the
+// sweeper and the seed publisher. The returned function stops them and waits
+// until they no longer use the env.
+func (s *Syncer) startEnvWorkers(ctx context.Context, env *lmdb.Env, seedReady <-chan struct{}) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	status.AddLMDBEnv(s.name, env)
+	s.startStatsLogger(ctx, env)
+	s.registerCollector(env)
+
+	// Publish seed images in the background once all snapshots were loaded,
+	// if enabled.
+	if s.lc.Seed.PublishInterval > 0 && !s.opt.ReceiveOnly {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.runSeedPublisher(ctx, env, seedReady)
+			s.l.WithError(err).Info("Seed publisher exited")
+		}()
+	}
+
+	// Run the tombsweeper to remove state deleted records, if enabled.
+	if s.c.Sweeper.Enabled {
+		sw := sweeper.New(s.name, s.c.Sweeper, env, s.l, s.lc.SchemaTracksChanges, s.events)
+		s.statusMu.Lock()
+		s.sweeper = sw
+		s.statusMu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := sw.Run(ctx)
+			s.l.WithError(err).Info("Sweeper exited")
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+		s.statusMu.Lock()
+		s.sweeper = nil
+		s.statusMu.Unlock()
+		status.RemoveLMDBEnv(s.name)
+	}
 }
 
 // syncLoop enters a two-way sync-loop and only returns when an error that cannot be
 // handled occurs.
 func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiver) error {
-	info, err := env.Info()
+	info, err := lmdbenv.Info(env)
 	if err != nil {
 		return err
 	}
@@ -73,14 +123,13 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 		s.l.WithError(err).Info("Cleaner exited")
 	}()
 
-	// Run the tombsweeper to remove state deleted records, if enabled.
-	if s.c.Sweeper.Enabled {
-		sw := sweeper.New(s.name, s.c.Sweeper, s.env, s.l, s.lc.SchemaTracksChanges)
-		go func() {
-			err := sw.Run(ctx)
-			s.l.WithError(err).Info("Sweeper exited")
-		}()
-	}
+	// The seed publisher starts once all snapshots were loaded
+	seedReady := make(chan struct{})
+	seedReadyClosed := false
+	stopEnvWorkers := s.startEnvWorkers(ctx, env, seedReady)
+	defer func() {
+		stopEnvWorkers()
+	}()
 
 	// Wait for an initial snapshot listing
 	for {
@@ -123,12 +172,14 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 		waitingForInstances.Add(instance)
 	}
 
+	s.setWaitingFor(waitingForInstances)
+
 	if hasDataAtStart && !s.lc.SchemaTracksChanges {
 		// Sync to shadow using a time in the past to not overwrite newer data.
 		// At least it allows us to save newer entries that were added
 		// while the syncer was not running. It will not save updated entries.
 		s.l.Info("Syncing main to shadow, in case data was changed before start")
-		err := env.Update(func(txn *lmdb.Txn) error {
+		err := lmdbenv.Update(env, func(txn *lmdb.Txn) error {
 			// We would like to just use timestamp 0 here, but that
 			// would break older clients that explicitly guard against
 			// zero timestamps.
@@ -191,53 +242,70 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 		// snapshot when local changes are detected.
 		// TODO: LSE: Maybe also add MaxConsecutiveUpdateLoads, or base this on time?
 		nLoads := 0
+		batchSize := s.loadBatchSize()
 	loadReadySnapshotsLoop:
 		for {
-			instance, update := r.Next()
-			if instance == "" {
-				break loadReadySnapshotsLoop // no more ready remote snapshots
+			if s.pauseLoad.Load() {
+				break // paused by admin request, updates wait in the receiver
 			}
+			// Collect up to batchSize ready updates to load together
+			var batch []InstanceUpdate
+			for len(batch) < batchSize {
+				instance, update := r.Next()
+				if instance == "" {
+					break // no more ready remote snapshots
+				}
 
-			// New update to load
-			l := s.l.WithFields(logrus.Fields{
-				"kind": update.NameInfo.Kind,
-				"file": update.NameInfo.FullName,
-			})
-			if instance == ownInstanceID {
-				l.Info("Loading update for own instance")
-			} else {
-				l.Debug("Loading update")
-			}
-			l = s.l.WithField("other_instance", instance)
+				// New update to load
+				l := s.l.WithFields(logrus.Fields{
+					"kind": update.NameInfo.Kind,
+					"file": update.NameInfo.FullName,
+				})
+				if instance == ownInstanceID {
+					l.Info("Loading update for own instance")
+				} else {
+					l.Debug("Loading update")
+				}
+				l = s.l.WithField("other_instance", instance)
 
-			if update.NameInfo.Kind == snapshot.KindSnapshot {
-				nLoads++
-				if waitingForInstances.Contains(instance) && s.hooks.InstanceReady == nil {
-					l.Info("No longer waiting for instance")
-					waitingForInstances.Remove(instance)
+				if update.NameInfo.Kind == snapshot.KindSnapshot {
+					nLoads++
+					if waitingForInstances.Contains(instance) && s.hooks.InstanceReady == nil {
+						l.Info("No longer waiting for instance")
+						waitingForInstances.Remove(instance)
+					}
 				}
-			}
-			if waitingForInstances.Contains(instance) && s.hooks.InstanceReady != nil {
-				if s.hooks.InstanceReady(&update.NameInfo) {
-					l.Info("No longer waiting for instance")
-					waitingForInstances.Remove(instance)
-				} else {
-					l.Info("Waiting for additional updates for instance")
+				if waitingForInstances.Contains(instance) && s.hooks.InstanceReady != nil {
+					if s.hooks.InstanceReady(&update.NameInfo) {
+						l.Info("No longer waiting for instance")
+						waitingForInstances.Remove(instance)
+					} else {
+						l.Info("Waiting for additional updates for instance")
+					}
 				}
+				batch = append(batch, InstanceUpdate{Instance: instance, Update: update})
+			}
+			if len(batch) == 0 {
+				break loadReadySnapshotsLoop // no more ready remote snapshots
 			}
 
-			actualTxnID, localChanged, err := s.LoadOnce(
-				ctx, env, instance, update, lastSyncedTxnID)
-			update.Close() // releases the DecompressedSnapshotToken
+			var actualTxnID header.TxnID
+			var localChanged bool
+			err := s.checkMapSize(env)
+			if err == nil {
+				err = s.withMapSizeRetry(env, func() (err error) {
+					actualTxnID, localChanged, err = s.LoadBatch(
+						ctx, env, batch, lastSyncedTxnID)
+					return err
+				})
+			}
+			for _, iu := range batch {
+				iu.Update.Close() // releases the DecompressedSnapshotToken
+			}
 			if err != nil {
 				return err
 			}
 
-			// Publish a successful load
-			s.events.UpdateLoaded.Publish(events.UpdateInfo{
-				NameInfo: update.NameInfo,
-			})
-
 			if !localChanged {
 				// Prevent triggering a local snapshot if there were no local
 				// changes by bumping the transaction ID we consider synced
@@ -267,9 +335,62 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 			}
 		}
 
+		// Store a snapshot if requested by the admin API
+		select {
+		case req := <-s.snapshotRequests:
+			var res snapshotResult
+			if waitingForInstances.Contains(ownInstanceID) {
+				res.err = errors.New("cannot store a snapshot before our own old snapshot was loaded")
+			} else {
+				var actualTxnID header.TxnID
+				res.err = s.withMapSizeRetry(env, func() (err error) {
+					actualTxnID, err = s.SendOnce(ctx, env)
+					return err
+				})
+				if res.err == nil {
+					lastSyncedTxnID = actualTxnID
+					if stored, ok := s.events.UpdateStored.Last(); ok {
+						res.name = stored.NameInfo.BuildName()
+					}
+				}
+			}
+			req.result <- res
+			if errors.Is(res.err, context.Canceled) {
+				return res.err
+			}
+		default:
+		}
+
+		// Swap in a compacted copy of the data file if requested by the
+		// admin API. This needs the env workers to be stopped, so that only
+		// the sync loop uses the env.
+		select {
+		case req := <-s.swapRequests:
+			var res compactResult
+			if res.err = s.checkSwap(env); res.err == nil {
+				stopEnvWorkers()
+				var newEnv *lmdb.Env
+				newEnv, res.CompactResult, res.err = s.swapCompacted(env)
+				if newEnv == nil {
+					req.result <- res
+					return res.err
+				}
+				env = newEnv
+				stopEnvWorkers = s.startEnvWorkers(ctx, env, seedReady)
+				if res.Swapped {
+					// LMDB resets the transaction ID of a compacted copy, and
+					// local changes made before the swap may not have been
+					// sent yet.
+					lastSyncedTxnID = 0
+				}
+			}
+			req.result <- res
+		default:
+		}
+
 		// Check if we need to do a periodic snapshot
 		snapshotOverdue := false
-		if dt := time.Since(s.lastSnapshotTime); forceSnapshotEnabled && dt > forceSnapshotInterval {
+		if dt := time.Since(s.lastSnapshotTime); forceSnapshotEnabled && dt > forceSnapshotInterval && !s.pauseSend.Load() {
 			snapshotOverdue = true
 			if s.hooks.SnapshotOverdue != nil {
 				if err := s.hooks.SnapshotOverdue(); err != nil {
@@ -283,7 +404,7 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 		}
 
 		// Check for change in local LMDB
-		info, err := env.Info()
+		info, err := lmdbenv.Info(env)
 		if err != nil {
 			return err
 		}
@@ -294,7 +415,9 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 		if header.TxnID(info.LastTxnID) > lastSyncedTxnID || snapshotOverdue {
 			// We have data to snapshot, or we have not performed a snapshot
 			// yet after startup.
-			if waitingForInstances.Contains(ownInstanceID) {
+			if s.pauseSend.Load() {
+				s.l.Debug("Not storing a snapshot, because sending is paused")
+			} else if waitingForInstances.Contains(ownInstanceID) {
 				// We must not store a snapshot before we have loaded our own
 				// snapshot, because if we started with an empty LMDB, we
 				// could write a snapshot that loses data that was only in our
@@ -312,7 +435,11 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 
 				// Store snapshot
 				if hasDataAtStart || lastSyncedTxnID > 0 {
-					actualTxnID, err := s.SendOnce(ctx, env)
+					var actualTxnID header.TxnID
+					err := s.withMapSizeRetry(env, func() (err error) {
+						actualTxnID, err = s.SendOnce(ctx, env)
+						return err
+					})
 					if err != nil {
 						return err
 					}
@@ -326,9 +453,15 @@ func (s *Syncer) syncLoop(ctx context.Context, env *lmdb.Env, r *receiver.Receiv
 			}
 		}
 
+		s.setWaitingFor(waitingForInstances)
+
 		// Update start tracker if pass has completed
 		if waitingForInstances.Done() {
 			s.startTracker.SetPassCompleted()
+			if !seedReadyClosed {
+				close(seedReady)
+				seedReadyClosed = true
+			}
 		}
 
 		// If set, we are done now.
@@ -349,6 +482,16 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 	t0 := time.Now() // for performance measurements
 	snap := update.Snapshot
 
+	ctx, span := tracing.Start(ctx, "LoadOnce",
+		tracing.AttrDB.String(s.name),
+		tracing.AttrInstance.String(instance),
+		tracing.AttrSnapshotName.String(update.NameInfo.FullName),
+		tracing.AttrSnapshotSize.Int64(int64(update.BlobSize.Bytes())))
+	defer func() {
+		span.SetAttributes(tracing.AttrTxnID.Int64(int64(txnID)))
+		tracing.End(span, err)
+	}()
+
 	var tTxnAcquire time.Time
 	var tShadow1Start time.Time
 	var tShadow1End time.Time
@@ -359,12 +502,52 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 
 	schemaTracksChanges := s.lc.SchemaTracksChanges
 
-	err = env.Update(func(txn *lmdb.Txn) error {
+	// Skip DBIs that did not change since the last snapshot of this instance
+	if s.lc.SkipUnchangedDBIs {
+		snap = s.skipUnchangedDBIs(instance, snap)
+		update.Snapshot = snap
+	}
+
+	// Apply any rewrites for a different schema version before we acquire
+	// the write lock.
+	databases, err := s.rewriteDatabases(snap, s.l.WithField("snapshot_instance", instance))
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Large snapshots can be loaded in multiple write transactions
+	if s.lc.LoadLockDuration > 0 {
+		return s.loadChunked(ctx, env, instance, update, databases, lastTxnID, t0)
+	}
+
+	// Merge the snapshot with the current LMDB contents before we acquire
+	// the write lock, so that we only need to apply the changes if the LMDB
+	// did not change in the meantime.
+	deletedCutoff := s.deletedCutoff(t0)
+	tPrepareStart := time.Now()
+	prepared, err := s.prepareLoad(ctx, env, snap, databases, lastTxnID, deletedCutoff)
+	if err != nil {
+		return 0, false, err
+	}
+	tPrepareEnd := time.Now()
+	span.AddEvent("prepared")
+
+	stats := make(map[string]events.DBIStats, len(databases))
+
+	latencies := newLatencyBuffer(s.name)
+	err = lmdbenv.Update(env, func(txn *lmdb.Txn) error {
 		ts := time.Now()
 		tTxnAcquire = ts
 		tsNano := header.TimestampFromTime(ts)
 		txnID = header.TxnID(txn.ID())
 
+		if prepared != nil && prepared.TxnID != txnID {
+			s.l.WithField("txnID", txnID).WithField("preparedTxnID", prepared.TxnID).
+				Debug("LMDB changed after load was prepared, merging in write transaction")
+			metricLoadPreparedStale.WithLabelValues(s.name).Inc()
+			prepared = nil
+		}
+
 		// There was a local change if the update transaction ID was more than 1
 		// higher than the last transaction ID we took a snapshot of.
 		// If nothing had changed since the last snapshot, we would get the next
@@ -380,6 +563,7 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 			"localChanged":      localChanged,
 		})
 		l.Debug("Started load")
+		span.AddEvent("txn acquired")
 
 		// First update the shadow dbs to reflect the latest local state
 		tShadow1Start = time.Now()
@@ -393,9 +577,8 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 
 		// Apply snapshot
 		tLoadStart = time.Now()
-		for _, dbiMsg := range snap.Databases {
+		for _, dbiMsg := range databases {
 			dbiName := dbiMsg.Name()
-			dbiOpt := s.lc.DBIOptions[dbiName]
 			ld := l.WithField("dbi", dbiName)
 
 			if strings.HasPrefix(dbiName, SyncDBIPrefix) {
@@ -403,79 +586,27 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 				continue // skip our own special dbs
 			}
 
-			err := dbiMsg.ValidateTransform(snap.FormatVersion, schemaTracksChanges)
-			if err != nil {
+			if err := s.checkLoadDBI(snap, dbiMsg); err != nil {
 				return err
 			}
+			transform := dbiMsg.Transform()
 
 			ld.Debug("Starting merge of snapshot into DBI")
-			targetDBIName := dbiName
-			if !schemaTracksChanges {
-				targetDBIName = SyncDBIShadowPrefix + dbiName
-
-				// We need to create the actual data DBI too if it does not
-				// exist yet.
-				exists, err := lmdbenv.DBIExists(txn, dbiName)
-				if err != nil {
-					return err
-				}
-				if !exists {
-					if snap.FormatVersion < 3 && dbiOpt.OverrideCreateFlags == nil {
-						// Earlier versions stored the DBI flags from the shadow
-						// DBI instead of the flags from the original DBI.
-						return fmt.Errorf(
-							"DBI %s does not exist yet, and we cannot safely "+
-								"create it from a formatVersion=%d snapshot, "+
-								"only a formatVersion 3+ snapshot contains the "+
-								"information we need for this; you can explicitly "+
-								"override the flags through `override_create_flags` "+
-								"in `dbi_options`, but only attempt this if you "+
-								"are sure you need it",
-							dbiName, snap.FormatVersion)
-					}
-
-					flags := dbiflags.Flags(dbiMsg.Flags())
-					if dbiOpt.OverrideCreateFlags != nil {
-						flags = *dbiOpt.OverrideCreateFlags
-					}
-					ld.WithField("flags", flags).Warn("Creating new DBI from snapshot")
-					_, err := txn.OpenDBI(dbiName, lmdb.Create|uint(flags))
-					if err != nil {
-						return err
-					}
-				}
-			}
-
-			// Create the target DBI if needed
-			exists, err := lmdbenv.DBIExists(txn, targetDBIName)
+			targetDBI, err := s.openLoadTargetDBI(txn, snap, dbiMsg, ld)
 			if err != nil {
 				return err
 			}
-			if !exists {
-				// The formatVersion does not matter here, because the DBI flags
-				// stored in earlier versions will be the correct ones for the
-				// DBI that we are creating here (shadow or native).
-				flags := dbiflags.Flags(dbiMsg.Flags())
-				if dbiOpt.OverrideCreateFlags != nil {
-					flags = *dbiOpt.OverrideCreateFlags
-				}
-				if !schemaTracksChanges {
-					// Only flags like MDB_INTEGERKEY must be transferred
-					// to shadow DBIs.
-					flags &= AllowedShadowDBIFlagsMask
-				}
-				ld.WithField("dbi", targetDBIName).
-					WithField("flags", flags).Warn("Creating new DBI from snapshot")
-				_, err := txn.OpenDBI(targetDBIName, lmdb.Create|uint(flags))
-				if err != nil {
-					return err
-				}
-			}
 
-			// Open the DBI now. It has been created if it did not exist yet.
-			targetDBI, err := txn.OpenDBI(targetDBIName, 0)
-			if err != nil {
-				return err
+			if prepared != nil {
+				if changes, ok := prepared.DBIs[dbiName]; ok {
+					latency := latencies.Observer(instance, dbiName)
+					if err := applyPrepared(ctx, txn, targetDBI, changes, latency, ts); err != nil {
+						return err
+					}
+					ld.WithField("changes", len(changes)).Debug("Applied prepared changes")
+					stats[dbiName] = prepared.Stats[dbiName]
+					continue
+				}
 			}
 
 			it, err := NewNativeIterator(
@@ -484,7 +615,7 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 				dbiMsg,
 				0, // no default timestamp
 				header.TxnID(txn.ID()),
-				s.deletedCutoff(t0),
+				deletedCutoff,
 			)
 			if err != nil {
 				return fmt.Errorf("create native iterator: %w", err)
@@ -492,10 +623,13 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 			if s.lc.HeaderExtraPaddingBlock {
 				it.HeaderPaddingBlock = true
 			}
-			err = strategy.Update(txn, targetDBI, it)
-			if err != nil {
+			it.Latency = latencies.Observer(instance, dbiName)
+			it.ApplyTime = ts
+			it.KeyLimit = s.changedKeyLimit()
+			if err := s.mergeLoadDBI(txn, targetDBI, it, transform, nil); err != nil {
 				return err
 			}
+			stats[dbiName] = it.Stats
 			ld.Debug("Merge successful")
 
 			if utils.IsCanceled(ctx) {
@@ -503,6 +637,7 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 			}
 		}
 		tLoadEnd = time.Now()
+		span.AddEvent("merged")
 
 		// Apply state of shadow dbs to main data
 		tShadow2Start = time.Now()
@@ -521,10 +656,11 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 		return 0, false, err
 	}
 	tLoaded := time.Now()
+	latencies.Flush() // only after the commit
 
 	// If no actual changes were made, LMDB will not record the transaction
 	// and reuse the ID the next time, so we need to adjust the txnID we return.
-	info, err := env.Info()
+	info, err := lmdbenv.Info(env)
 	if err != nil {
 		return 0, false, err
 	}
@@ -550,6 +686,7 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 	l.Info("Loaded remote update")
 
 	l.WithFields(logrus.Fields{
+		"time_prepare":      utils.TimeDiff(tPrepareEnd, tPrepareStart),
 		"time_acquire":      utils.TimeDiff(tTxnAcquire, t0),
 		"time_copy_shadow1": utils.TimeDiff(tShadow1End, tShadow1Start),
 		"time_copy_shadow2": utils.TimeDiff(tShadow2End, tShadow2Start),
@@ -558,7 +695,236 @@ func (s *Syncer) LoadOnce(ctx context.Context, env *lmdb.Env, instance string, u
 		"extra":             update.NameInfo.Extra.String(),
 	}).Debug("Loaded remote update (with timings)")
 
-	s.lastByInstance[instance] = update.NameInfo.Timestamp
+	s.loadDone(instance, update, txnID, localChanged, stats)
 
 	return txnID, localChanged, nil
 }
+
+// skipUnchangedDBIs returns the snapshot without the DBIs that have the same
+// digest as in the last snapshot loaded from the instance. The original
+// snapshot is not modified.
+func (s *Syncer) skipUnchangedDBIs(instance string, snap *snapshot.Snapshot) *snapshot.Snapshot {
+	last := s.lastDigests[instance]
+	if len(last) == 0 {
+		return snap
+	}
+	var databases []*snapshot.DBI
+	for _, dbiMsg := range snap.Databases {
+		name := dbiMsg.Name()
+		digest := snap.Meta.DBIDigest(name)
+		if digest != nil && bytes.Equal(digest, last[name]) {
+			s.l.WithFields(logrus.Fields{
+				"snapshot_instance": instance,
+				"dbi":               name,
+			}).Debug("Skipping unchanged DBI")
+			metricLoadDBIsSkipped.WithLabelValues(s.name).Inc()
+			continue
+		}
+		databases = append(databases, dbiMsg)
+	}
+	if len(databases) == len(snap.Databases) {
+		return snap
+	}
+	filtered := *snap
+	filtered.Databases = databases
+	return &filtered
+}
+
+// loadDone records that the update from the instance was successfully loaded
+// in the given transaction
+func (s *Syncer) loadDone(instance string, update snapshot.Update, txnID header.TxnID, localChanged bool, stats map[string]events.DBIStats) {
+	// The Snapshot is not set for updates that were not loaded from a
+	// snapshot file
+	var meta snapshot.Meta
+	if update.Snapshot != nil {
+		meta = update.Snapshot.Meta
+	}
+
+	s.lastByInstance[instance] = update.NameInfo.Timestamp
+	s.recordLoadStats(instance, stats)
+	s.events.UpdateLoaded.Publish(events.UpdateInfo{
+		NameInfo:     update.NameInfo,
+		Meta:         meta,
+		TxnID:        txnID,
+		Size:         int64(update.BlobSize),
+		LocalChanged: localChanged,
+		DBIStats:     stats,
+	})
+
+	s.statusMu.Lock()
+	s.lastLoaded[instance] = loadedSnapshot{
+		NameInfo: update.NameInfo,
+		LoadedAt: time.Now(),
+		Size:     int64(update.BlobSize),
+		Hostname: meta.Hostname,
+	}
+	s.statusMu.Unlock()
+	if !s.lc.SkipUnchangedDBIs {
+		return
+	}
+
+	// Skipped DBIs keep their digest, because they did not change
+	digests := s.lastDigests[instance]
+	if digests == nil {
+		digests = make(map[string][]byte)
+		s.lastDigests[instance] = digests
+	}
+	for _, dd := range meta.DBIDigests {
+		digests[dd.Name] = dd.SHA256
+	}
+}
+
+// checkLoadDBI checks if a snapshot DBI can be loaded with the local config
+func (s *Syncer) checkLoadDBI(snap *snapshot.Snapshot, dbiMsg *snapshot.DBI) error {
+	dbiName := dbiMsg.Name()
+	err := dbiMsg.ValidateTransform(snap.FormatVersion, s.lc.SchemaTracksChanges)
+	if err != nil {
+		return err
+	}
+	if err := s.checkDBIFlags(dbiName, uint(dbiMsg.Flags())); err != nil {
+		return err
+	}
+	transform := dbiMsg.Transform()
+	if transform != snapshot.TransformNone && transform != s.dupSortTransform() {
+		return fmt.Errorf(
+			"snapshot dbi %q: dupsort transform %q does not match local "+
+				"config (%q), all instances must use the same dupsort option",
+			dbiName, transform, s.dupSortTransform())
+	}
+	return nil
+}
+
+// openLoadTargetDBI opens the DBI to merge a snapshot DBI into, which is the
+// shadow DBI when schema_tracks_changes is disabled. Any missing DBIs are
+// created.
+func (s *Syncer) openLoadTargetDBI(txn *lmdb.Txn, snap *snapshot.Snapshot, dbiMsg *snapshot.DBI, ld logrus.FieldLogger) (lmdb.DBI, error) {
+	dbiName := dbiMsg.Name()
+	dbiOpt := s.lc.DBIOptions[dbiName]
+	schemaTracksChanges := s.lc.SchemaTracksChanges
+	targetDBIName := dbiName
+	if !schemaTracksChanges {
+		targetDBIName = SyncDBIShadowPrefix + dbiName
+
+		// We need to create the actual data DBI too if it does not
+		// exist yet.
+		exists, err := lmdbenv.DBIExists(txn, dbiName)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			if snap.FormatVersion < 3 && dbiOpt.OverrideCreateFlags == nil {
+				// Earlier versions stored the DBI flags from the shadow
+				// DBI instead of the flags from the original DBI.
+				return 0, fmt.Errorf(
+					"DBI %s does not exist yet, and we cannot safely "+
+						"create it from a formatVersion=%d snapshot, "+
+						"only a formatVersion 3+ snapshot contains the "+
+						"information we need for this; you can explicitly "+
+						"override the flags through `override_create_flags` "+
+						"in `dbi_options`, but only attempt this if you "+
+						"are sure you need it",
+					dbiName, snap.FormatVersion)
+			}
+
+			flags := dbiflags.Flags(dbiMsg.Flags())
+			if dbiOpt.OverrideCreateFlags != nil {
+				flags = *dbiOpt.OverrideCreateFlags
+			}
+			ld.WithField("flags", flags).Warn("Creating new DBI from snapshot")
+			_, err := txn.OpenDBI(dbiName, lmdb.Create|uint(flags))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Create the target DBI if needed
+	exists, err := lmdbenv.DBIExists(txn, targetDBIName)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		// The formatVersion does not matter here, because the DBI flags
+		// stored in earlier versions will be the correct ones for the
+		// DBI that we are creating here (shadow or native).
+		flags := dbiflags.Flags(dbiMsg.Flags())
+		if dbiOpt.OverrideCreateFlags != nil {
+			flags = *dbiOpt.OverrideCreateFlags
+		}
+		if !schemaTracksChanges {
+			// Only flags like MDB_INTEGERKEY must be transferred
+			// to shadow DBIs.
+			flags &= AllowedShadowDBIFlagsMask
+		}
+		ld.WithField("dbi", targetDBIName).
+			WithField("flags", flags).Warn("Creating new DBI from snapshot")
+		_, err := txn.OpenDBI(targetDBIName, lmdb.Create|uint(flags))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Open the DBI now. It has been created if it did not exist yet.
+	return txn.OpenDBI(targetDBIName, 0)
+}
+
+// recordLoadStats updates the metrics with the merge stats of a load and
+// logs them per DBI
+func (s *Syncer) recordLoadStats(instance string, stats map[string]events.DBIStats) {
+	for dbiName, st := range stats {
+		for result, n := range map[string]int{
+			"added":     st.Added,
+			"updated":   st.Updated,
+			"deleted":   st.Deleted,
+			"skipped":   st.Skipped,
+			"unchanged": st.Unchanged,
+			"dropped":   st.Dropped,
+		} {
+			metricLoadEntries.WithLabelValues(s.name, instance, dbiName, result).Add(float64(n))
+		}
+		s.l.WithFields(logrus.Fields{
+			"snapshot_instance": instance,
+			"dbi":               dbiName,
+			"added":             st.Added,
+			"updated":           st.Updated,
+			"deleted":           st.Deleted,
+			"skipped":           st.Skipped,
+			"unchanged":         st.Unchanged,
+			"dropped":           st.Dropped,
+		}).Debug("Merged DBI")
+	}
+}
+
+// changedKeyLimit returns the maximum number of changed keys to collect per
+// DBI for the reload trigger, or 0 if it is disabled
+func (s *Syncer) changedKeyLimit() int {
+	if !s.c.Reload.Enabled() {
+		return 0
+	}
+	return s.c.Reload.MaxKeys
+}
+
+// mergeLoadDBI merges a snapshot DBI into its target DBI using the iterator.
+// If limit is not nil, the merge stops when the limit is reached, and can be
+// resumed with the same iterator in a later transaction.
+func (s *Syncer) mergeLoadDBI(txn *lmdb.Txn, targetDBI lmdb.DBI, it *NativeIterator, transform string, limit *loadLimit) error {
+	switch {
+	case transform == snapshot.TransformDupSortSetV1 && s.lc.SchemaTracksChanges:
+		dit := &DupSetIterator{NativeIterator: it}
+		if limit != nil {
+			return strategy.DupUpdate(txn, targetDBI, &limitDupIterator{DupIterator: dit, limit: limit})
+		}
+		return strategy.DupUpdate(txn, targetDBI, dit)
+	case transform == snapshot.TransformDupSortSetV1:
+		sit := &DupSetIterator{NativeIterator: it}
+		if limit != nil {
+			return strategy.Update(txn, targetDBI, &limitIterator{Iterator: sit, limit: limit})
+		}
+		return strategy.Update(txn, targetDBI, sit)
+	default:
+		if limit != nil {
+			return strategy.Update(txn, targetDBI, &limitIterator{Iterator: it, limit: limit})
+		}
+		return strategy.Update(txn, targetDBI, it)
+	}
+}
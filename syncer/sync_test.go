@@ -16,9 +16,12 @@ import (
 	"github.com/PowerDNS/lightningstream/lmdbenv"
 	"github.com/PowerDNS/lightningstream/lmdbenv/header"
 	"github.com/PowerDNS/lightningstream/snapshot"
+	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/PowerDNS/simpleblob"
 	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -352,3 +355,230 @@ func createLMDB(t *testing.T) (env *lmdb.Env, tmpdir string, err error) {
 
 	return env, tmpdir, nil
 }
+
+func TestSyncer_LoadOnce_skipUnchangedDBIs(t *testing.T) {
+	ts := testTS(2)
+
+	newSnap := func(fooVal, barVal string) *snapshot.Snapshot {
+		snap := &snapshot.Snapshot{
+			FormatVersion: snapshot.CurrentFormatVersion,
+			CompatVersion: snapshot.CompatFormatVersion,
+		}
+		for _, kv := range [][2]string{{"foo", fooVal}, {"bar", barVal}} {
+			dbiMsg := snapshot.NewDBI()
+			dbiMsg.SetName(kv[0])
+			dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b(kv[1]), TimestampNano: uint64(ts)})
+			snap.Databases = append(snap.Databases, dbiMsg)
+			snap.Meta.DBIDigests = append(snap.Meta.DBIDigests, snapshot.DBIDigest{
+				Name:   kv[0],
+				SHA256: dbiMsg.Digest(),
+			})
+		}
+		return snap
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{SchemaTracksChanges: true, SkipUnchangedDBIs: true}
+		s, err := New("test", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		txnID, _, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: newSnap("x", "y")}, 0)
+		require.NoError(t, err)
+
+		// Overwrite both values with older versions, which would be
+		// replaced by a merge of the snapshot.
+		err = env.Update(func(txn *lmdb.Txn) error {
+			for _, name := range []string{"foo", "bar"} {
+				dbi, err := txn.OpenDBI(name, 0)
+				require.NoError(t, err)
+				require.NoError(t, txn.Put(dbi, b("a"), b(h(testTS(1), 1, 0)+"old"), 0))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		readA := func(name string) string {
+			var val string
+			err := env.View(func(txn *lmdb.Txn) error {
+				dbi, err := txn.OpenDBI(name, 0)
+				require.NoError(t, err)
+				vals, err := lmdbenv.ReadDBIString(txn, dbi)
+				require.NoError(t, err)
+				require.Len(t, vals, 1)
+				val = vals[0].Val
+				return nil
+			})
+			require.NoError(t, err)
+			return val
+		}
+
+		// Only bar changed, so foo is not merged
+		_, _, err = s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: newSnap("x", "z")}, txnID)
+		require.NoError(t, err)
+		require.Equal(t, h(testTS(1), 1, 0)+"old", readA("foo"))
+		require.Equal(t, h(ts, 3, 0)+"z", readA("bar"))
+
+		// Other instances are tracked separately
+		_, _, err = s.LoadOnce(ctx, env, "other", snapshot.Update{Snapshot: newSnap("x", "z")}, 3)
+		require.NoError(t, err)
+		require.Equal(t, h(ts, 4, 0)+"x", readA("foo"))
+		require.Equal(t, h(ts, 3, 0)+"z", readA("bar"))
+
+		// Updates without a snapshot have no digests to record
+		require.NotPanics(t, func() {
+			s.loadDone("empty", snapshot.Update{}, 5, false, nil)
+		})
+		require.Empty(t, s.lastDigests["empty"])
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_LoadOnce_replicationLatency(t *testing.T) {
+	now := time.Now()
+	ts := header.TimestampFromTime(now.Add(-2 * time.Second))
+
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("new"), TimestampNano: uint64(ts)})
+	dbiMsg.Append(snapshot.KV{Key: b("b"), Value: b("old"), TimestampNano: uint64(ts)})
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+
+	latency := func() (count uint64, sum float64) {
+		var m dto.Metric
+		o := metricReplicationLatency.WithLabelValues("latency", "remote", "foo")
+		require.NoError(t, o.(prometheus.Histogram).Write(&m))
+		return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum()
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{SchemaTracksChanges: true}
+		s, err := New("latency", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		// "b" is newer in the LMDB, so only "a" is applied
+		newer := header.TimestampFromTime(now)
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			return txn.Put(dbi, b("b"), b(h(newer, 1, 0)+"newer"), 0)
+		})
+		require.NoError(t, err)
+
+		txnID, _, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		count, sum := latency()
+		require.Equal(t, uint64(1), count)
+		require.InDelta(t, 2.0, sum, 1.0)
+
+		// Loading it again does not change anything
+		_, _, err = s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, txnID)
+		require.NoError(t, err)
+		count, _ = latency()
+		require.Equal(t, uint64(1), count)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_LoadOnce_stats(t *testing.T) {
+	now := time.Now()
+	ts := header.TimestampFromTime(now.Add(-2 * time.Second))
+
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	dbiMsg.Append(snapshot.KV{Key: b("a"), Value: b("new"), TimestampNano: uint64(ts)})
+	dbiMsg.Append(snapshot.KV{Key: b("b"), Value: b("old"), TimestampNano: uint64(ts)})
+	dbiMsg.Append(snapshot.KV{Key: b("c"), Value: b("update"), TimestampNano: uint64(ts)})
+	dbiMsg.Append(snapshot.KV{Key: b("d"), TimestampNano: uint64(ts), Flags: uint32(header.FlagDeleted)})
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+
+	loaded := func() (n int) {
+		var m dto.Metric
+		c := metricLoadEntries.WithLabelValues("stats", "remote", "foo", "added")
+		require.NoError(t, c.(prometheus.Counter).Write(&m))
+		return int(m.Counter.GetValue())
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		ctx := context.Background()
+		lc := config.LMDB{SchemaTracksChanges: true}
+		s, err := New("stats", env, nil, config.Config{}, lc, Options{})
+		require.NoError(t, err)
+
+		older := header.TimestampFromTime(now.Add(-time.Hour))
+		newer := header.TimestampFromTime(now)
+		err = env.Update(func(txn *lmdb.Txn) error {
+			dbi, err := txn.OpenDBI("foo", lmdb.Create)
+			require.NoError(t, err)
+			require.NoError(t, txn.Put(dbi, b("b"), b(h(newer, 1, 0)+"newer"), 0))
+			require.NoError(t, txn.Put(dbi, b("c"), b(h(older, 1, 0)+"older"), 0))
+			return txn.Put(dbi, b("d"), b(h(older, 1, 0)+"deleted"), 0)
+		})
+		require.NoError(t, err)
+
+		txnID, _, err := s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		info, ok := s.events.UpdateLoaded.Last()
+		require.True(t, ok)
+		require.Equal(t, map[string]events.DBIStats{
+			"foo": {Added: 1, Updated: 1, Deleted: 1, Skipped: 1},
+		}, info.DBIStats)
+		require.Equal(t, 1, loaded())
+
+		// Loading it again does not change anything
+		_, _, err = s.LoadOnce(ctx, env, "remote", snapshot.Update{Snapshot: snap}, txnID)
+		require.NoError(t, err)
+		info, _ = s.events.UpdateLoaded.Last()
+		require.Equal(t, map[string]events.DBIStats{
+			"foo": {Unchanged: 3, Skipped: 1},
+		}, info.DBIStats)
+		require.Equal(t, 1, loaded())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSyncer_LoadOnce_changedKeys(t *testing.T) {
+	ts := header.TimestampFromTime(time.Now())
+
+	dbiMsg := snapshot.NewDBI()
+	dbiMsg.SetName("foo")
+	for _, k := range []string{"a", "b", "c"} {
+		dbiMsg.Append(snapshot.KV{Key: b(k), Value: b("v"), TimestampNano: uint64(ts)})
+	}
+	snap := &snapshot.Snapshot{
+		FormatVersion: snapshot.CurrentFormatVersion,
+		CompatVersion: snapshot.CompatFormatVersion,
+		Databases:     []*snapshot.DBI{dbiMsg},
+	}
+
+	err := lmdbenv.TestEnv(func(env *lmdb.Env) error {
+		c := config.Config{}
+		c.Reload.Socket = "/nonexistent"
+		c.Reload.MaxKeys = 2
+		lc := config.LMDB{SchemaTracksChanges: true}
+		s, err := New("keys", env, nil, c, lc, Options{})
+		require.NoError(t, err)
+
+		_, _, err = s.LoadOnce(context.Background(), env, "remote", snapshot.Update{Snapshot: snap}, 1)
+		require.NoError(t, err)
+		info, ok := s.events.UpdateLoaded.Last()
+		require.True(t, ok)
+		require.Equal(t, map[string]events.DBIStats{
+			"foo": {Added: 3, ChangedKeys: [][]byte{b("a"), b("b")}, KeysTruncated: true},
+		}, info.DBIStats)
+		return nil
+	})
+	require.NoError(t, err)
+}
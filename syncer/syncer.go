@@ -2,11 +2,14 @@ package syncer
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PowerDNS/lightningstream/syncer/cleaner"
 	"github.com/PowerDNS/lightningstream/syncer/events"
 	"github.com/PowerDNS/lightningstream/syncer/hooks"
+	"github.com/PowerDNS/lightningstream/syncer/sweeper"
 	"github.com/PowerDNS/lmdb-go/lmdb"
 	"github.com/PowerDNS/simpleblob"
 	"github.com/sirupsen/logrus"
@@ -19,6 +22,10 @@ import (
 func New(name string, env *lmdb.Env, st simpleblob.Interface, c config.Config, lc config.LMDB, opt Options) (*Syncer, error) {
 	l := logrus.WithField("db", name)
 
+	if err := checkRewrites(lc.Rewrites); err != nil {
+		return nil, err
+	}
+
 	// Start cleaner, but make sure it is disabled if we run in receive-only mode
 	var cleanupConf config.Cleanup
 	if opt.ReceiveOnly {
@@ -28,12 +35,11 @@ func New(name string, env *lmdb.Env, st simpleblob.Interface, c config.Config, l
 	} else {
 		cleanupConf = c.Storage.Cleanup
 	}
-	cl := cleaner.New(name, st, cleanupConf, l)
-
 	ev := opt.Events
 	if ev == nil {
 		ev = events.New()
 	}
+	cl := cleaner.New(name, st, cleanupConf, l, ev)
 	h := opt.Hooks
 	if h == nil {
 		h = hooks.New()
@@ -50,7 +56,11 @@ func New(name string, env *lmdb.Env, st simpleblob.Interface, c config.Config, l
 		events:             ev,
 		hooks:              h,
 		lastByInstance:     make(map[string]time.Time),
+		lastDigests:        make(map[string]map[string][]byte),
+		lastLoaded:         make(map[string]loadedSnapshot),
 		lastSnapshotTime:   time.Time{}, // zero
+		snapshotRequests:   make(chan snapshotRequest),
+		swapRequests:       make(chan compactRequest),
 		cleaner:            cl,
 		storageStoreHealth: healthtracker.New(c.Health.StorageStore, fmt.Sprintf("%s_storage_store", name), "write to storage backend"),
 		startTracker:       starttracker.New(c.Health.Start, name),
@@ -77,7 +87,6 @@ type Syncer struct {
 	opt    Options
 	l      logrus.FieldLogger
 	shadow bool // use shadow database for timestamps?
-	env    *lmdb.Env
 	events *events.Events
 	hooks  *hooks.Hooks
 
@@ -85,6 +94,31 @@ type Syncer struct {
 	// cleaner can make safe decisions about when to remove stale snapshots.
 	lastByInstance map[string]time.Time
 
+	// lastDigests tracks the DBI digests of the last snapshot loaded by
+	// instance, so that unchanged DBIs can be skipped with SkipUnchangedDBIs.
+	lastDigests map[string]map[string][]byte
+
+	// statusMu protects the fields below, which are read by the status API
+	statusMu sync.Mutex
+	// lastLoaded is the last snapshot loaded by instance
+	lastLoaded map[string]loadedSnapshot
+	// waitingFor are the instances we still wait for after startup
+	waitingFor []string
+	// sweeper is set if the sweeper is running
+	sweeper *sweeper.Sweeper
+	// env is replaced when a compacted copy is swapped in, see Env
+	env *lmdb.Env
+
+	// Pause loading or sending, set by the admin API
+	pauseLoad atomic.Bool
+	pauseSend atomic.Bool
+	// snapshotRequests are forced snapshots requested by the admin API,
+	// handled by the sync loop
+	snapshotRequests chan snapshotRequest
+	// swapRequests are compacted copies to swap in requested by the admin
+	// API, handled by the sync loop
+	swapRequests chan compactRequest
+
 	// lastSnapshotTime is the last time we generated a snapshot, used to force
 	// a new one
 	lastSnapshotTime time.Time
@@ -31,10 +31,38 @@ const (
 const (
 	// AllowedShadowDBIFlagsMask is the set of LMDB DBI flags that we transfer
 	// to shadow DBIs.
-	// MDB_INTEGERKEY needs to be transferred for proper ordering of shadow DBIs.
-	AllowedShadowDBIFlagsMask = dbiflags.IntegerKey
+	// MDB_INTEGERKEY and MDB_REVERSEKEY need to be transferred for proper
+	// ordering of shadow DBIs, because their keys must be in the same order
+	// as the keys of the original DBI.
+	// The flags for duplicate values are not transferred, because shadow DBIs
+	// never use MDB_DUPSORT.
+	AllowedShadowDBIFlagsMask = dbiflags.IntegerKey | dbiflags.ReverseKey
 )
 
+// checkDBIFlags checks if the flags of a DBI are supported with the current
+// configuration.
+func (s *Syncer) checkDBIFlags(dbiName string, dbiFlags uint) error {
+	flags := dbiflags.Flags(dbiFlags)
+	if flags&dbiflags.DupSort == 0 {
+		return nil
+	}
+	if s.dupSortTransform() == snapshot.TransformDupSortHackV1 {
+		// The dupsort_hack requires the values to be in lexicographic order
+		// when appended to the key.
+		unsupported := flags & (dbiflags.ReverseKey | dbiflags.IntegerDup | dbiflags.ReverseDup)
+		if unsupported != 0 {
+			return fmt.Errorf("dbi %q: %s not supported by dupsort_hack, use dupsort_set instead",
+				dbiName, unsupported)
+		}
+	}
+	if s.lc.SchemaTracksChanges && flags&dbiflags.IntegerDup > 0 {
+		// Every value has a header, so they cannot be integers
+		return fmt.Errorf("dbi %q: %s not supported with schema_tracks_changes",
+			dbiName, dbiflags.IntegerDup)
+	}
+	return nil
+}
+
 // ErrEntry is returned when an entry is invalid, for example due to a missing
 // or invalid header.
 type ErrEntry struct {
@@ -152,12 +180,20 @@ func (s *Syncer) readDBI(txn *lmdb.Txn, dbiName, origDBIName string, rawValues b
 			return nil, err
 		}
 	}
+	if err := s.checkDBIFlags(origDBIName, dbiFlags); err != nil {
+		return nil, err
+	}
 	isDupSort := dbiFlags&lmdb.DupSort > 0
+	// For dupsort_set, the values of a key in the DBI itself need to be
+	// grouped into a set. Shadow DBIs already contain the set.
+	var groupDups bool
 	if isDupSort {
-		if !s.lc.DupSortHack {
-			return nil, fmt.Errorf("readDBI: dupsort db %q found and dupsort_hack disabled", dbiName)
+		transform := s.dupSortTransform()
+		if transform == snapshot.TransformNone {
+			return nil, fmt.Errorf("readDBI: dupsort db %q found and dupsort_hack and dupsort_set disabled", dbiName)
 		}
-		dbiMsg.SetTransform(snapshot.TransformDupSortHackV1)
+		dbiMsg.SetTransform(transform)
+		groupDups = transform == snapshot.TransformDupSortSetV1 && dbiName == origDBIName
 	}
 	dbiMsg.SetFlags(uint64(dbiFlags))
 
@@ -172,6 +208,15 @@ func (s *Syncer) readDBI(txn *lmdb.Txn, dbiName, origDBIName string, rawValues b
 	filtered := false
 
 	var prev []byte
+	var setKey []byte
+	var setVals []snapshot.DupValue
+	flushSet := func() {
+		if len(setVals) > 0 {
+			dbiMsg.Append(dupSetKV(setKey, setVals))
+		}
+		setVals = setVals[:0]
+	}
+
 	var flag uint = lmdb.First
 	for {
 		key, val, err := c.Get(nil, nil, flag)
@@ -223,6 +268,18 @@ func (s *Syncer) readDBI(txn *lmdb.Txn, dbiName, origDBIName string, rawValues b
 				continue
 			}
 		}
+		if groupDups {
+			if !bytes.Equal(setKey, key) {
+				flushSet()
+				setKey = key
+			}
+			setVals = append(setVals, snapshot.DupValue{
+				Value:         val,
+				TimestampNano: uint64(ts),
+				Flags:         uint32(flags.Masked()),
+			})
+			continue
+		}
 		dbiMsg.Append(snapshot.KV{
 			Key:           key,
 			Value:         val,
@@ -230,6 +287,7 @@ func (s *Syncer) readDBI(txn *lmdb.Txn, dbiName, origDBIName string, rawValues b
 			Flags:         uint32(flags.Masked()),
 		})
 	}
+	flushSet()
 
 	// Check how close our hint was
 	var efficiency float64
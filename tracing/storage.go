@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/PowerDNS/simpleblob"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapStorage returns a storage backend that records a span for every
+// storage operation. The streaming interfaces are always provided, falling
+// back to the buffered implementations of simpleblob if the backend does not
+// implement them.
+func WrapStorage(st simpleblob.Interface) simpleblob.Interface {
+	return &tracedStorage{st: st}
+}
+
+type tracedStorage struct {
+	st simpleblob.Interface
+}
+
+var (
+	_ simpleblob.StreamReader = (*tracedStorage)(nil)
+	_ simpleblob.StreamWriter = (*tracedStorage)(nil)
+)
+
+func (t *tracedStorage) List(ctx context.Context, prefix string) (ls simpleblob.BlobList, err error) {
+	ctx, span := Start(ctx, "storage.List", AttrStoragePath.String(prefix))
+	defer func() {
+		span.SetAttributes(AttrStorageCount.Int(len(ls)))
+		End(span, err)
+	}()
+	return t.st.List(ctx, prefix)
+}
+
+func (t *tracedStorage) Load(ctx context.Context, name string) (data []byte, err error) {
+	ctx, span := Start(ctx, "storage.Load", AttrStorageName.String(name))
+	defer func() {
+		span.SetAttributes(AttrStorageSize.Int(len(data)))
+		End(span, err)
+	}()
+	return t.st.Load(ctx, name)
+}
+
+func (t *tracedStorage) Store(ctx context.Context, name string, data []byte) (err error) {
+	ctx, span := Start(ctx, "storage.Store",
+		AttrStorageName.String(name), AttrStorageSize.Int(len(data)))
+	defer func() {
+		End(span, err)
+	}()
+	return t.st.Store(ctx, name, data)
+}
+
+func (t *tracedStorage) Delete(ctx context.Context, name string) (err error) {
+	ctx, span := Start(ctx, "storage.Delete", AttrStorageName.String(name))
+	defer func() {
+		End(span, err)
+	}()
+	return t.st.Delete(ctx, name)
+}
+
+// NewReader starts a span that ends when the reader is closed
+func (t *tracedStorage) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	ctx, span := Start(ctx, "storage.NewReader", AttrStorageName.String(name))
+	r, err := simpleblob.NewReader(ctx, t.st, name)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+	return &tracedReader{ReadCloser: r, span: span}, nil
+}
+
+// NewWriter starts a span that ends when the writer is closed
+func (t *tracedStorage) NewWriter(ctx context.Context, name string) (io.WriteCloser, error) {
+	ctx, span := Start(ctx, "storage.NewWriter", AttrStorageName.String(name))
+	w, err := simpleblob.NewWriter(ctx, t.st, name)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+	return &tracedWriter{WriteCloser: w, span: span}, nil
+}
+
+type tracedReader struct {
+	io.ReadCloser
+	span trace.Span
+	size int64
+	err  error // first read error other than io.EOF
+}
+
+func (r *tracedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *tracedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.SetAttributes(AttrStorageSize.Int64(r.size))
+	if r.err != nil {
+		End(r.span, r.err)
+	} else {
+		End(r.span, err)
+	}
+	return err
+}
+
+type tracedWriter struct {
+	io.WriteCloser
+	span trace.Span
+	size int64
+	err  error // first write error
+}
+
+func (w *tracedWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.size += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *tracedWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.span.SetAttributes(AttrStorageSize.Int64(w.size))
+	if w.err != nil {
+		End(w.span, w.err)
+	} else {
+		End(w.span, err)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/PowerDNS/simpleblob"
+	"github.com/PowerDNS/simpleblob/backends/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWrapStorage(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	ctx := context.Background()
+	st := WrapStorage(memory.New())
+
+	ctx, parent := Start(ctx, "test")
+	require.NoError(t, st.Store(ctx, "a", []byte("hello")))
+
+	w, err := simpleblob.NewWriter(ctx, st, "b")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world!"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := st.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	r, err := simpleblob.NewReader(ctx, st, "b")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "world!", string(data))
+
+	ls, err := st.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, ls, 2)
+
+	_, err = st.Load(ctx, "missing")
+	assert.Error(t, err)
+
+	require.NoError(t, st.Delete(ctx, "a"))
+	parent.End()
+
+	type result struct {
+		name string
+		attr attribute.KeyValue
+		code codes.Code
+	}
+	var spans []result
+	for _, s := range rec.Ended() {
+		if s.Name() == "test" {
+			continue
+		}
+		assert.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID(), s.Name())
+		res := result{name: s.Name(), code: s.Status().Code}
+		for _, a := range s.Attributes() {
+			switch a.Key {
+			case AttrStorageSize, AttrStorageCount:
+				res.attr = a
+			}
+		}
+		spans = append(spans, res)
+	}
+	assert.Equal(t, []result{
+		{"storage.Store", AttrStorageSize.Int(5), codes.Unset},
+		{"storage.NewWriter", AttrStorageSize.Int64(6), codes.Unset},
+		{"storage.Load", AttrStorageSize.Int(5), codes.Unset},
+		{"storage.NewReader", AttrStorageSize.Int64(6), codes.Unset},
+		{"storage.List", AttrStorageCount.Int(2), codes.Unset},
+		{"storage.Load", AttrStorageSize.Int(0), codes.Error},
+		{"storage.Delete", attribute.KeyValue{}, codes.Unset},
+	}, spans)
+}
@@ -0,0 +1,115 @@
+// Package tracing provides OpenTelemetry tracing of sync cycles and storage
+// operations. Spans are exported to an OTLP collector over HTTP when enabled
+// in the config. Without it, the global no-op tracer is used and creating
+// spans has negligible overhead.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/PowerDNS/lightningstream/config"
+)
+
+// TracerName is the instrumentation name of all our spans
+const TracerName = "github.com/PowerDNS/lightningstream"
+
+// Attribute keys used on spans
+const (
+	AttrDB           = attribute.Key("lightningstream.db")
+	AttrInstance     = attribute.Key("lightningstream.instance")
+	AttrTxnID        = attribute.Key("lightningstream.txn_id")
+	AttrSnapshotName = attribute.Key("lightningstream.snapshot.name")
+	AttrSnapshotSize = attribute.Key("lightningstream.snapshot.size")
+	AttrStorageName  = attribute.Key("lightningstream.storage.name")
+	AttrStoragePath  = attribute.Key("lightningstream.storage.prefix")
+	AttrStorageSize  = attribute.Key("lightningstream.storage.size")
+	AttrStorageCount = attribute.Key("lightningstream.storage.count")
+	AttrTotal        = attribute.Key("lightningstream.total")   // entries considered by cleaner or sweeper
+	AttrCleaned      = attribute.Key("lightningstream.cleaned") // entries removed by cleaner or sweeper
+	AttrFailed       = attribute.Key("lightningstream.failed")
+)
+
+// Setup configures the global tracer provider to export spans to the
+// configured OTLP endpoint. The returned function flushes any pending spans
+// and must be called before exit. If tracing is disabled, Setup does nothing.
+func Setup(ctx context.Context, c config.Config) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	tc := c.Tracing
+	if tc.Endpoint == "" {
+		return shutdown, nil
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(tc.Endpoint),
+	}
+	if tc.URLPath != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(tc.URLPath))
+	}
+	if tc.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(tc.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(tc.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return shutdown, fmt.Errorf("tracing exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("lightningstream"),
+		semconv.ServiceVersion(c.Version),
+		semconv.ServiceInstanceID(c.Instance),
+	))
+	if err != nil {
+		return shutdown, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(tc.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.WithError(err).Warn("Tracing error")
+	}))
+	logrus.WithFields(logrus.Fields{
+		"endpoint":     tc.Endpoint,
+		"sample_ratio": tc.SampleRatio,
+	}).Info("Tracing enabled")
+	return tp.Shutdown, nil
+}
+
+// Tracer returns our tracer from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Start starts a new span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span.
+// This is intended to be deferred with a named error return value.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
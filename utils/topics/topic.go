@@ -106,6 +106,34 @@ func (t *Topic[T]) Handle(ctx context.Context, cb func(T) error) error {
 	}
 }
 
+// HandleUntil subscribes to the topic and calls cb from a new goroutine for
+// every published value until stop is closed. The subscription is made before
+// it returns, so no values published after that are missed. Values that are
+// being published when stop is closed are still passed to cb, because the
+// publisher may hold the lock needed to unsubscribe.
+// The returned channel is closed when the goroutine is done.
+func (t *Topic[T]) HandleUntil(stop <-chan struct{}, cb func(T)) <-chan struct{} {
+	sub := t.Subscribe(false)
+	ch := sub.Channel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case v := <-ch:
+				cb(v)
+			case <-stop:
+				go sub.Close()
+				for v := range ch {
+					cb(v)
+				}
+				return
+			}
+		}
+	}()
+	return done
+}
+
 // unsubscribeID is called by Subscription.Close()
 // It removes a subscription.
 func (t *Topic[T]) unsubscribeID(id subscriptionID) {
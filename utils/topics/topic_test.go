@@ -0,0 +1,26 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopic_HandleUntil(t *testing.T) {
+	topic := New[int]()
+	stop := make(chan struct{})
+	var received []int
+	done := topic.HandleUntil(stop, func(v int) {
+		received = append(received, v)
+	})
+
+	topic.Publish(1)
+	topic.Publish(2)
+	close(stop)
+	<-done
+	assert.Equal(t, []int{1, 2}, received)
+
+	// No longer subscribed, so this does not block
+	topic.Publish(3)
+	assert.Equal(t, []int{1, 2}, received)
+}